@@ -0,0 +1,71 @@
+// Command analyze prints the post-game analysis of a serialized game (see rummikub.GameState.Serialize) as JSON.
+//
+// Usage:
+//
+//	analyze [-compact] [game.json]
+//
+// The game is read from standard input if no file is given.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func main() {
+	compact := flag.Bool("compact", false, "print the report on a single line")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [-compact] [game.json]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if err := analyze(in, os.Stdout, !*compact); err != nil {
+		fail(err)
+	}
+}
+
+// analyze reads a serialized game and writes the analysis report.
+func analyze(in io.Reader, out io.Writer, indent bool) error {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	var game rummikub.GameState
+	if err := json.Unmarshal(data, &game); err != nil {
+		return fmt.Errorf("error deserializing game: %w", err)
+	}
+
+	analysis, err := game.Analyze(rummikub.NewILPSolver(game.Rules))
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(analysis)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
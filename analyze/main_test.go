@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestAnalyze(t *testing.T) {
+	playerA := rummikub.NewHumanPlayer("A")
+	playerA.SetHand([]rummikub.Brick{{Value: 10, Color: "red"}, {Value: 11, Color: "red"}, {Value: 12, Color: "red"}, {Value: 1, Color: "blue"}})
+	playerB := rummikub.NewAIPlayer("B", &rummikub.DummySolver{})
+	playerB.SetHand([]rummikub.Brick{{Value: 1, Color: "yellow"}})
+	game, err := rummikub.NewEmptyGame(rummikub.NewDefaultRules(), playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
+	_, err = game.ProcessMove(rummikub.NewMove("A", []rummikub.BrickCombination{}))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, analyze(bytes.NewReader(game.Serialize()), &out, false))
+
+	var analysis rummikub.GameAnalysis
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &analysis), "report is not valid JSON")
+	if assert.Len(t, analysis.Moves, 1) {
+		assert.True(t, analysis.Moves[0].MissedFirstMove)
+		assert.Equal(t, 33, analysis.Moves[0].ValueLeft)
+	}
+
+	assert.Error(t, analyze(strings.NewReader("not a game"), &out, false))
+}
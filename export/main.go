@@ -0,0 +1,92 @@
+// Command export writes the solver's ILP model of a position to standard output, in CPLEX LP or MPS format
+// (see rummikub.ILPSolver.WriteLP), to inspect it or to solve it with another MIP solver.
+//
+// Usage:
+//
+//	export [-format lp|mps] [position.json]
+//
+// The position is read from standard input if no file is given. It is a JSON object holding the hand, the table and the objective,
+// in the format of the cases logged for invalid solutions (see rummikub.InvalidSolution):
+//
+//	{"rules": {...}, "hand": [...], "table": [...], "maximize_value": false}
+//
+// The default rules are used if none are given.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+// position is the turn to export the model of.
+type position struct {
+	Rules         *rummikub.Rules             `json:"rules"`
+	Hand          []rummikub.Brick            `json:"hand"`
+	Table         []rummikub.BrickCombination `json:"table"`
+	MaximizeValue bool                        `json:"maximize_value"`
+}
+
+func main() {
+	format := flag.String("format", "lp", "the format to write the model in: lp or mps")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [-format lp|mps] [position.json]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if err := export(in, os.Stdout, *format); err != nil {
+		fail(err)
+	}
+}
+
+// export reads the position and writes its model in the format.
+func export(in io.Reader, out io.Writer, format string) error {
+	if format != "lp" && format != "mps" {
+		return fmt.Errorf("unknown format %q: must be lp or mps", format)
+	}
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	var p position
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("error deserializing position: %w", err)
+	}
+
+	rules := rummikub.NewDefaultRules()
+	if p.Rules != nil {
+		rules = *p.Rules
+	}
+	// NewILPSolver panics on invalid rules. The position may be that of any player, so the rules are checked for one.
+	if err := rules.Validate(1); err != nil {
+		return err
+	}
+	solver := rummikub.NewILPSolver(rules)
+
+	if format == "mps" {
+		return solver.WriteMPS(out, p.Hand, p.Table, p.MaximizeValue)
+	}
+	return solver.WriteLP(out, p.Hand, p.Table, p.MaximizeValue)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
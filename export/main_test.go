@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestExport(t *testing.T) {
+	hand := []rummikub.Brick{{Value: 10, Color: "red"}, {Value: 11, Color: "red"}, {Value: 12, Color: "red"}}
+	solver := rummikub.NewILPSolver(rummikub.NewDefaultRules())
+
+	var out, expected bytes.Buffer
+	assert.NoError(t, export(strings.NewReader(`{"hand": [{"value": 10, "color": "red"}, {"value": 11, "color": "red"}, {"value": 12, "color": "red"}]}`), &out, "lp"))
+	assert.NoError(t, solver.WriteLP(&expected, hand, nil, false))
+	assert.Equal(t, expected.String(), out.String())
+
+	// the cases logged for invalid solutions can be exported as they are.
+	c := rummikub.InvalidSolution{Rules: rummikub.NewDefaultRules(), Hand: hand, Table: []rummikub.BrickCombination{}, MaximizeValue: true}
+	data, err := json.Marshal(c)
+	assert.NoError(t, err)
+	out.Reset()
+	expected.Reset()
+	assert.NoError(t, export(bytes.NewReader(data), &out, "mps"))
+	assert.NoError(t, solver.WriteMPS(&expected, hand, nil, true))
+	assert.Equal(t, expected.String(), out.String())
+
+	assert.Error(t, export(strings.NewReader("{}"), &out, "glpk"), "unknown format")
+	assert.Error(t, export(strings.NewReader("not a position"), &out, "lp"))
+	assert.Error(t, export(strings.NewReader(`{"rules": {"values": 0}}`), &out, "lp"), "invalid rules")
+}
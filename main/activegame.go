@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
@@ -13,10 +15,8 @@ import (
 // TODO write test for user inputs (proposed moves)
 // TODO improve type-safety by emphasizing channel directions where possible
 // TODO do we want panic (with recovery) or only soft errors?
-// TODO write a cleanup protocol (storing games and closing connections) that fires when the SERVER is terminated (using a builtin hook of http.Server?)
 // TODO watch out for blockages caused by congested channels
 // TODO keep channels simple by having only one writer
-// TODO implement rate limiting in the readPump
 //see: "- However if you have several senders and several receivers on the "quit" channel, then you have a problem: closing a closed channel will panic."
 
 const (
@@ -35,17 +35,39 @@ const (
 	// the period of the game's heartbeat
 	gameHeartBeatPeriod = 5 * time.Second
 
+	// the version of the message protocol spoken over the game websocket.
+	// Described by the JSON schema in protocol_schema.json, which is served at PROTOCOL_SCHEMA.
+	PROTOCOL_VERSION = 1
+
 	// outgoing message types
-	ERROR_MESSAGE  = "error_message"
-	HAND_UPDATE    = "hand_update"
-	GAME_SNAPSHOT  = "game_snapshot"
-	MOVE_REJECTION = "move_rejected"
+	ERROR_MESSAGE   = "error_message"
+	HAND_UPDATE     = "hand_update"
+	GAME_SNAPSHOT   = "game_snapshot"
+	MOVE_REJECTION  = "move_rejected"
+	MOVE_ACCEPTED   = "move_accepted"
+	HINT            = "hint"
+	RESIGNED        = "resigned"
+	PONG            = "pong"
+	CHAT_MESSAGE    = "chat_message"
+	CHAT_HISTORY    = "chat_history"
+	SERVER_SHUTDOWN = "server_shutdown"
+	GAME_ABORTED    = "game_aborted"
 
 	// incoming messages
-	MOVE_PROPOSAL = "move"
+	MOVE_PROPOSAL    = "move"
+	FORFEIT          = "forfeit"
+	REQUEST_SNAPSHOT = "request_snapshot"
+	REQUEST_HINT     = "request_hint"
+	RESIGN           = "resign"
+	PING             = "ping"
+	CHAT             = "chat"
+	REACTION         = "reaction"
 
 	// error responses
 	UNKNOWN_MESSAGE_TYPE = "unknown message type"
+	UNSUPPORTED_VERSION  = "unsupported protocol version"
+	INVALID_PAYLOAD      = "invalid payload"
+	RATE_LIMITED         = "rate limit exceeded"
 )
 
 // ActiveGame contains a GameState struct and the websocket connections of the involved players.
@@ -76,12 +98,49 @@ type ActiveGame struct {
 	unsubscribe chan *Client
 
 	// the channel used to inactivate the game and all relevant connections without concurrency issues.
-	closer chan bool
+	// Carries a notice to send to the clients before they are unsubscribed (if not nil).
+	closer chan *Envelope
+
+	// functions to be run by the gameManager, e.g. to safely read or save the game state from another goroutine.
+	tasks chan func()
+
+	// closed once the gameManager has returned and the onClose function has been called.
+	done chan struct{}
 
 	// this channel contains the move proposals: i.e. candidate moves pending approval
 	moveCandidates chan MoveProposal
+
+	// this channel contains all other requests made by the clients (snapshots, hints, resignations, pings).
+	clientRequests chan ClientRequest
+
+	// the solver used to compute hints for human players. Built on the first hint that is given, so never if hints are disabled.
+	hintSolver rummikub.Solver
+
+	// held while a hint is computed: one hint is computed at a time (see hint).
+	hintSlot chan struct{}
+
+	// this channel contains the chat messages and reactions to be broadcast.
+	chat chan ChatMessage
+
+	// the most recent chat messages (at most chatHistorySize). Only accessed by the gameManager.
+	chatHistory []ChatMessage
+
+	// whether the game has been won. Used to count each finished game once. Only accessed by the gameManager.
+	finished bool
+
+	// the AI moves computed by the aiWorker, to be played by the gameManager.
+	aiMoves chan aiMoveResult
+
+	// cancels the AI move that is being computed, if any. Only accessed by the gameManager.
+	cancelAIMove context.CancelFunc
+
+	// the minimum time an AI player takes for its move.
+	thinkingDelay time.Duration
 }
 
+// the minimum time an AI player takes for its move, for a more natural feel. Set from AI_THINKING_DELAY_ENV.
+var aiThinkingDelay time.Duration
+
 func (aGame *ActiveGame) IsPlayerSubscribed(name string) bool {
 	aGame.Lock()
 	defer aGame.Unlock()
@@ -90,8 +149,9 @@ func (aGame *ActiveGame) IsPlayerSubscribed(name string) bool {
 }
 
 // ActivateGame wraps a rummikub.GameState into an ActiveGame.
+// The chat history is the history saved when the game was last closed (if any).
 // The cleanup function is called when the game ActiveGame is terminated to allow for removal of any external references.
-func ActivateGame(g *rummikub.GameState, gameID string, cleanupFunc func(aGame *ActiveGame)) *ActiveGame {
+func ActivateGame(g *rummikub.GameState, gameID string, chatHistory []ChatMessage, cleanupFunc func(aGame *ActiveGame)) *ActiveGame {
 	aGame := &ActiveGame{
 		ID:               gameID,
 		gameState:        g,
@@ -100,10 +160,19 @@ func ActivateGame(g *rummikub.GameState, gameID string, cleanupFunc func(aGame *
 		onClose:          cleanupFunc,
 		subscribe:        make(chan *Client),
 		unsubscribe:      make(chan *Client),
-		closer:           make(chan bool),
+		closer:           make(chan *Envelope),
+		tasks:            make(chan func()),
+		done:             make(chan struct{}),
 
 		// TODO double check whether we want this channel to be buffered.
 		moveCandidates: make(chan MoveProposal, 10),
+		clientRequests: make(chan ClientRequest, 10),
+		chat:           make(chan ChatMessage, 10),
+		chatHistory:    chatHistory,
+		finished:       g.HasBeenWon(),
+		aiMoves:        make(chan aiMoveResult),
+		thinkingDelay:  aiThinkingDelay,
+		hintSlot:       make(chan struct{}, 1),
 	}
 
 	// activate the gameManager.
@@ -112,8 +181,14 @@ func ActivateGame(g *rummikub.GameState, gameID string, cleanupFunc func(aGame *
 	return aGame
 }
 
-// A wrapper around the server messages containing an identifier for the type of payload.
+// A wrapper around the messages containing an identifier for the type of payload.
 type Envelope struct {
+	// the protocol version the message adheres to. Messages of other versions are rejected.
+	Version int `json:"version"`
+
+	// chosen by the client for each request. Echoed back in the response(s) to that request.
+	RequestID string `json:"request_id,omitempty"`
+
 	// identifier so the client knows what type of message this is
 	MessageType string      `json:"message_type"`
 	Payload     interface{} `json:"payload"`
@@ -131,6 +206,9 @@ type GameSnapshot struct {
 
 	// whether the game has been won. If true, the winner is the current player.
 	HasBeenWon bool `json:"has_been_won"`
+
+	// the players that have resigned from the game.
+	ResignedPlayers []string `json:"resigned_players"`
 }
 
 // snapshot generates a snapshot of the current ActiveGame to be sent down to the clients.
@@ -140,16 +218,21 @@ func (aGame *ActiveGame) snapshot() *GameSnapshot {
 	defer aGame.Unlock()
 
 	statuses := make(map[string]bool)
+	resigned := []string{}
 	for _, p := range aGame.gameState.Players {
 		//check if the player is subscribed (i.e. a Client exists)
 		_, subbed := aGame.connectedClients[p.Name]
 		statuses[p.Name] = subbed
+		if p.Resigned {
+			resigned = append(resigned, p.Name)
+		}
 	}
 	return &GameSnapshot{
-		aGame.gameState.Table(),
-		aGame.gameState.CurrentPlayer().Name,
-		statuses,
-		aGame.gameState.HasBeenWon(),
+		Table:           aGame.gameState.Table(),
+		CurrentPlayer:   aGame.gameState.CurrentPlayer().Name,
+		PlayerStatuses:  statuses,
+		HasBeenWon:      aGame.gameState.HasBeenWon(),
+		ResignedPlayers: resigned,
 	}
 }
 
@@ -158,8 +241,8 @@ func (aGame *ActiveGame) snapshot() *GameSnapshot {
 func (aGame *ActiveGame) BroadcastPublicGameState() {
 	// get the snapshot and serialize it
 	wrappedSnap := Envelope{
-		GAME_SNAPSHOT,
-		aGame.snapshot(),
+		MessageType: GAME_SNAPSHOT,
+		Payload:     aGame.snapshot(),
 	}
 
 	for _, client := range aGame.connectedClients {
@@ -167,9 +250,71 @@ func (aGame *ActiveGame) BroadcastPublicGameState() {
 	}
 }
 
+// BroadcastChatMessage sends a chat message down to all subscribed clients.
+// The sender receives it as the response to its request.
+func (aGame *ActiveGame) BroadcastChatMessage(msg ChatMessage) {
+	for name, client := range aGame.connectedClients {
+		if name == msg.Sender {
+			client.Reply(msg.requestID, CHAT_MESSAGE, msg)
+		} else {
+			client.Send(Envelope{MessageType: CHAT_MESSAGE, Payload: msg})
+		}
+	}
+}
+
+// ChatHistory returns the most recent chat messages, oldest first. Never nil.
+// Only to be called by the gameManager, or after it has returned (e.g. in the cleanup function).
+func (aGame *ActiveGame) ChatHistory() []ChatMessage {
+	return append([]ChatMessage{}, aGame.chatHistory...)
+}
+
 // gracefully close the game
 func (aGame *ActiveGame) Close() {
-	aGame.closer <- true
+	aGame.closeWithNotice(context.Background(), nil)
+}
+
+// closeWithNotice gracefully closes the game, sending the notice (if not nil) to all clients before they are unsubscribed.
+// Returns immediately if the game has already been closed, or when the context is done.
+// Does not wait for the game to be closed: see Done.
+func (aGame *ActiveGame) closeWithNotice(ctx context.Context, notice *Envelope) {
+	select {
+	case aGame.closer <- notice:
+	case <-aGame.done:
+	case <-ctx.Done():
+	}
+}
+
+// errGameClosed is returned when a task is submitted to a game that has been closed.
+var errGameClosed = errors.New("the game has been closed")
+
+// Do runs the function in the gameManager goroutine, where it has exclusive access to the game, and waits for it to return.
+// Returns errGameClosed if the game has been closed, or the context's error if it is done first.
+func (aGame *ActiveGame) Do(ctx context.Context, f func()) error {
+	finished := make(chan struct{})
+	task := func() {
+		defer close(finished)
+		f()
+	}
+
+	select {
+	case aGame.tasks <- task:
+	case <-aGame.done:
+		return errGameClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once the game has been closed and the cleanup function has returned.
+func (aGame *ActiveGame) Done() <-chan struct{} {
+	return aGame.done
 }
 
 func (aGame *ActiveGame) connectPlayer(connection *websocket.Conn, player *rummikub.Player) {
@@ -181,6 +326,7 @@ func (aGame *ActiveGame) connectPlayer(connection *websocket.Conn, player *rummi
 			"client_name": player.Name,
 		}),
 		make(chan []byte),
+		chatLimiter{},
 	}
 
 	// tell the gameManager to subscribe the player to the game.
@@ -209,8 +355,17 @@ func (aGame *ActiveGame) ReadyToStart() bool {
 // - Handles the graceful termination of the receiver ActiveGame by invoking the provided onClose() function.
 func (aGame *ActiveGame) gameManager() {
 
-	// call the cleanup function when the gameManager returns.
+	// call the cleanup function when the gameManager returns, and signal that it has been called.
+	defer close(aGame.done)
 	defer aGame.onClose(aGame)
+	defer metrics.connectedClients.Delete(aGame.ID)
+
+	// abandon the AI move that is being computed, if any.
+	defer func() {
+		if aGame.cancelAIMove != nil {
+			aGame.cancelAIMove()
+		}
+	}()
 
 	// activate the heartbeat.
 	heartbeat := time.NewTicker(gameHeartBeatPeriod)
@@ -222,20 +377,20 @@ func (aGame *ActiveGame) gameManager() {
 		// centralize write access to the clients map
 		case client := <-aGame.subscribe:
 			aGame.connectedClients[client.player.Name] = client
+			metrics.connectedClients.Set(aGame.ID, float64(len(aGame.connectedClients)))
 			logger.Infof("Player %v subscribed to the game.", client.player.Name)
 
 			// update the players on the game state now that the new player has joined
 			aGame.BroadcastPublicGameState()
 
-			// send the newly connected player the contents of his hand
+			// send the newly connected player the contents of his hand, and the conversation so far
 			client.SyncHandStatus()
+			client.Send(Envelope{MessageType: CHAT_HISTORY, Payload: aGame.ChatHistory()})
 
 			// If all players have joined, start the game by running the AI move
 			if aGame.ReadyToStart() {
-				//TODO make RunAITurns non-recursive so state updates can be synced after every call
 				logger.Info("All players connected. Game ready to start.")
-				aGame.gameState.RunAITurns()
-				aGame.BroadcastPublicGameState()
+				aGame.startAITurn()
 			}
 
 		case client := <-aGame.unsubscribe:
@@ -243,6 +398,7 @@ func (aGame *ActiveGame) gameManager() {
 			playerName := client.player.Name
 			if _, ok := aGame.connectedClients[playerName]; ok {
 				delete(aGame.connectedClients, playerName)
+				metrics.connectedClients.Set(aGame.ID, float64(len(aGame.connectedClients)))
 				close(client.send)
 				logger.Infof("Unsubscribed %v", client.player.Name)
 			}
@@ -259,9 +415,14 @@ func (aGame *ActiveGame) gameManager() {
 
 		//If no clients are subscribed, return this function.
 		// If clients are subscribed, unsubscribe all clients.
-		case <-aGame.closer:
+		case notice := <-aGame.closer:
 			//fmt.Println("closer called")
 			logger.Info("Close channel invoked. Unsubscribing remaining clients...")
+			if notice != nil {
+				for _, client := range aGame.connectedClients {
+					client.Send(*notice)
+				}
+			}
 			if len(aGame.connectedClients) == 0 {
 				logger.Info("No clients are connected. Terminating gameManager routine.")
 				return
@@ -293,28 +454,223 @@ func (aGame *ActiveGame) gameManager() {
 			}
 
 			// check the legality of the move against the game state
-			accepted, reason := aGame.gameState.ProcessMove(move)
+			outcome, err := aGame.gameState.ProcessMove(move)
+			metrics.ObserveMove(outcome, err)
+
+			if err == nil {
+				logger.Infof("Move submitted by %v was accepted (why: %v). Synchronizing game state and running AI turns if applicable...", candidateMove.client.player.Name, outcome)
+				candidateMove.client.Reply(candidateMove.requestID, MOVE_ACCEPTED, MoveAcceptance{outcome})
 
-			if accepted {
-				logger.Infof("Move submitted by %v was accepted (why: %v). Synchronizing game state and running AI turns if applicable...", candidateMove.client.player.Name, reason)
 				// synchronize the new game state to the clients
 				//candidateMove.client.SyncHandStatus()
 				//aGame.BroadcastPublicGameState()
 
-				// run the AI turns if applicable.
-				//TODO handle game cycling in a neater way. Each AI turn should trigger a broadcast.
-				aGame.gameState.RunAITurns()
+				// start the AI turn if applicable. Each AI move is broadcast as it lands.
+				aGame.startAITurn()
 				aGame.BroadcastPublicGameState()
 				candidateMove.client.SyncHandStatus()
 
 			} else {
-				// inform the client of the reason his move was rejected
-				logger.Infof("Move submitted by %v was not accepted for reason: %v", candidateMove.client.player.Name, reason)
-				candidateMove.client.Send(Envelope{MessageType: MOVE_REJECTION, Payload: reason})
+				// inform the client of the reason his move was rejected.
+				// The payload is the *rummikub.RuleViolation, so the client can highlight the offending combination or bricks.
+				logger.Infof("Move submitted by %v was not accepted for reason: %v", candidateMove.client.player.Name, err)
+				candidateMove.client.Reply(candidateMove.requestID, MOVE_REJECTION, err)
+			}
+
+		case request := <-aGame.clientRequests:
+			aGame.handleClientRequest(request)
+
+		case result := <-aGame.aiMoves:
+			aGame.playAIMove(result)
+
+		case task := <-aGame.tasks:
+			task()
+
+		case msg := <-aGame.chat:
+			aGame.chatHistory = appendChatHistory(aGame.chatHistory, msg)
+			aGame.BroadcastChatMessage(msg)
+
+		}
+	}
+}
+
+// handleClientRequest answers the non-move requests of the clients. Only to be called by the gameManager.
+func (aGame *ActiveGame) handleClientRequest(request ClientRequest) {
+	client := request.client
+
+	switch request.messageType {
+	case PING:
+		client.Reply(request.requestID, PONG, nil)
+
+	case REQUEST_SNAPSHOT:
+		client.Reply(request.requestID, GAME_SNAPSHOT, aGame.snapshot())
+		client.Reply(request.requestID, HAND_UPDATE, client.NewHandSnapshot())
+
+	case REQUEST_HINT:
+		aGame.hint(client.player.Name, func(hint *Hint, err error) {
+			// the client may have left while the hint was computed.
+			if aGame.connectedClients[client.player.Name] != client {
+				return
+			}
+			if err != nil {
+				client.logSink.WithField("error", err).Error("Error computing hint.")
+				client.SendError(err.Error(), request.requestID)
+				return
 			}
+			client.Reply(request.requestID, HINT, hint)
+		})
 
+	case RESIGN:
+		if err := aGame.gameState.Resign(client.player.Name); err != nil {
+			client.SendError(err.Error(), request.requestID)
+			return
 		}
+		logger.Infof("Player %v resigned.", client.player.Name)
+		client.Reply(request.requestID, RESIGNED, nil)
+
+		// the resignation may have handed the turn to the AI players.
+		aGame.startAITurn()
+		aGame.BroadcastPublicGameState()
+	}
+}
+
+// startAITurn starts computing the move of the player whose turn it is in the background (see aiWorker),
+// if it is an AI and its move is not being computed yet. Also counts the game as finished once it has been won.
+// Only to be called by the gameManager.
+func (aGame *ActiveGame) startAITurn() {
+	if !aGame.finished && aGame.gameState.HasBeenWon() {
+		aGame.finished = true
+		metrics.gamesFinished.Inc("")
 	}
+
+	if aGame.cancelAIMove != nil {
+		return
+	}
+	request, ok := aGame.gameState.NextAIMove()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	aGame.cancelAIMove = cancel
+	go aGame.aiWorker(ctx, request)
+}
+
+// aiMoveResult is the move computed by the aiWorker.
+type aiMoveResult struct {
+	request    *rummikub.AIMoveRequest
+	playerName string
+	move       rummikub.Move
+	solveTime  time.Duration
+	err        error
+}
+
+// aiWorker computes the AI move and hands it to the gameManager, taking at least the thinking delay.
+// It works on a copy of the game state, so that the gameManager is free to serve the clients in the meantime.
+// Returns without a result once the context is cancelled.
+func (aGame *ActiveGame) aiWorker(ctx context.Context, request *rummikub.AIMoveRequest) {
+	start := time.Now()
+	move, err := request.Solve(ctx)
+	solveTime := time.Since(start)
+
+	if err == nil && solveTime < aGame.thinkingDelay {
+		select {
+		case <-time.After(aGame.thinkingDelay - solveTime):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	select {
+	case aGame.aiMoves <- aiMoveResult{request, request.PlayerName(), move, solveTime, err}:
+	case <-ctx.Done():
+	}
+}
+
+// playAIMove plays the move computed by the aiWorker, broadcasts the new game state and starts the next AI turn (if any).
+// AI players should never produce illegal moves: if the solver failed or its move was not accepted, the AI forfeits its turn instead.
+// Only to be called by the gameManager.
+func (aGame *ActiveGame) playAIMove(result aiMoveResult) {
+	aGame.cancelAIMove()
+	aGame.cancelAIMove = nil
+	log := aGame.logSink.WithField("player_name", result.playerName)
+
+	var (
+		outcome rummikub.Outcome
+		err     = result.err
+	)
+	if err == nil {
+		outcome, err = aGame.gameState.ProcessMove(result.move)
+	}
+
+	switch {
+	case err == nil:
+		log.Infof("AI move accepted (why: %v).", outcome)
+		metrics.ObserveAITurn(rummikub.AITurn{PlayerName: result.playerName, SolveTime: result.solveTime, Outcome: outcome})
+
+	case errors.Is(err, rummikub.NOT_YOUR_TURN) || errors.Is(err, rummikub.GAME_OVER):
+		// the turn has passed while the move was computed, e.g. because a player resigned.
+		log.WithField("error", err).Info("AI move discarded.")
+
+	default:
+		log.WithField("error", err).Error("AI player did not come up with a legal move. Forfeiting its turn.")
+		metrics.ObserveMove(outcome, err)
+		if result.err == nil {
+			// the move was computed, but not accepted: log the position so that it can be reproduced.
+			rummikub.LogInvalidSolution(result.request.RejectedMove(result.move, err))
+		}
+		// the rejected move has been counted: only the forfeit is, once it has been accepted.
+		outcome, err = aGame.gameState.ProcessMove(rummikub.NewMove(result.playerName, aGame.gameState.Table()))
+		if err != nil {
+			log.WithField("error", err).Error("AI player could not forfeit its turn.")
+		} else {
+			metrics.ObserveAITurn(rummikub.AITurn{PlayerName: result.playerName, SolveTime: result.solveTime, Outcome: outcome})
+		}
+	}
+
+	aGame.BroadcastPublicGameState()
+	aGame.startAITurn()
+}
+
+// hint computes a hint for the player using the game's hint solver, which is built on the first hint that is given,
+// and hands it (or the error) to give once it has been counted.
+// The hint is solved in the background on a snapshot of the game (see newHintRequest), so that the gameManager is free to serve
+// the clients in the meantime; give is called by the gameManager, and not at all if the game is closed first.
+// One hint is computed at a time: while it is, other requests are refused with errHintBusy rather than piling up behind it.
+// Only to be called by the gameManager.
+func (aGame *ActiveGame) hint(playerName string, give func(hint *Hint, err error)) {
+	if err := aGame.gameState.CheckHint(playerName); err != nil {
+		give(nil, err)
+		return
+	}
+	select {
+	case aGame.hintSlot <- struct{}{}:
+	default:
+		give(nil, errHintBusy)
+		return
+	}
+
+	if aGame.hintSolver == nil {
+		aGame.hintSolver = rummikub.NewILPSolver(aGame.gameState.Rules)
+	}
+	request, err := newHintRequest(aGame.gameState, playerName, aGame.hintSolver)
+	if err != nil {
+		<-aGame.hintSlot
+		give(nil, err)
+		return
+	}
+
+	go func() {
+		move, err := request.Solve(context.Background())
+		<-aGame.hintSlot
+		aGame.Do(context.Background(), func() {
+			if err != nil {
+				give(nil, err)
+				return
+			}
+			give(recordHint(aGame.gameState, request, move))
+		})
+	}()
 }
 
 type Client struct {
@@ -329,6 +685,9 @@ type Client struct {
 	// this channel feeds directly to the writePump
 	// closing it will kill the Write pump, which kills the read pump as it closes the websocket.
 	send chan []byte
+
+	// limits the rate of chat messages. Only used by the readPump.
+	chatLimiter chatLimiter
 }
 
 type HandSnapshot struct {
@@ -351,35 +710,50 @@ func (c *Client) NewHandSnapshot() HandSnapshot {
 // SyncHandStatus tells the player the contents of his hand
 func (c *Client) SyncHandStatus() {
 	update := Envelope{
-		HAND_UPDATE,
-		c.NewHandSnapshot(),
+		MessageType: HAND_UPDATE,
+		Payload:     c.NewHandSnapshot(),
 	}
 	c.Send(update)
 }
 
+// Reply sends a response to the request with the given ID down to the client.
+func (c *Client) Reply(requestID string, messageType string, payload interface{}) {
+	c.Send(Envelope{
+		RequestID:   requestID,
+		MessageType: messageType,
+		Payload:     payload,
+	})
+}
+
 // Unsubscribe the client from the game, initiating its graceful termination.
+// Returns immediately if the game has already been closed.
 func (c *Client) Unsubscribe() {
-	c.activeGame.unsubscribe <- c
+	select {
+	case c.activeGame.unsubscribe <- c:
+	case <-c.activeGame.done:
+	}
 }
 
 // Send is a convenience method to send arbitrary serialized data to the write pump through the send channel.
+// The message is stamped with the current protocol version.
 func (c *Client) Send(message Envelope) {
+	message.Version = PROTOCOL_VERSION
 	data, err := json.Marshal(message)
 	if err != nil {
 		panic(err)
 	}
+
+	// the time spent waiting for the write pump measures the backpressure of the client.
+	metrics.sendsPending.Inc("")
+	start := time.Now()
 	c.send <- data
+	metrics.sendWait.ObserveDuration(time.Since(start))
+	metrics.sendsPending.Add("", -1)
 }
 
-func (c *Client) SendError(errorMsg string) {
-	data, err := json.Marshal(Envelope{
-		ERROR_MESSAGE,
-		errorMsg,
-	})
-	if err != nil {
-		panic(err)
-	}
-	c.send <- data
+// SendError sends an error message down to the client, in response to the request with the given ID (if any).
+func (c *Client) SendError(errorMsg string, requestID string) {
+	c.Reply(requestID, ERROR_MESSAGE, errorMsg)
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -474,8 +848,21 @@ func (c *Client) writePump() {
 // The move candidate to be processed by the game manager.
 // Note that forfeitures are encoded as empty table slices.
 type MoveProposal struct {
-	table  []rummikub.BrickCombination
-	client *Client
+	table     []rummikub.BrickCombination
+	client    *Client
+	requestID string
+}
+
+// MoveAcceptance is the payload of the response to an accepted move.
+type MoveAcceptance struct {
+	Outcome rummikub.Outcome `json:"outcome"`
+}
+
+// ClientRequest is a request without payload, to be answered by the game manager.
+type ClientRequest struct {
+	messageType string
+	client      *Client
+	requestID   string
 }
 
 func (c *Client) processUserMessage(messageBytes []byte) {
@@ -489,7 +876,13 @@ func (c *Client) processUserMessage(messageBytes []byte) {
 
 	if err := json.Unmarshal(messageBytes, &env); err != nil {
 		sublogger.Error("error unmarshalling message sent by user")
-		c.SendError(UNKNOWN_MESSAGE_TYPE)
+		c.SendError(UNKNOWN_MESSAGE_TYPE, "")
+		return
+	}
+
+	if env.Version != PROTOCOL_VERSION {
+		sublogger.Errorf("Unsupported protocol version sent by user: %v", env.Version)
+		c.SendError(UNSUPPORTED_VERSION, env.RequestID)
 		return
 	}
 
@@ -502,17 +895,50 @@ func (c *Client) processUserMessage(messageBytes []byte) {
 		var tableProposal struct{ Table []rummikub.BrickCombination }
 		if err := json.Unmarshal(msg, &tableProposal); err != nil {
 			sublogger.Error("Error unmarshalling payload of user message.")
-			c.SendError(UNKNOWN_MESSAGE_TYPE)
+			c.SendError(INVALID_PAYLOAD, env.RequestID)
 			return
 		}
 
 		// send the move proposal to the gameManager for evaluation.
-		prop := MoveProposal{table: tableProposal.Table, client: c}
+		prop := MoveProposal{table: tableProposal.Table, client: c, requestID: env.RequestID}
 		c.activeGame.moveCandidates <- prop
 		return
 
+	case FORFEIT:
+		// an explicit forfeiture is an empty move proposal.
+		sublogger.Info("Processing incoming forfeiture.")
+		c.activeGame.moveCandidates <- MoveProposal{client: c, requestID: env.RequestID}
+		return
+
+	case REQUEST_SNAPSHOT, REQUEST_HINT, RESIGN, PING:
+		c.activeGame.clientRequests <- ClientRequest{messageType: env.MessageType, client: c, requestID: env.RequestID}
+		return
+
+	case CHAT, REACTION:
+		if !c.chatLimiter.allow(time.Now()) {
+			sublogger.Warn("Chat message dropped: rate limit exceeded.")
+			c.SendError(RATE_LIMITED, env.RequestID)
+			return
+		}
+
+		var payload ChatPayload
+		if err := json.Unmarshal(msg, &payload); err != nil {
+			sublogger.Error("Error unmarshalling payload of user message.")
+			c.SendError(INVALID_PAYLOAD, env.RequestID)
+			return
+		}
+		chatMsg, ok := newChatMessage(env.MessageType, c.player.Name, payload)
+		if !ok {
+			c.SendError(INVALID_PAYLOAD, env.RequestID)
+			return
+		}
+		chatMsg.requestID = env.RequestID
+
+		c.activeGame.chat <- chatMsg
+		return
+
 	default:
-		c.SendError(UNKNOWN_MESSAGE_TYPE)
+		c.SendError(UNKNOWN_MESSAGE_TYPE, env.RequestID)
 		sublogger.Errorf("Unknown message type sent by user: %v", env.MessageType)
 		return
 	}
@@ -1,7 +1,7 @@
-// +build !race
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus/hooks/test"
@@ -22,18 +22,19 @@ func TestActiveGame_SubscriptionFlow(t *testing.T) {
 	// shortcut a game into the database
 	gamerules := rummikub.NewDefaultRules()
 	playerName := "testplayer"
-	player := rummikub.NewAIPlayer("AIplayer", rummikub.NewILPSolver(gamerules))
+	// the AI never finishes its move, so that the game state does not change while the messages are compared.
+	solver := newBlockingSolver()
+	defer close(solver.release)
+	player := rummikub.NewAIPlayer("AIplayer", solver)
 
 	// initiate the game and store it
-	gamestate := rummikub.NewGame(gamerules, 88, player, rummikub.NewHumanPlayer(playerName))
+	gamestate, err := rummikub.NewGame(gamerules, 88, player, rummikub.NewHumanPlayer(playerName))
+	assert.NoError(t, err, "error initiating game")
 	gameID := gameDB.StoreNewGame(gamestate)
 
-	// initiate the global logger
-	//var hook *test.Hook
-	logger, _ = test.NewNullLogger()
-
 	// run the test server
 	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
 
 	// // subscribe to the game using the subscription endpoint
 	//send the upgrade request; create a new connection
@@ -44,6 +45,8 @@ func TestActiveGame_SubscriptionFlow(t *testing.T) {
 		t.Logf("dial error:", err)
 		assert.Fail(t, "dial failed")
 	}
+	// keep the connection referenced (and thus open) until the end of the test.
+	defer conn.Close()
 	assert.NotNil(t, resp, "response object is nil")
 	assert.Equal(t, 101, resp.StatusCode, "Unexpected status code")
 
@@ -83,16 +86,21 @@ func TestActiveGame_SubscriptionFlow(t *testing.T) {
 	// shortcut the active games to compare the received data with the actual data
 	aGame := activeGamesStore.get(gameID)
 
-	// get the expected values
-	snapshotBytes, _ := json.Marshal(Envelope{
-		GAME_SNAPSHOT,
-		aGame.snapshot(),
-	})
-
-	handBytes, _ := json.Marshal(Envelope{
-		HAND_UPDATE,
-		aGame.connectedClients[playerName].NewHandSnapshot(),
-	})
+	// get the expected values from the gameManager, which owns the game state.
+	var snapshotBytes, handBytes []byte
+	assert.NoError(t, aGame.Do(context.Background(), func() {
+		snapshotBytes, _ = json.Marshal(Envelope{
+			Version:     PROTOCOL_VERSION,
+			MessageType: GAME_SNAPSHOT,
+			Payload:     aGame.snapshot(),
+		})
+
+		handBytes, _ = json.Marshal(Envelope{
+			Version:     PROTOCOL_VERSION,
+			MessageType: HAND_UPDATE,
+			Payload:     aGame.connectedClients[playerName].NewHandSnapshot(),
+		})
+	}), "game is not running")
 
 	// inspect the saved messages and compare with saved values
 	savedMessages := [][]byte{}
@@ -102,13 +110,9 @@ func TestActiveGame_SubscriptionFlow(t *testing.T) {
 	assert.Contains(t, savedMessages, snapshotBytes, "no game snapshot was received.")
 	assert.Contains(t, savedMessages, handBytes, "No player hand was received.")
 
-	// cleanly close the active game
-	aGame.Close()
-
-	// block until the game is closed and thus removed from the activeGamesStore.
-	for activeGamesStore.contains(gameID) {
-		time.Sleep(100)
-	}
+	// cleanly close the active game, and block until it is closed and thus removed from the activeGamesStore.
+	closeGame(aGame)
+	assert.False(t, activeGamesStore.contains(gameID), "game still in activeGameStore")
 
 	// dump the saved messages
 	t.Logf("--Received messages:")
@@ -126,13 +130,14 @@ func TestActiveGame_Heartbeat_Cleanup(t *testing.T) {
 	player := rummikub.NewAIPlayer("AIplayer", rummikub.NewILPSolver(gamerules))
 
 	// initiate the game and store it
-	gamestate := rummikub.NewGame(gamerules, 88, player, rummikub.NewHumanPlayer(playerName))
+	gamestate, err := rummikub.NewGame(gamerules, 88, player, rummikub.NewHumanPlayer(playerName))
+	assert.NoError(t, err, "error initiating game")
 	gameID := gameDB.StoreNewGame(gamestate)
 
 	// // activate the game, but dont connect any players.
 	// hook the cleanup function
 	cleanupCalled := make(chan bool)
-	activeGame := ActivateGame(gamestate, gameID, func(aGame *ActiveGame) {
+	activeGame := ActivateGame(gamestate, gameID, nil, func(aGame *ActiveGame) {
 		t.Log("cleanup function called.")
 		// the cleanup function, invoked after the ActiveGame has been closed.
 		// remove the game from the active games store
@@ -174,9 +179,6 @@ listenLoop:
 }
 
 func TestActiveGame_Full_1v1_GameFlow(t *testing.T) {
-
-	//TODO this test bares a race condition!!!
-
 	// shortcut a game into the database
 	gamerules := rummikub.NewDefaultRules()
 	humanPlayerName := "testplayer"
@@ -184,42 +186,54 @@ func TestActiveGame_Full_1v1_GameFlow(t *testing.T) {
 	// provision the players in the game, initiate the game and store it.
 	AIplayer := rummikub.NewAIPlayer("AIplayer1", rummikub.NewILPSolver(gamerules))
 	humanPlayer := rummikub.NewHumanPlayer(humanPlayerName)
-	gamestate := rummikub.NewGame(gamerules, 88, AIplayer, humanPlayer)
+	gamestate, err := rummikub.NewGame(gamerules, 88, AIplayer, humanPlayer)
+	assert.NoError(t, err, "error initiating game")
 	gameID := gameDB.StoreNewGame(gamestate)
 
-	// initiate the global logger
-	//var hook *test.Hook
-	logsink, hook := test.NewNullLogger()
-	logger = logsink
+	// hook the global logger
+	hook := test.NewLocal(logger)
 
 	// run the test server
 	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
 
 	// // subscribe to the game using the subscription endpoint
 	//send the upgrade request; create a new connection
 	urlRoot := "ws:" + trimHTTPproto(ts.URL) + SUBSCRIBE + "/" + gameID + "/"
 	mockClient := ConnectMockClient(humanPlayerName, urlRoot+humanPlayerName, gamerules, t)
 
-	// loop: asynchronously wait for the player's turn and then let the client play a move
+	// loop: asynchronously wait for the player's turn and then let the client play a move, until the game has been won.
 	go func() {
 		for {
+			select {
 			// wait on the channel that tells us when the mock client GETS TOLD it is his turn.
-			<-mockClient.turnAwaiter
+			case <-mockClient.turnAwaiter:
+			case <-mockClient.done:
+				return
+			}
 			t.Logf("making move")
 			mockClient.MakeMove()
+			if mockClient.GetGameImage().HasBeenWon {
+				return
+			}
 		}
 	}()
 
-	// set a deadline to block the test while we wait for the move to be processed.
-	deadline := make(chan bool)
-	time.AfterFunc(5*time.Second, func() {
-		deadline <- true
-	})
-	<-deadline
+	// poll the client's image of the game until the game has been won, or the deadline expires.
+	deadline := time.Now().Add(60 * time.Second)
+	for !mockClient.GetGameImage().HasBeenWon && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
 
 	// check if the game was won
 	assert.True(t, mockClient.GetGameImage().HasBeenWon, "game has not been won")
 
+	// close the client and the game, so that they do not outlive the test.
+	mockClient.Close()
+	if aGame := activeGamesStore.get(gameID); aGame != nil {
+		closeGame(aGame)
+	}
+
 	// Dump server logs
 	t.Log("---Server logs:")
 	for _, x := range hook.AllEntries() {
@@ -227,3 +241,319 @@ func TestActiveGame_Full_1v1_GameFlow(t *testing.T) {
 	}
 
 }
+
+func TestClient_ProtocolRequests(t *testing.T) {
+
+	// build a game of two human players, without running the gameManager, so that requests can be handled synchronously.
+	gamerules := rummikub.NewDefaultRules()
+	gamestate, err := rummikub.NewGame(gamerules, 88, rummikub.NewHumanPlayer("alice"), rummikub.NewHumanPlayer("bob"))
+	assert.NoError(t, err, "error initiating game")
+
+	aGame := &ActiveGame{
+		gameState:        gamestate,
+		logSink:          logger.WithField("game_id", "testgame"),
+		connectedClients: make(map[string]*Client),
+		moveCandidates:   make(chan MoveProposal, 10),
+		clientRequests:   make(chan ClientRequest, 10),
+	}
+	client := &Client{
+		player:     gamestate.CurrentPlayer(),
+		activeGame: aGame,
+		logSink:    aGame.logSink,
+		send:       make(chan []byte, 10),
+	}
+	aGame.connectedClients[client.player.Name] = client
+
+	send := func(env Envelope) {
+		b, err := json.Marshal(env)
+		assert.NoError(t, err)
+		client.processUserMessage(b)
+	}
+	receive := func() (Envelope, json.RawMessage) {
+		var payload json.RawMessage
+		env := Envelope{Payload: &payload}
+		select {
+		case b := <-client.send:
+			assert.NoError(t, json.Unmarshal(b, &env))
+		default:
+			assert.Fail(t, "no message was sent to the client")
+		}
+		return env, payload
+	}
+	handleRequest := func() {
+		select {
+		case request := <-aGame.clientRequests:
+			aGame.handleClientRequest(request)
+		default:
+			assert.Fail(t, "no request was passed on to the game")
+		}
+	}
+
+	// CASE 1: unsupported protocol version
+	send(Envelope{Version: PROTOCOL_VERSION + 1, RequestID: "1", MessageType: PING})
+	env, payload := receive()
+	assert.Equal(t, ERROR_MESSAGE, env.MessageType)
+	assert.Equal(t, "1", env.RequestID, "request ID not echoed")
+	assert.Equal(t, `"`+UNSUPPORTED_VERSION+`"`, string(payload))
+
+	// CASE 2: unknown message type
+	send(Envelope{Version: PROTOCOL_VERSION, RequestID: "2", MessageType: "dance"})
+	env, _ = receive()
+	assert.Equal(t, ERROR_MESSAGE, env.MessageType)
+	assert.Equal(t, "2", env.RequestID, "request ID not echoed")
+
+	// CASE 3: ping
+	send(Envelope{Version: PROTOCOL_VERSION, RequestID: "3", MessageType: PING})
+	handleRequest()
+	env, _ = receive()
+	assert.Equal(t, PONG, env.MessageType)
+	assert.Equal(t, "3", env.RequestID, "request ID not echoed")
+	assert.Equal(t, PROTOCOL_VERSION, env.Version)
+
+	// CASE 4: snapshot request
+	send(Envelope{Version: PROTOCOL_VERSION, RequestID: "4", MessageType: REQUEST_SNAPSHOT})
+	handleRequest()
+	env, payload = receive()
+	assert.Equal(t, GAME_SNAPSHOT, env.MessageType)
+	assert.Equal(t, "4", env.RequestID, "request ID not echoed")
+	var snap GameSnapshot
+	assert.NoError(t, json.Unmarshal(payload, &snap))
+	assert.Equal(t, "alice", snap.CurrentPlayer)
+	env, _ = receive()
+	assert.Equal(t, HAND_UPDATE, env.MessageType)
+	assert.Equal(t, "4", env.RequestID, "request ID not echoed")
+
+	// CASE 5: malformed move and forfeiture are passed on as move proposals
+	send(Envelope{Version: PROTOCOL_VERSION, RequestID: "5", MessageType: MOVE_PROPOSAL, Payload: "not a table"})
+	env, payload = receive()
+	assert.Equal(t, ERROR_MESSAGE, env.MessageType)
+	assert.Equal(t, `"`+INVALID_PAYLOAD+`"`, string(payload))
+
+	send(Envelope{Version: PROTOCOL_VERSION, RequestID: "6", MessageType: FORFEIT})
+	prop := <-aGame.moveCandidates
+	assert.Empty(t, prop.table, "a forfeiture is an empty proposal")
+	assert.Equal(t, "6", prop.requestID)
+
+	// CASE 6: resignation hands the game to the only remaining player
+	send(Envelope{Version: PROTOCOL_VERSION, RequestID: "7", MessageType: RESIGN})
+	handleRequest()
+	env, _ = receive()
+	assert.Equal(t, RESIGNED, env.MessageType)
+	assert.Equal(t, "7", env.RequestID, "request ID not echoed")
+	env, payload = receive()
+	assert.Equal(t, GAME_SNAPSHOT, env.MessageType)
+	assert.Empty(t, env.RequestID, "broadcasts carry no request ID")
+	assert.NoError(t, json.Unmarshal(payload, &snap))
+	assert.True(t, snap.HasBeenWon)
+	assert.Equal(t, []string{"alice"}, snap.ResignedPlayers)
+
+	// resigning twice is an error
+	send(Envelope{Version: PROTOCOL_VERSION, RequestID: "8", MessageType: RESIGN})
+	handleRequest()
+	env, _ = receive()
+	assert.Equal(t, ERROR_MESSAGE, env.MessageType)
+	assert.Equal(t, "8", env.RequestID, "request ID not echoed")
+}
+
+// blockingSolver forfeits, but only once it is released. Signals every call on the started channel.
+type blockingSolver struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingSolver() *blockingSolver {
+	return &blockingSolver{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (s *blockingSolver) Solve(hand []rummikub.Brick, table []rummikub.BrickCombination, maximizeValue bool) ([]rummikub.BrickCombination, []rummikub.Brick, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return table, []rummikub.Brick{}, nil
+}
+
+// awaitMessage reads messages from the connection until one satisfies the condition. Returns false on timeout.
+func awaitMessage(t *testing.T, conn *websocket.Conn, timeout time.Duration, condition func(messageType string, requestID string, payload json.RawMessage) bool) bool {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		var payload json.RawMessage
+		env := Envelope{Payload: &payload}
+		if err := conn.ReadJSON(&env); err != nil {
+			t.Logf("error reading message: %v", err)
+			return false
+		}
+		if condition(env.MessageType, env.RequestID, payload) {
+			return true
+		}
+	}
+}
+
+// isTurnOf returns a condition matching the game snapshots in which it is the named player's turn.
+func isTurnOf(playerName string) func(string, string, json.RawMessage) bool {
+	return func(messageType string, requestID string, payload json.RawMessage) bool {
+		var snap GameSnapshot
+		return messageType == GAME_SNAPSHOT && json.Unmarshal(payload, &snap) == nil && snap.CurrentPlayer == playerName
+	}
+}
+
+// subscribeToAIGame stores a game in which the AI player (using the solver) moves first, and subscribes its human player.
+func subscribeToAIGame(t *testing.T, ts *httptest.Server, solver rummikub.Solver) (string, *websocket.Conn) {
+	gamestate, err := rummikub.NewGame(rummikub.NewDefaultRules(), 88, rummikub.NewAIPlayer("bot", solver), rummikub.NewHumanPlayer("alice"))
+	assert.NoError(t, err, "error initiating game")
+	gameID := gameDB.StoreNewGame(gamestate)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws:"+trimHTTPproto(ts.URL)+SUBSCRIBE+"/"+gameID+"/alice", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	return gameID, conn
+}
+
+func TestActiveGame_AITurnDoesNotBlock(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	solver := newBlockingSolver()
+	_, conn := subscribeToAIGame(t, ts, solver)
+	defer conn.Close()
+
+	select {
+	case <-solver.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AI turn not started")
+	}
+
+	// the game keeps serving its clients while the AI is thinking.
+	assert.NoError(t, conn.WriteJSON(Envelope{Version: PROTOCOL_VERSION, RequestID: "ping", MessageType: PING}))
+	assert.True(t, awaitMessage(t, conn, time.Second, func(messageType string, requestID string, payload json.RawMessage) bool {
+		return requestID == "ping" && messageType == PONG
+	}), "no pong received while the AI was thinking")
+
+	// the AI move is broadcast as soon as it lands.
+	close(solver.release)
+	assert.True(t, awaitMessage(t, conn, 5*time.Second, isTurnOf("alice")), "AI move not broadcast")
+}
+
+func TestActiveGame_MoveDuringAITurn(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	solver := newBlockingSolver()
+	gameID, conn := subscribeToAIGame(t, ts, solver)
+	defer conn.Close()
+
+	select {
+	case <-solver.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AI turn not started")
+	}
+	aGame := activeGamesStore.get(gameID)
+	if !assert.NotNil(t, aGame, "game is not active") {
+		return
+	}
+	defer closeGame(aGame)
+
+	// a move by the human player while the AI is thinking is rejected, and the AI keeps its turn.
+	assert.NoError(t, conn.WriteJSON(Envelope{Version: PROTOCOL_VERSION, RequestID: "move", MessageType: MOVE_PROPOSAL, Payload: struct {
+		Table []rummikub.BrickCombination `json:"table"`
+	}{}}))
+	assert.True(t, awaitMessage(t, conn, 5*time.Second, func(messageType string, requestID string, payload json.RawMessage) bool {
+		return requestID == "move" && messageType == MOVE_REJECTION
+	}), "the move was not rejected")
+	assert.NoError(t, aGame.Do(context.Background(), func() {
+		assert.Equal(t, "bot", aGame.gameState.CurrentPlayer().Name, "the turn passed on a rejected move")
+	}))
+
+	// the AI move is played once it lands, after which it is the human player's turn.
+	close(solver.release)
+	assert.True(t, awaitMessage(t, conn, 5*time.Second, isTurnOf("alice")), "AI move not broadcast")
+	assert.NoError(t, aGame.Do(context.Background(), func() {
+		assert.Equal(t, "alice", aGame.gameState.CurrentPlayer().Name)
+		if assert.Len(t, aGame.gameState.MoveHistory, 1, "the AI move was not played") {
+			assert.Equal(t, "bot", aGame.gameState.MoveHistory[0].PlayerName)
+		}
+	}))
+}
+
+func TestActiveGame_RejectedAIMove(t *testing.T) {
+	shutdownActiveGames(t)
+	metrics = NewMetrics()
+	activeGamesStore = &ActiveGameStore{runningGames: make(map[string]*ActiveGame)}
+	defer func() {
+		metrics = NewMetrics()
+	}()
+	var logged int
+	defer func(log func(*rummikub.InvalidSolution)) { rummikub.LogInvalidSolution = log }(rummikub.LogInvalidSolution)
+	rummikub.LogInvalidSolution = func(c *rummikub.InvalidSolution) { logged++ }
+
+	// build a game in which the AI moves first, without running the gameManager, so that the move can be played synchronously.
+	gamestate, err := rummikub.NewGame(rummikub.NewDefaultRules(), 88, rummikub.NewAIPlayer("bot", &rummikub.DummySolver{}), rummikub.NewHumanPlayer("alice"))
+	assert.NoError(t, err, "error initiating game")
+	aGame := &ActiveGame{
+		gameState:        gamestate,
+		logSink:          logger.WithField("game_id", "testgame"),
+		connectedClients: make(map[string]*Client),
+		cancelAIMove:     func() {},
+	}
+	request, ok := gamestate.NextAIMove()
+	if !assert.True(t, ok, "not the AI's turn") {
+		return
+	}
+	handSize := len(gamestate.CurrentPlayer().Hand())
+
+	// the AI claims bricks it does not own (there are only two red 13s).
+	red13 := rummikub.Brick{Value: 13, Color: "red"}
+	move := rummikub.NewMove("bot", []rummikub.BrickCombination{rummikub.NewBrickCombination(red13, red13, red13)})
+	aGame.playAIMove(aiMoveResult{request, "bot", move, time.Millisecond, nil})
+
+	// the rejected move is logged and counted once, after which the AI forfeits: it draws a brick, and the turn passes to the human player.
+	assert.Equal(t, 1, logged)
+	assert.Equal(t, 1.0, metrics.moves.Value(string(rummikub.NOT_OWNED)))
+	assert.Equal(t, 1.0, metrics.moves.Value(string(rummikub.FORFEITED)))
+	assert.Len(t, gamestate.Players[0].Hand(), handSize+1)
+	assert.Equal(t, "alice", gamestate.CurrentPlayer().Name)
+}
+
+func TestActiveGame_AITurnCancelledOnClose(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	solver := newBlockingSolver()
+	defer close(solver.release)
+	gameID, conn := subscribeToAIGame(t, ts, solver)
+	defer conn.Close()
+
+	select {
+	case <-solver.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AI turn not started")
+	}
+
+	aGame := activeGamesStore.get(gameID)
+	if !assert.NotNil(t, aGame, "game is not active") {
+		return
+	}
+	aGame.Close()
+	select {
+	case <-aGame.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("game not closed while the AI was thinking")
+	}
+
+	// the abandoned move is never played.
+	assert.Equal(t, "bot", gameDB.GetGame(gameID).CurrentPlayer().Name)
+}
+
+func TestActiveGame_AIThinkingDelay(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	aiThinkingDelay = 300 * time.Millisecond
+	defer func() { aiThinkingDelay = 0 }()
+
+	start := time.Now()
+	_, conn := subscribeToAIGame(t, ts, &rummikub.DummySolver{})
+	defer conn.Close()
+
+	assert.True(t, awaitMessage(t, conn, 5*time.Second, isTurnOf("alice")), "AI move not broadcast")
+	assert.True(t, time.Since(start) >= aiThinkingDelay, "AI move landed before the thinking delay")
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/thisendout/apollo"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+const (
+	// game statuses
+	STATUS_WAITING  = "waiting"  // stored, but no players are connected.
+	STATUS_ACTIVE   = "active"   // players are connected.
+	STATUS_FINISHED = "finished" // the game has been won.
+	STATUS_ABORTED  = "aborted"  // the game has been aborted by an administrator.
+
+	// the environment variable holding the bearer token for the admin endpoints. If not set, the admin endpoints are disabled.
+	ADMIN_TOKEN_ENV = "RUMMIGO_ADMIN_TOKEN"
+
+	// the notice sent to the clients of a game that is aborted.
+	ABORT_NOTICE = "The game has been aborted by an administrator."
+
+	// Note that any consumer of this API should use the provided HTTP status codes as much as possible and avoid relying on these messages.
+	INVALID_STATUS_FILTER = "invalid status filter"
+	GAME_NOT_FINISHED     = "the game has not finished yet"
+	GAME_ALREADY_FINISHED = "the game has already finished"
+	GAME_IS_ACTIVE        = "the game is active. Abort it first."
+	GAME_NOT_ACTIVE       = "the game is not active"
+	GAME_WAS_ABORTED      = "the game has been aborted"
+	ADMIN_API_DISABLED    = "the admin API is disabled"
+	UNAUTHORIZED          = "missing or invalid admin token"
+	ANALYSIS_TIMED_OUT    = "the analysis of the game took too long"
+)
+
+// ANALYSIS_TIMEOUT is the time the analysis of a game may take, before it is given up on.
+const ANALYSIS_TIMEOUT = 30 * time.Second
+
+// the bearer token for the admin endpoints.
+var adminToken string
+
+// PlayerSummary is the public state of a player.
+type PlayerSummary struct {
+	Name     string `json:"name"`
+	Human    bool   `json:"human"`
+	Resigned bool   `json:"resigned"`
+	HandSize int    `json:"hand_size"`
+}
+
+// GameSummary is the public overview of a game, as listed by the API.
+type GameSummary struct {
+	ID            string          `json:"id"`
+	Status        string          `json:"status"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Players       []PlayerSummary `json:"players"`
+	CurrentPlayer string          `json:"current_player"`
+	Winner        string          `json:"winner,omitempty"`
+	Moves         int             `json:"moves"`
+}
+
+// PublicGameState is the state of a game that is visible to everyone: everything but the contents of the hands and the pile.
+type PublicGameState struct {
+	GameSummary
+	Table    []rummikub.BrickCombination `json:"table"`
+	PileSize int                         `json:"pile_size"`
+}
+
+type GameHistory struct {
+	ID    string          `json:"id"`
+	Moves []rummikub.Move `json:"moves"`
+
+	// the number of hints requested by each human player.
+	Hints map[string]int `json:"hints"`
+}
+
+// GameAnalysis is the post-game analysis of a game, as served by the API.
+type GameAnalysis struct {
+	ID string `json:"id"`
+	rummikub.GameAnalysis
+}
+
+type GameScores struct {
+	ID     string         `json:"id"`
+	Winner string         `json:"winner"`
+	Scores map[string]int `json:"scores"`
+}
+
+// readGame runs f on the game with the given ID.
+// Active games are read through their gameManager, so that f never races with a move in progress.
+// Returns false if the game does not exist.
+func readGame(ctx context.Context, id string, f func(game *rummikub.GameState, active bool)) (bool, error) {
+	if aGame := activeGamesStore.get(id); aGame != nil {
+		err := aGame.Do(ctx, func() { f(aGame.gameState, true) })
+		if err != errGameClosed {
+			return true, err
+		}
+		// the game has been closed in the meantime, and saved to the database.
+	}
+
+	game := gameDB.GetGame(id)
+	if game == nil {
+		return false, nil
+	}
+	f(game, false)
+	return true, nil
+}
+
+// summarize produces the public overview of the game.
+func summarize(id string, game *rummikub.GameState, active bool) GameSummary {
+	summary := GameSummary{
+		ID:            id,
+		Status:        STATUS_WAITING,
+		Players:       []PlayerSummary{},
+		CurrentPlayer: game.CurrentPlayer().Name,
+		Moves:         len(game.MoveHistory),
+	}
+	if createdAt, err := IDTimestamp(id); err == nil {
+		summary.CreatedAt = createdAt.UTC()
+	}
+	for _, p := range game.Players {
+		summary.Players = append(summary.Players, PlayerSummary{p.Name, p.Human, p.Resigned, len(p.Hand())})
+	}
+
+	switch {
+	case gameDB.IsAborted(id):
+		summary.Status = STATUS_ABORTED
+	case game.HasBeenWon():
+		summary.Status = STATUS_FINISHED
+		summary.Winner = game.Winner().Name
+	case active:
+		summary.Status = STATUS_ACTIVE
+	}
+	return summary
+}
+
+// writeJSON sends the value down with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", CONTENT_JSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// listGames lists all games, optionally filtered by status and/or player name.
+func listGames(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+	})
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", STATUS_WAITING, STATUS_ACTIVE, STATUS_FINISHED, STATUS_ABORTED:
+	default:
+		http.Error(w, INVALID_STATUS_FILTER, http.StatusBadRequest)
+		log.Error(INVALID_STATUS_FILTER)
+		return
+	}
+	player := r.URL.Query().Get("player")
+
+	games := []GameSummary{}
+	for _, id := range gameDB.ListGameIDs() {
+		var summary GameSummary
+		found, err := readGame(r.Context(), id, func(game *rummikub.GameState, active bool) {
+			summary = summarize(id, game, active)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			log.WithField("error", err).Error("error reading game")
+			return
+		}
+		if !found || (status != "" && summary.Status != status) {
+			continue
+		}
+		if player != "" && !summaryHasPlayer(summary, player) {
+			continue
+		}
+		games = append(games, summary)
+	}
+
+	writeJSON(w, http.StatusOK, games)
+	log.Info("served game list")
+}
+
+func summaryHasPlayer(summary GameSummary, name string) bool {
+	for _, p := range summary.Players {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serveGame reads the game named in the URL and sends down the view produced by the given function.
+func serveGame(w http.ResponseWriter, r *http.Request, view func(id string, game *rummikub.GameState, active bool) (interface{}, int, string)) {
+	gameID := mux.Vars(r)[GAME_RESOURCE]
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+		"game_id": gameID,
+	})
+
+	var (
+		v      interface{}
+		status int
+		errMsg string
+	)
+	found, err := readGame(r.Context(), gameID, func(game *rummikub.GameState, active bool) {
+		v, status, errMsg = view(gameID, game, active)
+	})
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		log.WithField("error", err).Error("error reading game")
+	case !found:
+		http.Error(w, GAME_NOT_FOUND, http.StatusNotFound)
+		log.Error(GAME_NOT_FOUND)
+	case errMsg != "":
+		http.Error(w, errMsg, status)
+		log.Error(errMsg)
+	default:
+		writeJSON(w, status, v)
+		log.Info("served game")
+	}
+}
+
+// getGameState serves the public state of a game.
+func getGameState(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	serveGame(w, r, func(id string, game *rummikub.GameState, active bool) (interface{}, int, string) {
+		return PublicGameState{
+			GameSummary: summarize(id, game, active),
+			Table:       game.Table(),
+			PileSize:    len(game.Pile),
+		}, http.StatusOK, ""
+	})
+}
+
+// getGameHistory serves all moves made in a game, oldest first.
+func getGameHistory(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	serveGame(w, r, func(id string, game *rummikub.GameState, active bool) (interface{}, int, string) {
+		hints := make(map[string]int)
+		for _, p := range game.Players {
+			if p.Human {
+				hints[p.Name] = p.HintsUsed
+			}
+		}
+		return GameHistory{
+			ID:    id,
+			Moves: append([]rummikub.Move{}, game.MoveHistory...),
+			Hints: hints,
+		}, http.StatusOK, ""
+	})
+}
+
+// getGameScores serves the final scores of a finished game.
+func getGameScores(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	serveGame(w, r, func(id string, game *rummikub.GameState, active bool) (interface{}, int, string) {
+		winner := game.Winner()
+		if winner == nil {
+			return nil, http.StatusConflict, GAME_NOT_FINISHED
+		}
+		return GameScores{
+			ID:     id,
+			Winner: winner.Name,
+			Scores: game.Scores(),
+		}, http.StatusOK, ""
+	})
+}
+
+// getGameAnalysis serves the post-game analysis of the moves made by the human players of a finished game.
+// The analysis runs on a copy of the game, so that active games are not held up by the solver.
+func getGameAnalysis(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	gameID := mux.Vars(r)[GAME_RESOURCE]
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+		"game_id": gameID,
+	})
+
+	var game *rummikub.GameState
+	found, err := readGame(r.Context(), gameID, func(g *rummikub.GameState, active bool) {
+		if g.HasBeenWon() {
+			game = rummikub.DeserializeGame(g.Serialize())
+		}
+	})
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		log.WithField("error", err).Error("error reading game")
+		return
+	case !found:
+		http.Error(w, GAME_NOT_FOUND, http.StatusNotFound)
+		log.Error(GAME_NOT_FOUND)
+		return
+	case game == nil:
+		http.Error(w, GAME_NOT_FINISHED, http.StatusConflict)
+		log.Error(GAME_NOT_FINISHED)
+		return
+	}
+
+	// the analysis is given up on once the request is done, or has taken ANALYSIS_TIMEOUT.
+	analysisCtx, cancel := context.WithTimeout(r.Context(), ANALYSIS_TIMEOUT)
+	defer cancel()
+	analysis, err := game.AnalyzeContext(analysisCtx, rummikub.NewILPSolver(game.Rules))
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, ANALYSIS_TIMED_OUT, http.StatusServiceUnavailable)
+		log.Error(ANALYSIS_TIMED_OUT)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.WithField("error", err).Error("error analyzing game")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, GameAnalysis{ID: gameID, GameAnalysis: *analysis})
+	log.Info("served game analysis")
+}
+
+// requireAdmin is the middleware guarding the admin endpoints with the bearer token in ADMIN_TOKEN_ENV.
+func requireAdmin(next apollo.Handler) apollo.Handler {
+	return apollo.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		log := logger.WithFields(logrus.Fields{
+			"user_ip": r.RemoteAddr,
+			"url":     r.URL,
+		})
+
+		if adminToken == "" {
+			http.Error(w, ADMIN_API_DISABLED, http.StatusForbidden)
+			log.Error(ADMIN_API_DISABLED)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, UNAUTHORIZED, http.StatusUnauthorized)
+			log.Error(UNAUTHORIZED)
+			return
+		}
+
+		next.ServeHTTP(ctx, w, r)
+	})
+}
+
+// deleteGame removes a game that is not active from the database.
+func deleteGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	gameID := mux.Vars(r)[GAME_RESOURCE]
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+		"game_id": gameID,
+	})
+
+	if activeGamesStore.contains(gameID) {
+		http.Error(w, GAME_IS_ACTIVE, http.StatusConflict)
+		log.Error(GAME_IS_ACTIVE)
+		return
+	}
+	if !gameDB.DeleteGame(gameID) {
+		http.Error(w, GAME_NOT_FOUND, http.StatusNotFound)
+		log.Error(GAME_NOT_FOUND)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("game deleted")
+}
+
+// abortGame ends a game that has not finished. If the game is active, its players are notified and disconnected,
+// and the response is sent once the game has been saved.
+func abortGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	gameID := mux.Vars(r)[GAME_RESOURCE]
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+		"game_id": gameID,
+	})
+
+	var finished bool
+	found, err := readGame(r.Context(), gameID, func(game *rummikub.GameState, active bool) {
+		finished = game.HasBeenWon()
+	})
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		log.WithField("error", err).Error("error reading game")
+		return
+	case !found:
+		http.Error(w, GAME_NOT_FOUND, http.StatusNotFound)
+		log.Error(GAME_NOT_FOUND)
+		return
+	case finished:
+		http.Error(w, GAME_ALREADY_FINISHED, http.StatusConflict)
+		log.Error(GAME_ALREADY_FINISHED)
+		return
+	}
+
+	gameDB.Abort(gameID)
+
+	// close the game, and wait for it to be saved.
+	if aGame := activeGamesStore.get(gameID); aGame != nil {
+		aGame.closeWithNotice(r.Context(), &Envelope{MessageType: GAME_ABORTED, Payload: ABORT_NOTICE})
+		select {
+		case <-aGame.Done():
+		case <-r.Context().Done():
+			log.Error("request cancelled before the aborted game was closed")
+			return
+		}
+	}
+
+	log.Info("game aborted")
+	getGameState(ctx, w, r)
+}
+
+// saveGame saves the current state of an active game to the database.
+func saveGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	gameID := mux.Vars(r)[GAME_RESOURCE]
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+		"game_id": gameID,
+	})
+
+	aGame := activeGamesStore.get(gameID)
+	if aGame == nil {
+		if gameDB.containsID(gameID) {
+			http.Error(w, GAME_NOT_ACTIVE, http.StatusConflict)
+			log.Error(GAME_NOT_ACTIVE)
+		} else {
+			http.Error(w, GAME_NOT_FOUND, http.StatusNotFound)
+			log.Error(GAME_NOT_FOUND)
+		}
+		return
+	}
+
+	// a copy is saved, which the moves made in the game afterwards do not change.
+	err := aGame.Do(r.Context(), func() {
+		gameDB.SaveGame(gameID, aGame.gameState.Copy())
+		gameDB.SaveChatHistory(gameID, aGame.ChatHistory())
+	})
+	// a game that has been closed in the meantime has been saved by its cleanup function.
+	if err != nil && err != errGameClosed {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		log.WithField("error", err).Error("error saving game")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("game saved")
+}
+
+// openAPI serves the OpenAPI description of the HTTP API.
+func openAPI(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", CONTENT_JSON)
+	http.ServeFile(w, r, "openapi.json")
+
+	logger.WithField("user_ip", r.RemoteAddr).Info("served OpenAPI description")
+}
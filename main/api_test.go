@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+// storeTestGame stores a new game between two human players, alice and bob.
+func storeTestGame(t *testing.T) (string, *rummikub.GameState) {
+	game, err := rummikub.NewGame(rummikub.NewDefaultRules(), 88, rummikub.NewHumanPlayer("alice"), rummikub.NewHumanPlayer("bob"))
+	assert.NoError(t, err, "error initiating game")
+	return gameDB.StoreNewGame(game), game
+}
+
+// adminRequest sends a request to an admin endpoint with the given bearer token (none if empty).
+func adminRequest(t *testing.T, method string, url string, token string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	assert.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "Error sending request to mock server")
+	return resp
+}
+
+func TestAPI_OpenAPIDescribesAllRoutes(t *testing.T) {
+	data, err := ioutil.ReadFile("openapi.json")
+	assert.NoError(t, err)
+
+	var description struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &description), "OpenAPI description is not valid JSON")
+
+	err = buildServeMux().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// websocket endpoints accept any method; they are described as GET.
+			methods = []string{"GET"}
+		}
+
+		operations, ok := description.Paths[path]
+		if !assert.True(t, ok, "route %v not described by the OpenAPI description", path) {
+			return nil
+		}
+		for _, method := range methods {
+			_, ok := operations[strings.ToLower(method)]
+			assert.True(t, ok, "operation %v %v not described by the OpenAPI description", method, path)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestAPI_ListGames(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	waitingID, _ := storeTestGame(t)
+	finishedID, finished := storeTestGame(t)
+	assert.NoError(t, finished.Resign("bob"))
+	gameDB.SaveGame(finishedID, finished)
+
+	list := func(query string) ([]GameSummary, int) {
+		resp, err := http.Get(ts.URL + GAMES_ROOT + query)
+		assert.NoError(t, err, "Error sending request to mock server")
+		defer resp.Body.Close()
+		games := []GameSummary{}
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&games))
+		}
+		return games, resp.StatusCode
+	}
+	ids := func(games []GameSummary) []string {
+		ids := []string{}
+		for _, g := range games {
+			ids = append(ids, g.ID)
+		}
+		return ids
+	}
+
+	games, status := list("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, ids(games), waitingID)
+	assert.Contains(t, ids(games), finishedID)
+
+	games, status = list("?status=" + STATUS_FINISHED)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, ids(games), finishedID)
+	assert.NotContains(t, ids(games), waitingID)
+	for _, g := range games {
+		assert.Equal(t, STATUS_FINISHED, g.Status)
+	}
+
+	games, status = list("?status=" + STATUS_WAITING + "&player=alice")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, ids(games), waitingID)
+	assert.NotContains(t, ids(games), finishedID)
+
+	games, status = list("?player=nobody")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, games)
+
+	_, status = list("?status=bogus")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestAPI_GameResources(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	gameID, game := storeTestGame(t)
+
+	// state
+	resp, err := http.Get(ts.URL + GAMES_ROOT + "/" + gameID)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+	var state PublicGameState
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+	resp.Body.Close()
+	assert.Equal(t, gameID, state.ID)
+	assert.Equal(t, STATUS_WAITING, state.Status)
+	assert.Equal(t, len(game.Pile), state.PileSize)
+	assert.Len(t, state.Players, 2)
+	assert.Equal(t, len(game.GetPlayer("alice").Hand()), state.Players[0].HandSize)
+	assert.WithinDuration(t, time.Now(), state.CreatedAt, time.Minute)
+
+	// the hands themselves are not public
+	raw, err := json.Marshal(state)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), `"hand"`)
+
+	// history
+	resp, err = http.Get(ts.URL + GAMES_ROOT + "/" + gameID + GAMES_HISTORY)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+	var history GameHistory
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	resp.Body.Close()
+	assert.Equal(t, gameID, history.ID)
+	assert.Empty(t, history.Moves)
+
+	// scores are not available before the game has finished
+	resp, err = http.Get(ts.URL + GAMES_ROOT + "/" + gameID + GAMES_SCORES)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Unexpected status code")
+
+	assert.NoError(t, game.Resign("alice"))
+	gameDB.SaveGame(gameID, game)
+
+	resp, err = http.Get(ts.URL + GAMES_ROOT + "/" + gameID + GAMES_SCORES)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+	var scores GameScores
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&scores))
+	resp.Body.Close()
+	assert.Equal(t, "bob", scores.Winner)
+	assert.Equal(t, game.Scores(), scores.Scores)
+
+	// unknown games
+	for _, suffix := range []string{"", GAMES_HISTORY, GAMES_SCORES} {
+		resp, err = http.Get(ts.URL + GAMES_ROOT + "/nonexistantgame" + suffix)
+		assert.NoError(t, err, "Error sending request to mock server")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
+	}
+}
+
+func TestAPI_AdminAuthentication(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+	defer func() { adminToken = "" }()
+
+	gameID, _ := storeTestGame(t)
+	url := ts.URL + GAMES_ROOT + "/" + gameID
+
+	// no token configured: the admin API is disabled
+	adminToken = ""
+	resp := adminRequest(t, http.MethodDelete, url, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Unexpected status code")
+
+	adminToken = "secret"
+	resp = adminRequest(t, http.MethodDelete, url, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Unexpected status code")
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	resp = adminRequest(t, http.MethodDelete, url, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Unexpected status code")
+
+	// nothing has been deleted so far
+	assert.NotNil(t, gameDB.GetGame(gameID))
+}
+
+func TestAPI_DeleteGame(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+	adminToken = "secret"
+	defer func() { adminToken = "" }()
+
+	gameID, _ := storeTestGame(t)
+
+	resp := adminRequest(t, http.MethodDelete, ts.URL+GAMES_ROOT+"/"+gameID, adminToken)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "Unexpected status code")
+	assert.Nil(t, gameDB.GetGame(gameID))
+
+	resp = adminRequest(t, http.MethodDelete, ts.URL+GAMES_ROOT+"/"+gameID, adminToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
+
+	// active games must be aborted first
+	activeID, _ := storeTestGame(t)
+	conn, _, err := websocket.DefaultDialer.Dial("ws:"+trimHTTPproto(ts.URL)+SUBSCRIBE+"/"+activeID+"/alice", nil)
+	if !assert.NoError(t, err, "error subscribing to game") {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(t, conn.ReadJSON(&Envelope{}))
+
+	resp = adminRequest(t, http.MethodDelete, ts.URL+GAMES_ROOT+"/"+activeID, adminToken)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Unexpected status code")
+	assert.NotNil(t, gameDB.GetGame(activeID))
+}
+
+func TestAPI_AbortGame(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+	adminToken = "secret"
+	defer func() { adminToken = "" }()
+
+	gameID, _ := storeTestGame(t)
+	subscribeURL := "ws:" + trimHTTPproto(ts.URL) + SUBSCRIBE + "/" + gameID + "/alice"
+	conn, _, err := websocket.DefaultDialer.Dial(subscribeURL, nil)
+	if !assert.NoError(t, err, "error subscribing to game") {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(t, conn.ReadJSON(&Envelope{}))
+
+	resp := adminRequest(t, http.MethodPost, ts.URL+GAMES_ROOT+"/"+gameID+GAMES_ABORT, adminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+	var state PublicGameState
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+	resp.Body.Close()
+	assert.Equal(t, STATUS_ABORTED, state.Status)
+
+	// the client is notified before its connection is closed.
+	noticed := false
+	for {
+		var env Envelope
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&env); err != nil {
+			break
+		}
+		if env.MessageType == GAME_ABORTED {
+			noticed = true
+		}
+	}
+	assert.True(t, noticed, "client was not notified of the abort")
+	assert.False(t, activeGamesStore.contains(gameID), "aborted game is still active")
+
+	// aborted games cannot be rejoined or aborted again, but can be deleted.
+	_, resp, err = websocket.DefaultDialer.Dial(subscribeURL, nil)
+	assert.Error(t, err, "dial did not fail!")
+	if assert.NotNil(t, resp, "response object is nil") {
+		assert.Equal(t, http.StatusGone, resp.StatusCode, "Unexpected status code")
+	}
+
+	resp = adminRequest(t, http.MethodDelete, ts.URL+GAMES_ROOT+"/"+gameID, adminToken)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "Unexpected status code")
+
+	resp = adminRequest(t, http.MethodPost, ts.URL+GAMES_ROOT+"/nonexistantgame"+GAMES_ABORT, adminToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
+
+	// finished games cannot be aborted
+	finishedID, finished := storeTestGame(t)
+	assert.NoError(t, finished.Resign("bob"))
+	gameDB.SaveGame(finishedID, finished)
+	resp = adminRequest(t, http.MethodPost, ts.URL+GAMES_ROOT+"/"+finishedID+GAMES_ABORT, adminToken)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Unexpected status code")
+}
+
+func TestAPI_SaveGame(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+	adminToken = "secret"
+	defer func() { adminToken = "" }()
+
+	gameID, _ := storeTestGame(t)
+
+	// games without connected players are not active
+	resp := adminRequest(t, http.MethodPost, ts.URL+GAMES_ROOT+"/"+gameID+GAMES_SAVE, adminToken)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Unexpected status code")
+
+	resp = adminRequest(t, http.MethodPost, ts.URL+GAMES_ROOT+"/nonexistantgame"+GAMES_SAVE, adminToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws:"+trimHTTPproto(ts.URL)+SUBSCRIBE+"/"+gameID+"/alice", nil)
+	if !assert.NoError(t, err, "error subscribing to game") {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(t, conn.ReadJSON(&Envelope{}))
+
+	resp = adminRequest(t, http.MethodPost, ts.URL+GAMES_ROOT+"/"+gameID+GAMES_SAVE, adminToken)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "Unexpected status code")
+
+	// the saved game is a copy, which the moves made in the active game afterwards do not change.
+	saved := gameDB.GetGame(gameID)
+	aGame := activeGamesStore.get(gameID)
+	if !assert.NotNil(t, aGame) {
+		return
+	}
+	assert.NoError(t, aGame.Do(context.Background(), func() {
+		assert.False(t, saved == aGame.gameState, "the active game state was saved")
+		assert.Equal(t, aGame.gameState.Serialize(), saved.Serialize())
+	}))
+}
+
+func TestAPI_GameAnalysis(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	// alice holds a run, but the game has not finished yet.
+	gameID, game := storeHintGame(t)
+	resp, err := http.Get(ts.URL + GAMES_ROOT + "/" + gameID + GAMES_ANALYSIS)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Unexpected status code")
+
+	// alice wins by playing the run.
+	outcome, err := game.ProcessMove(rummikub.NewMove("alice", []rummikub.BrickCombination{rummikub.NewBrickCombination(game.GetPlayer("alice").Hand()...)}))
+	assert.NoError(t, err)
+	assert.Equal(t, rummikub.GAME_WON, outcome)
+
+	resp, err = http.Get(ts.URL + GAMES_ROOT + "/" + gameID + GAMES_ANALYSIS)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+	var analysis GameAnalysis
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&analysis))
+	resp.Body.Close()
+
+	assert.Equal(t, gameID, analysis.ID)
+	if assert.Len(t, analysis.Moves, 1) {
+		assert.Equal(t, rummikub.Play{Bricks: 3, Value: 33}, analysis.Moves[0].Played)
+		assert.Equal(t, rummikub.Play{Bricks: 3, Value: 33}, analysis.Moves[0].MostValue)
+		assert.Equal(t, 0, analysis.Moves[0].BricksLeft)
+	}
+	assert.Equal(t, map[string]rummikub.PlayerAnalysis{"alice": {Moves: 1}, "bob": {}}, analysis.Players, "AI players should not be analyzed")
+
+	resp, err = http.Get(ts.URL + GAMES_ROOT + "/unknown" + GAMES_ANALYSIS)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
+}
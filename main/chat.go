@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// the maximum number of chat messages kept per game. Older messages are dropped.
+	chatHistorySize = 100
+
+	// the maximum length (in characters) of a chat message.
+	maxChatMessageLength = 500
+
+	// the maximum length (in characters) of an emoji reaction. Leaves room for multi-codepoint emoji (e.g. flags, skin tones).
+	maxReactionLength = 10
+
+	// each client may send a burst of chatRateBurst chat messages and reactions,
+	// after which it regains one message per chatRatePeriod.
+	chatRateBurst  = 5
+	chatRatePeriod = 2 * time.Second
+)
+
+// ChatMessage is a chat message or an emoji reaction sent by a player. Exactly one of Text and Reaction is set.
+type ChatMessage struct {
+	Sender   string    `json:"sender"`
+	Text     string    `json:"text,omitempty"`
+	Reaction string    `json:"reaction,omitempty"`
+	SentAt   time.Time `json:"sent_at"`
+
+	// the ID of the request that sent the message. Only echoed back to the sender.
+	requestID string
+}
+
+// ChatPayload is the payload of the incoming CHAT and REACTION messages.
+type ChatPayload struct {
+	Text     string `json:"text"`
+	Reaction string `json:"reaction"`
+}
+
+// newChatMessage validates the payload of an incoming CHAT or REACTION message and converts it into a ChatMessage.
+// Returns false if the payload is invalid.
+func newChatMessage(messageType string, sender string, payload ChatPayload) (ChatMessage, bool) {
+	msg := ChatMessage{Sender: sender, SentAt: time.Now().UTC()}
+
+	switch messageType {
+	case CHAT:
+		text := strings.TrimSpace(payload.Text)
+		if text == "" || utf8.RuneCountInString(text) > maxChatMessageLength {
+			return msg, false
+		}
+		msg.Text = text
+
+	case REACTION:
+		reaction := strings.TrimSpace(payload.Reaction)
+		if reaction == "" || utf8.RuneCountInString(reaction) > maxReactionLength {
+			return msg, false
+		}
+		msg.Reaction = reaction
+
+	default:
+		return msg, false
+	}
+
+	return msg, true
+}
+
+// chatLimiter is a token bucket limiting the rate at which a client may send chat messages.
+// The zero value is a full bucket. Not safe for concurrent use: it is only used by the client's readPump.
+type chatLimiter struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// allow reports whether a message may be sent at the given time, consuming a token if so.
+func (l *chatLimiter) allow(now time.Time) bool {
+	if l.lastRefill.IsZero() {
+		l.tokens = chatRateBurst
+	} else {
+		l.tokens += float64(now.Sub(l.lastRefill)) / float64(chatRatePeriod)
+		if l.tokens > chatRateBurst {
+			l.tokens = chatRateBurst
+		}
+	}
+	l.lastRefill = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// appendChatHistory appends the message to the history, dropping the oldest messages if the history exceeds chatHistorySize.
+func appendChatHistory(history []ChatMessage, msg ChatMessage) []ChatMessage {
+	history = append(history, msg)
+	if len(history) > chatHistorySize {
+		history = append([]ChatMessage(nil), history[len(history)-chatHistorySize:]...)
+	}
+	return history
+}
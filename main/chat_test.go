@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestChatLimiter(t *testing.T) {
+	var l chatLimiter
+	now := time.Now()
+
+	// the zero value allows a full burst
+	for i := 0; i < chatRateBurst; i++ {
+		assert.True(t, l.allow(now), "message %v of the burst was not allowed", i)
+	}
+	assert.False(t, l.allow(now), "message exceeding the burst was allowed")
+
+	// a token is regained after each period
+	now = now.Add(chatRatePeriod)
+	assert.True(t, l.allow(now), "token was not regained")
+	assert.False(t, l.allow(now), "more than one token regained")
+
+	// the bucket never exceeds the burst size
+	now = now.Add(100 * chatRatePeriod)
+	for i := 0; i < chatRateBurst; i++ {
+		assert.True(t, l.allow(now))
+	}
+	assert.False(t, l.allow(now), "bucket exceeded the burst size")
+}
+
+func TestAppendChatHistory(t *testing.T) {
+	var history []ChatMessage
+	for i := 0; i < chatHistorySize+10; i++ {
+		history = appendChatHistory(history, ChatMessage{Text: fmt.Sprint(i)})
+	}
+	assert.Len(t, history, chatHistorySize, "history not bounded")
+	assert.Equal(t, "10", history[0].Text, "oldest messages should be dropped first")
+	assert.Equal(t, fmt.Sprint(chatHistorySize+9), history[chatHistorySize-1].Text)
+}
+
+func TestNewChatMessage(t *testing.T) {
+	cases := []struct {
+		messageType string
+		payload     ChatPayload
+		valid       bool
+	}{
+		{CHAT, ChatPayload{Text: "nice move"}, true},
+		{CHAT, ChatPayload{Text: "   "}, false},
+		{CHAT, ChatPayload{Text: strings.Repeat("a", maxChatMessageLength+1)}, false},
+		{CHAT, ChatPayload{Reaction: "👍"}, false},
+		{REACTION, ChatPayload{Reaction: "👍"}, true},
+		{REACTION, ChatPayload{Reaction: "👨‍👩‍👧‍👦"}, true},
+		{REACTION, ChatPayload{Reaction: "not an emoji at all"}, false},
+		{REACTION, ChatPayload{Text: "nice move"}, false},
+		{PING, ChatPayload{Text: "nice move"}, false},
+	}
+	for i, c := range cases {
+		msg, ok := newChatMessage(c.messageType, "alice", c.payload)
+		assert.Equal(t, c.valid, ok, "case %v", i)
+		if ok {
+			assert.Equal(t, "alice", msg.Sender, "case %v", i)
+			assert.False(t, msg.SentAt.IsZero(), "case %v", i)
+		}
+	}
+}
+
+func TestActiveGame_Chat(t *testing.T) {
+
+	gamestate, err := rummikub.NewGame(rummikub.NewDefaultRules(), 88, rummikub.NewHumanPlayer("alice"), rummikub.NewHumanPlayer("bob"))
+	assert.NoError(t, err, "error initiating game")
+
+	// build the game without running the gameManager, so that the chat can be handled synchronously.
+	aGame := &ActiveGame{
+		gameState:        gamestate,
+		logSink:          logger.WithField("game_id", "testgame"),
+		connectedClients: make(map[string]*Client),
+		chat:             make(chan ChatMessage, 10),
+	}
+	clients := map[string]*Client{}
+	for _, name := range []string{"alice", "bob"} {
+		c := &Client{
+			player:     gamestate.GetPlayer(name),
+			activeGame: aGame,
+			logSink:    aGame.logSink,
+			send:       make(chan []byte, 10),
+		}
+		aGame.connectedClients[name] = c
+		clients[name] = c
+	}
+
+	receive := func(c *Client) (Envelope, json.RawMessage) {
+		var payload json.RawMessage
+		env := Envelope{Payload: &payload}
+		select {
+		case b := <-c.send:
+			assert.NoError(t, json.Unmarshal(b, &env))
+		default:
+			assert.Fail(t, "no message was sent to the client")
+		}
+		return env, payload
+	}
+
+	// alice sends a full burst of messages, all of which are broadcast.
+	for i := 0; i < chatRateBurst; i++ {
+		b, _ := json.Marshal(Envelope{Version: PROTOCOL_VERSION, RequestID: fmt.Sprint(i), MessageType: CHAT, Payload: ChatPayload{Text: fmt.Sprint("hello ", i)}})
+		clients["alice"].processUserMessage(b)
+
+		msg := <-aGame.chat
+		aGame.chatHistory = appendChatHistory(aGame.chatHistory, msg)
+		aGame.BroadcastChatMessage(msg)
+
+		// the sender gets the request ID echoed, the other players do not.
+		env, payload := receive(clients["alice"])
+		assert.Equal(t, CHAT_MESSAGE, env.MessageType)
+		assert.Equal(t, fmt.Sprint(i), env.RequestID)
+		env, payload = receive(clients["bob"])
+		assert.Equal(t, CHAT_MESSAGE, env.MessageType)
+		assert.Empty(t, env.RequestID)
+
+		var received ChatMessage
+		assert.NoError(t, json.Unmarshal(payload, &received))
+		assert.Equal(t, "alice", received.Sender)
+		assert.Equal(t, fmt.Sprint("hello ", i), received.Text)
+	}
+
+	// the next one is rate limited.
+	b, _ := json.Marshal(Envelope{Version: PROTOCOL_VERSION, RequestID: "spam", MessageType: REACTION, Payload: ChatPayload{Reaction: "🎉"}})
+	clients["alice"].processUserMessage(b)
+	env, payload := receive(clients["alice"])
+	assert.Equal(t, ERROR_MESSAGE, env.MessageType)
+	assert.Equal(t, "spam", env.RequestID)
+	assert.Equal(t, `"`+RATE_LIMITED+`"`, string(payload))
+	assert.Len(t, aGame.chat, 0, "rate limited message was passed on")
+
+	// the limit is per client.
+	b, _ = json.Marshal(Envelope{Version: PROTOCOL_VERSION, MessageType: REACTION, Payload: ChatPayload{Reaction: "🎉"}})
+	clients["bob"].processUserMessage(b)
+	assert.Len(t, aGame.chat, 1, "reaction was not passed on")
+
+	assert.Len(t, aGame.ChatHistory(), chatRateBurst)
+}
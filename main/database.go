@@ -1,11 +1,15 @@
 package main
 
 import (
-	"crypto/md5"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
 	"fmt"
 	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
-	"io"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,6 +20,43 @@ import (
 type ActiveGameStore struct {
 	runningGames map[string]*ActiveGame
 	sync.Mutex
+
+	// set when the server shuts down. No new subscriptions are accepted from then on.
+	shuttingDown bool
+}
+
+// isShuttingDown reports whether the store is being shut down.
+func (db *ActiveGameStore) isShuttingDown() bool {
+	db.Lock()
+	defer db.Unlock()
+	return db.shuttingDown
+}
+
+// Shutdown stops the store from accepting new subscriptions, closes all active games (sending the notice to their clients)
+// and waits for their cleanup functions to save them.
+// Returns the context's error if the games were not all closed before the context was done.
+func (db *ActiveGameStore) Shutdown(ctx context.Context, notice string) error {
+	db.Lock()
+	db.shuttingDown = true
+	games := make([]*ActiveGame, 0, len(db.runningGames))
+	for _, aGame := range db.runningGames {
+		games = append(games, aGame)
+	}
+	db.Unlock()
+
+	// close the games concurrently, so that a single busy game does not hold up the others.
+	for _, aGame := range games {
+		go aGame.closeWithNotice(ctx, &Envelope{MessageType: SERVER_SHUTDOWN, Payload: notice})
+	}
+
+	for _, aGame := range games {
+		select {
+		case <-aGame.Done():
+		case <-ctx.Done():
+			return fmt.Errorf("not all active games were closed: %v", ctx.Err())
+		}
+	}
+	return nil
 }
 
 func (db *ActiveGameStore) contains(ID string) bool {
@@ -32,6 +73,29 @@ func (db *ActiveGameStore) get(ID string) *ActiveGame {
 	return db.runningGames[ID]
 }
 
+// errShuttingDown is returned by getOrActivate once the store is being shut down.
+var errShuttingDown = errors.New(SERVER_SHUTTING_DOWN)
+
+// getOrActivate returns the active game with the ID, or else the game returned by activate (nil if there is none to activate), which is stored.
+// The check that the store is not shutting down, the lookup and the insert are done under the same lock, so that no game is stored
+// after Shutdown has collected the games to close (where it would never be saved), and no game is activated twice.
+// Returns errShuttingDown once the store is being shut down, also for games that are active already.
+func (db *ActiveGameStore) getOrActivate(ID string, activate func() *ActiveGame) (*ActiveGame, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.shuttingDown {
+		return nil, errShuttingDown
+	}
+	if aGame, ok := db.runningGames[ID]; ok {
+		return aGame, nil
+	}
+	aGame := activate()
+	if aGame != nil {
+		db.runningGames[ID] = aGame
+	}
+	return aGame, nil
+}
+
 func (db *ActiveGameStore) store(game *ActiveGame) {
 	if db.contains(game.ID) {
 		panic("ActiveGame already stored in ActiveGameStore")
@@ -48,11 +112,27 @@ func (db *ActiveGameStore) remove(gameID string) {
 	delete(db.runningGames, gameID)
 }
 
+// count returns the number of active games.
+func (db *ActiveGameStore) count() int {
+	db.Lock()
+	defer db.Unlock()
+	return len(db.runningGames)
+}
+
 // GameDatabase supplies the interface to the archived games.
 // TODO: for now nothing more than an in-memory k:v store.
 type GameDatabase struct {
 	sync.Mutex
 	gameStore map[string]*rummikub.GameState
+
+	// the chat history of each game, saved when the game is closed.
+	chatStore map[string][]ChatMessage
+
+	// the number of random bytes in the IDs of new games. DEFAULT_ID_RANDOM_BYTES if zero (see SetIDRandomBytes).
+	idRandomBytes int
+
+	// the games that have been aborted by an administrator.
+	aborted map[string]bool
 }
 
 func (db *GameDatabase) GetGame(ID string) *rummikub.GameState {
@@ -74,22 +154,137 @@ func (db *GameDatabase) SaveGame(id string, game *rummikub.GameState) {
 	db.gameStore[id] = game
 }
 
+// ListGameIDs returns the IDs of all stored games, oldest first.
+func (db *GameDatabase) ListGameIDs() []string {
+	db.Lock()
+	defer db.Unlock()
+	ids := make([]string, 0, len(db.gameStore))
+	for id := range db.gameStore {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// DeleteGame removes the game and everything saved with it. Returns false if the game was not found.
+func (db *GameDatabase) DeleteGame(id string) bool {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.gameStore[id]; !ok {
+		return false
+	}
+	delete(db.gameStore, id)
+	delete(db.chatStore, id)
+	delete(db.aborted, id)
+	return true
+}
+
+// Abort marks the game as aborted. Aborted games can no longer be played.
+func (db *GameDatabase) Abort(id string) {
+	db.Lock()
+	defer db.Unlock()
+	db.aborted[id] = true
+}
+
+func (db *GameDatabase) IsAborted(id string) bool {
+	db.Lock()
+	defer db.Unlock()
+	return db.aborted[id]
+}
+
+// GetChatHistory returns the chat history saved with the game, or nil if there is none.
+func (db *GameDatabase) GetChatHistory(id string) []ChatMessage {
+	db.Lock()
+	defer db.Unlock()
+	return db.chatStore[id]
+}
+
+func (db *GameDatabase) SaveChatHistory(id string, history []ChatMessage) {
+	db.Lock()
+	defer db.Unlock()
+	db.chatStore[id] = history
+}
+
 // StoreNewGame stores the game under a new ID and returns the ID
 func (db *GameDatabase) StoreNewGame(game *rummikub.GameState) string {
-	// generate an ID for the game. Small built in check ensures ID is unique.
-	gameID := getRandomShortString()
-	for db.containsID(gameID) {
-		gameID = getRandomShortString()
-	}
 	db.Lock()
 	defer db.Unlock()
+
+	// generate an ID for the game. With enough random bytes a collision is practically impossible,
+	// but the check is cheap and performed under the same lock as the write.
+	randomBytes := db.getIDRandomBytes()
+	gameID := NewID(randomBytes)
+	for db.gameStore[gameID] != nil {
+		gameID = NewID(randomBytes)
+	}
 	db.gameStore[gameID] = game
+	metrics.gamesCreated.Inc("")
 	return gameID
 }
 
-// use an md5 hash to produce a random string of a fixed size.
-func getRandomShortString() string {
-	h := md5.New()
-	io.WriteString(h, strconv.FormatInt(time.Now().Unix(), 10))
-	return fmt.Sprintf("%x", h.Sum(nil))[0:6]
+// SetIDRandomBytes sets the number of random bytes in the IDs of new games.
+// Returns an error if there are fewer than MIN_ID_RANDOM_BYTES, which would make the IDs guessable (and, with few enough, make StoreNewGame
+// run out of IDs), or more than MAX_ID_RANDOM_BYTES.
+func (db *GameDatabase) SetIDRandomBytes(n int) error {
+	if n < MIN_ID_RANDOM_BYTES || n > MAX_ID_RANDOM_BYTES {
+		return fmt.Errorf("the number of random bytes in a game ID must be between %v and %v, not %v", MIN_ID_RANDOM_BYTES, MAX_ID_RANDOM_BYTES, n)
+	}
+	db.Lock()
+	defer db.Unlock()
+	db.idRandomBytes = n
+	return nil
+}
+
+// getIDRandomBytes returns the number of random bytes in the IDs of new games, applying the default. Only to be called under the lock.
+func (db *GameDatabase) getIDRandomBytes() int {
+	if db.idRandomBytes < MIN_ID_RANDOM_BYTES {
+		return DEFAULT_ID_RANDOM_BYTES
+	}
+	return db.idRandomBytes
+}
+
+const (
+	// the default number of random bytes in a game ID (80 bits of entropy).
+	DEFAULT_ID_RANDOM_BYTES = 10
+
+	// the bounds on the number of random bytes in a game ID (see GameDatabase.SetIDRandomBytes).
+	MIN_ID_RANDOM_BYTES = 8
+	MAX_ID_RANDOM_BYTES = 64
+
+	// the environment variable holding the number of random bytes in the IDs of new games. DEFAULT_ID_RANDOM_BYTES if not set.
+	ID_RANDOM_BYTES_ENV = "RUMMIGO_ID_RANDOM_BYTES"
+
+	// the number of hexadecimal digits encoding the creation time (in milliseconds since the Unix epoch) in an ID.
+	// Sufficient until the year 2527.
+	idTimestampDigits = 12
+
+	// separates the timestamp from the random part of an ID.
+	idSeparator = "-"
+)
+
+// lower case, unpadded base32 keeps the IDs URL-safe and case-insensitive.
+var idEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// NewID generates an unguessable ID from the given number of cryptographically random bytes,
+// prefixed with the creation time so that IDs sort chronologically (to the millisecond).
+func NewID(randomBytes int) string {
+	b := make([]byte, randomBytes)
+	if _, err := rand.Read(b); err != nil {
+		// the system's source of randomness has failed. Handing out guessable IDs is not an option.
+		panic(err)
+	}
+	ms := time.Now().UnixNano() / int64(time.Millisecond)
+	return fmt.Sprintf("%0*x%v%v", idTimestampDigits, ms, idSeparator, idEncoding.EncodeToString(b))
+}
+
+// IDTimestamp decodes the creation time from an ID generated by NewID.
+func IDTimestamp(id string) (time.Time, error) {
+	if len(id) < idTimestampDigits+len(idSeparator) || !strings.HasPrefix(id[idTimestampDigits:], idSeparator) {
+		return time.Time{}, fmt.Errorf("malformed ID %q", id)
+	}
+	ms, err := strconv.ParseInt(id[:idTimestampDigits], 16, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed ID %q: %v", id, err)
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
 }
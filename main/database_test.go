@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestNewID(t *testing.T) {
+	before := time.Now().Truncate(time.Millisecond)
+	id := NewID(DEFAULT_ID_RANDOM_BYTES)
+	after := time.Now()
+
+	// 16 base32 characters encode 10 random bytes
+	assert.Len(t, id, idTimestampDigits+len(idSeparator)+16)
+	assert.Regexp(t, "^[0-9a-f]{12}-[a-z2-7]{16}$", id)
+	assert.Len(t, NewID(32), idTimestampDigits+len(idSeparator)+52, "ID length not configurable")
+
+	created, err := IDTimestamp(id)
+	assert.NoError(t, err)
+	assert.False(t, created.Before(before), "creation time %v before %v", created, before)
+	assert.False(t, created.After(after), "creation time %v after %v", created, after)
+
+	for _, malformed := range []string{"", "abc", "0123456789ab_cdef", "0123456789xy-cdef"} {
+		_, err := IDTimestamp(malformed)
+		assert.Error(t, err, "malformed ID %q accepted", malformed)
+	}
+
+	// IDs sort chronologically
+	older := NewID(DEFAULT_ID_RANDOM_BYTES)
+	time.Sleep(2 * time.Millisecond)
+	newer := NewID(DEFAULT_ID_RANDOM_BYTES)
+	assert.True(t, older < newer, "%v does not sort before %v", older, newer)
+}
+
+func TestGameDatabase_StoreNewGame_Concurrent(t *testing.T) {
+	db := &GameDatabase{
+		gameStore:     make(map[string]*rummikub.GameState),
+		chatStore:     make(map[string][]ChatMessage),
+		idRandomBytes: DEFAULT_ID_RANDOM_BYTES,
+		aborted:       make(map[string]bool),
+	}
+	game, err := rummikub.NewGame(rummikub.NewDefaultRules(), 88, rummikub.NewHumanPlayer("alice"), rummikub.NewHumanPlayer("bob"))
+	assert.NoError(t, err, "error initiating game")
+
+	// store thousands of games in parallel, all created within the same few milliseconds.
+	const nWorkers, gamesPerWorker = 50, 100
+	ids := make(chan string, nWorkers*gamesPerWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < gamesPerWorker; i++ {
+				ids <- db.StoreNewGame(game)
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	unique := map[string]bool{}
+	sorted := []string{}
+	for id := range ids {
+		assert.False(t, unique[id], "duplicate ID %v", id)
+		unique[id] = true
+		sorted = append(sorted, id)
+		assert.True(t, db.containsID(id), "game %v not stored", id)
+	}
+	assert.Len(t, unique, nWorkers*gamesPerWorker)
+	assert.Len(t, db.gameStore, nWorkers*gamesPerWorker, "games overwritten")
+
+	// sorting by ID sorts by creation time
+	sort.Strings(sorted)
+	var last time.Time
+	for _, id := range sorted {
+		created, err := IDTimestamp(id)
+		assert.NoError(t, err)
+		assert.False(t, created.Before(last), "IDs not in chronological order")
+		last = created
+	}
+}
+
+func TestGameDatabase_SetIDRandomBytes(t *testing.T) {
+	db := &GameDatabase{gameStore: make(map[string]*rummikub.GameState)}
+	game, err := rummikub.NewGame(rummikub.NewDefaultRules(), 88, rummikub.NewHumanPlayer("alice"), rummikub.NewHumanPlayer("bob"))
+	assert.NoError(t, err, "error initiating game")
+
+	// the default applies until the length is set.
+	defaultLength := len(NewID(DEFAULT_ID_RANDOM_BYTES))
+	assert.Len(t, db.StoreNewGame(game), defaultLength)
+
+	assert.NoError(t, db.SetIDRandomBytes(32))
+	assert.True(t, len(db.StoreNewGame(game)) > defaultLength, "the longer ID length is not used")
+
+	// IDs that are too short to be unguessable (or to be unique at all) are rejected, as are absurdly long ones.
+	for _, n := range []int{-1, 0, 1, MIN_ID_RANDOM_BYTES - 1, MAX_ID_RANDOM_BYTES + 1} {
+		assert.Error(t, db.SetIDRandomBytes(n), "%v random bytes accepted", n)
+	}
+	assert.Equal(t, 32, db.idRandomBytes)
+}
@@ -32,9 +32,26 @@ func index(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// protocolSchema serves the JSON schema of the messages exchanged over the game websocket.
+func protocolSchema(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	http.ServeFile(w, r, "protocol_schema.json")
+
+	logger.WithField("user_ip", r.RemoteAddr).Info("served protocol schema")
+}
+
 type NewGameSettings struct {
 	AIplayerNames    []string `json:"ai_player_names"`
 	HumanPlayerNames []string `json:"human_player_names"`
+
+	// whether the human players are denied hints.
+	DisableHints bool `json:"disable_hints"`
+}
+
+// newAIPlayer returns an AI player for a game with the rules. On each turn, it races the ILP solver against the greedy solver
+// (see rummikub.NewDefaultPortfolioSolver): positions on which the ILP solver runs out of time are often solved to optimality by the greedy one.
+func newAIPlayer(name string, rules rummikub.Rules) rummikub.Player {
+	return rummikub.NewAIPlayer(name, rummikub.NewDefaultPortfolioSolver(rules))
 }
 
 func newGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -56,7 +73,7 @@ func newGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 
 	players := []rummikub.Player{}
 	for _, name := range settings.AIplayerNames {
-		players = append(players, rummikub.NewAIPlayer(name, rummikub.NewILPSolver(rules)))
+		players = append(players, newAIPlayer(name, rules))
 	}
 
 	for _, name := range settings.HumanPlayerNames {
@@ -64,11 +81,17 @@ func newGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// initiate a new game
-	game := rummikub.NewGame(
+	game, err := rummikub.NewGame(
 		rules,
 		time.Now().Unix(),
 		players...,
 	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.WithField("error", err).Error("invalid game settings")
+		return
+	}
+	game.HintsDisabled = settings.DisableHints
 
 	// store the new game under a new random ID
 	gameId := gameDB.StoreNewGame(game)
@@ -115,14 +138,14 @@ func getHand(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	// find corresponding resources
 	game := gameDB.GetGame(gameId)
 	if game == nil {
-		http.Error(w, "game not found", http.StatusNoContent)
+		http.Error(w, "game not found", http.StatusNotFound)
 		log.Errorf("game not found")
 		return
 	}
 
 	player := game.GetPlayer(playerName)
 	if player == nil {
-		http.Error(w, "player not found", http.StatusNoContent)
+		http.Error(w, "player not found", http.StatusNotFound)
 		log.Errorf("player not found")
 		return
 	}
@@ -146,6 +169,7 @@ const (
 	NO_HUMAN_PROVISIONED          = "No human player with this name has been provisioned"
 	PLAYER_ALREADY_SUBSCRIBED     = "A player with this name has already subscribed to this game"
 	ERROR_UPGRADING_CONNECTION    = "unexpected error upgrading connection"
+	SERVER_SHUTTING_DOWN          = "The server is shutting down. Try again later."
 )
 
 // connect to a certain game by ID using a websocket.
@@ -178,21 +202,24 @@ func subscribeToGame(ctx context.Context, w http.ResponseWriter, r *http.Request
 		"player_name": playAs,
 	})
 
+	// aborted games can no longer be played.
+	if gameDB.IsAborted(gameID) {
+		http.Error(w, GAME_WAS_ABORTED, http.StatusGone)
+		log.Error(GAME_WAS_ABORTED)
+		return
+	}
+
 	// // find corresponding game.
-	// check if the game is already started. If so, reject the subscription.
-	// If the game is not present in the ActiveGameStore, check if it exists in archived form in the database.
-	var activeGame *ActiveGame
-	activeGame = activeGamesStore.get(gameID)
-	if activeGame == nil {
+	// If the game is not present in the ActiveGameStore, check if it exists in archived form in the database, and activate it.
+	// New subscriptions are refused while the active games are being saved.
+	activeGame, err := activeGamesStore.getOrActivate(gameID, func() *ActiveGame {
 		// pull the game from the database
 		game := gameDB.GetGame(gameID)
 		if game == nil {
-			http.Error(w, GAME_NOT_FOUND, http.StatusNoContent)
-			log.Errorf(GAME_NOT_FOUND)
-			return
+			return nil
 		}
 		// activate the game.
-		activeGame = ActivateGame(game, gameID, func(aGame *ActiveGame) {
+		activeGame := ActivateGame(game, gameID, gameDB.GetChatHistory(gameID), func(aGame *ActiveGame) {
 			// the cleanup function, invoked after the ActiveGame has been closed.
 
 			// remove the game from the active games store
@@ -200,27 +227,35 @@ func subscribeToGame(ctx context.Context, w http.ResponseWriter, r *http.Request
 
 			// store the (updated) GameState in the long-term storage.
 			gameDB.SaveGame(gameID, aGame.gameState)
+			gameDB.SaveChatHistory(gameID, aGame.ChatHistory())
 
 			log.Info("Game inactivated")
 		})
-
-		// store the ActiveGame in the global store
-		activeGamesStore.store(activeGame)
-
 		log.Info("Game activated")
+		return activeGame
+	})
+	if err != nil {
+		http.Error(w, SERVER_SHUTTING_DOWN, http.StatusServiceUnavailable)
+		log.Error(SERVER_SHUTTING_DOWN)
+		return
+	}
+	if activeGame == nil {
+		http.Error(w, GAME_NOT_FOUND, http.StatusNotFound)
+		log.Errorf(GAME_NOT_FOUND)
+		return
 	}
 
 	// check if the player name has been provisioned (human players only)
 	player := activeGame.gameState.GetPlayer(playAs)
 	if player == nil || !player.Human {
-		http.Error(w, NO_HUMAN_PROVISIONED, http.StatusPartialContent)
+		http.Error(w, NO_HUMAN_PROVISIONED, http.StatusNotFound)
 		log.Error(NO_HUMAN_PROVISIONED)
 		return
 	}
 
 	// check if the player has already been subscribed.
 	if activeGame.IsPlayerSubscribed(playAs) {
-		http.Error(w, PLAYER_ALREADY_SUBSCRIBED, http.StatusPartialContent)
+		http.Error(w, PLAYER_ALREADY_SUBSCRIBED, http.StatusConflict)
 		log.Error(PLAYER_ALREADY_SUBSCRIBED)
 		return
 	}
@@ -241,3 +276,96 @@ func subscribeToGame(ctx context.Context, w http.ResponseWriter, r *http.Request
 	// return 101 in case of success (default)
 	return
 }
+
+type OpenGameSettings struct {
+	Seats int `json:"seats"`
+}
+
+// openGame adds a game with empty seats to the lobby.
+func openGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+	})
+
+	var settings OpenGameSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "error deserializing json", http.StatusBadRequest)
+		log.Errorf("error deserializing json")
+		return
+	}
+
+	og, err := lobby.Open(settings.Seats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.WithField("error", err).Error("invalid lobby settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", CONTENT_JSON)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(og)
+
+	log.WithField("lobby_id", og.ID).Info("game opened in lobby")
+}
+
+// listOpenGames lists the games in the lobby.
+func listOpenGames(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", CONTENT_JSON)
+	json.NewEncoder(w).Encode(lobby.List())
+
+	logger.WithField("user_ip", r.RemoteAddr).Info("served lobby")
+}
+
+// joinOpenGame claims a seat at an open game. Once the game has started, the response contains the game ID to subscribe to.
+func joinOpenGame(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	lobbyID, playerName := vars[LOBBY_RESOURCE], vars[PLAYER_RESOURCE]
+
+	log := logger.WithFields(logrus.Fields{
+		"user_ip":     r.RemoteAddr,
+		"url":         r.URL,
+		"lobby_id":    lobbyID,
+		"player_name": playerName,
+	})
+
+	og, err := lobby.Join(lobbyID, playerName)
+	switch err {
+	case nil:
+	case errOpenGameNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		log.Error(err)
+		return
+	case errNoOpenSeats, errSeatNameTaken:
+		http.Error(w, err.Error(), http.StatusConflict)
+		log.Error(err)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.WithField("error", err).Error("error starting the game")
+		return
+	}
+
+	w.Header().Set("Content-Type", CONTENT_JSON)
+	json.NewEncoder(w).Encode(og)
+
+	log.Info("seat claimed")
+}
+
+// subscribeToLobby upgrades the connection to a websocket receiving live lobby updates.
+func subscribeToLobby(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	log := logger.WithFields(logrus.Fields{
+		"user_ip": r.RemoteAddr,
+		"url":     r.URL,
+	})
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an HTTP error.
+		log.WithField("error", err).Error(ERROR_UPGRADING_CONNECTION)
+		return
+	}
+
+	lobby.Subscribe(conn)
+	log.Info("Subscribed to lobby")
+}
@@ -25,9 +25,8 @@ func bodyToString(b io.ReadCloser) string {
 }
 
 func TestSubscriptionHandler_Errors(t *testing.T) {
-	// initiate the global logger
-	var hook *test.Hook
-	logger, hook = test.NewNullLogger()
+	// hook the global logger
+	hook := test.NewLocal(logger)
 
 	// run the test server
 	ts := httptest.NewServer(buildServeMux())
@@ -41,7 +40,7 @@ func TestSubscriptionHandler_Errors(t *testing.T) {
 		assert.Error(t, err, "dial did not fail!")
 	}
 	assert.NotNil(t, resp, "response object is nil")
-	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "Unexpected status code")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
 	assert.Equal(t, GAME_NOT_FOUND, hook.LastEntry().Message, "unexpected final log message")
 
 	//// CASE 2: player not provisioned in game
@@ -51,7 +50,8 @@ func TestSubscriptionHandler_Errors(t *testing.T) {
 	player := rummikub.NewAIPlayer("AIplayer", rummikub.NewILPSolver(gamerules))
 
 	// initiate the game and store it
-	gamestate := rummikub.NewGame(gamerules, 88, player, rummikub.NewHumanPlayer(playerName))
+	gamestate, err := rummikub.NewGame(gamerules, 88, player, rummikub.NewHumanPlayer(playerName))
+	assert.NoError(t, err, "error initiating game")
 	gameID := gameDB.StoreNewGame(gamestate)
 
 	// attempt to subscribe
@@ -61,7 +61,7 @@ func TestSubscriptionHandler_Errors(t *testing.T) {
 		assert.Error(t, err, "dial did not fail!")
 	}
 	assert.NotNil(t, resp, "response object is nil")
-	assert.Equal(t, http.StatusPartialContent, resp.StatusCode, "Unexpected status code")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
 	assert.Equal(t, NO_HUMAN_PROVISIONED, hook.LastEntry().Message, "unexpected final log message")
 
 	// log dump
@@ -78,14 +78,11 @@ func TestSubscriptionHandler_NameCollision(t *testing.T) {
 	playerA := rummikub.NewAIPlayer("AIplayerA", rummikub.NewILPSolver(gamerules))
 
 	// initiate the game and store it
-	gamestate := rummikub.NewGame(gamerules, 88, playerA,
+	gamestate, err := rummikub.NewGame(gamerules, 88, playerA,
 		rummikub.NewHumanPlayer(playerName))
+	assert.NoError(t, err, "error initiating game")
 	gameID := gameDB.StoreNewGame(gamestate)
 
-	// initiate the global logger
-	//var hook *test.Hook
-	logger, _ = test.NewNullLogger()
-
 	// run the test server
 	ts := httptest.NewServer(buildServeMux())
 
@@ -106,13 +103,12 @@ func TestSubscriptionHandler_NameCollision(t *testing.T) {
 	_, resp, err = websocket.DefaultDialer.Dial(u, nil)
 	assert.Error(t, err, "dial did not fail!")
 	assert.NotNil(t, resp, "response object is nil")
-	assert.Equal(t, http.StatusPartialContent, resp.StatusCode, "Unexpected status code")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Unexpected status code")
 }
 
 func TestHandler_newGame(t *testing.T) {
-	// initiate the global logger
-	var hook *test.Hook
-	logger, hook = test.NewNullLogger()
+	// hook the global logger
+	hook := test.NewLocal(logger)
 
 	// run the test server
 	ts := httptest.NewServer(buildServeMux())
@@ -146,6 +142,40 @@ func TestHandler_newGame(t *testing.T) {
 
 }
 
+func TestNewAIPlayer(t *testing.T) {
+	rules := rummikub.NewDefaultRules()
+	player := newAIPlayer("bot", rules)
+	assert.Equal(t, "bot", player.Name)
+	assert.False(t, player.Human)
+
+	// the player plays the best move found by its engines.
+	table := []rummikub.BrickCombination{rummikub.NewBrickCombination(rummikub.Brick{Value: 5, Color: "red"}, rummikub.Brick{Value: 5, Color: "blue"}, rummikub.Brick{Value: 5, Color: "green"})}
+	hand := []rummikub.Brick{{Value: 5, Color: "yellow"}, {Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}}
+	player.SetHand(hand)
+	move := player.MakeMove(table, rules, false)
+	assert.NoError(t, rummikub.VerifySolution(rules, hand, table, move.Arrangement, rummikub.BrickSliceDiff(rummikub.DissolveCombinations(table), move.Bricks())))
+	assert.Len(t, move.Bricks(), 7, "not all bricks were played")
+}
+
+func TestHandler_newGame_InvalidSettings(t *testing.T) {
+	// run the test server
+	ts := httptest.NewServer(buildServeMux())
+	targetURL := ts.URL + GAME_ROOT
+
+	// player names must be unique across AI and human players.
+	settings := NewGameSettings{
+		AIplayerNames:    []string{"jan"},
+		HumanPlayerNames: []string{"jan"},
+	}
+	settingsBytes, err := json.Marshal(settings)
+	assert.NoError(t, err, "error serializing settings")
+
+	//send the request
+	resp, err := http.Post(targetURL, CONTENT_JSON, bytes.NewBuffer(settingsBytes))
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Unexpected status code")
+}
+
 func TestHandler_getHand(t *testing.T) {
 	// shortcut a game into the database
 	gamerules := rummikub.NewDefaultRules()
@@ -153,15 +183,15 @@ func TestHandler_getHand(t *testing.T) {
 	player := rummikub.NewAIPlayer(playerName, rummikub.NewILPSolver(gamerules))
 
 	// initiate the game and store it
-	gamestate := rummikub.NewGame(gamerules, 88, player)
+	gamestate, err := rummikub.NewGame(gamerules, 88, player)
+	assert.NoError(t, err, "error initiating game")
 	gameID := gameDB.StoreNewGame(gamestate)
 
 	// get the player hand
 	playerHand := gamestate.GetPlayer(playerName).Hand()
 
-	// initiate the global logger
-	var hook *test.Hook
-	logger, hook = test.NewNullLogger()
+	// hook the global logger
+	hook := test.NewLocal(logger)
 
 	// run the test server
 	ts := httptest.NewServer(buildServeMux())
@@ -190,10 +220,6 @@ func TestHandler_getHand(t *testing.T) {
 }
 
 func TestHandler_InvalidMethods(t *testing.T) {
-	// initiate the global logger
-	//var hook *test.Hook
-	logger, _ = test.NewNullLogger()
-
 	// run the test server
 	ts := httptest.NewServer(buildServeMux())
 
@@ -212,3 +238,36 @@ func TestHandler_InvalidMethods(t *testing.T) {
 	assert.NoError(t, err, "Error sending request to mock server")
 	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "Unexpected status code")
 }
+
+func TestHandler_protocolSchema(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + PROTOCOL_SCHEMA)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var schema struct {
+		Definitions map[string]struct {
+			Properties struct {
+				MessageType struct {
+					Const string `json:"const"`
+				} `json:"message_type"`
+			} `json:"properties"`
+		} `json:"definitions"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&schema), "schema is not valid JSON")
+
+	// every message type must be described by the schema
+	described := map[string]bool{}
+	for _, def := range schema.Definitions {
+		described[def.Properties.MessageType.Const] = true
+	}
+	for _, messageType := range []string{
+		ERROR_MESSAGE, HAND_UPDATE, GAME_SNAPSHOT, MOVE_REJECTION, MOVE_ACCEPTED, HINT, RESIGNED, PONG,
+		CHAT_MESSAGE, CHAT_HISTORY, LOBBY_SNAPSHOT, SERVER_SHUTDOWN, GAME_ABORTED,
+		MOVE_PROPOSAL, FORFEIT, REQUEST_SNAPSHOT, REQUEST_HINT, RESIGN, PING, CHAT, REACTION,
+	} {
+		assert.True(t, described[messageType], "message type %q not described by the protocol schema", messageType)
+	}
+}
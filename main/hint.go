@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+// Hint contains the arrangement of the table that the hint solver suggests to a player.
+type Hint struct {
+	// the suggested arrangement of the table. Equal to the current table if no bricks can be played.
+	Arrangement []rummikub.BrickCombination `json:"arrangement"`
+
+	// the bricks from the player's hand that are played in the suggested arrangement,
+	// and their summed value (the jokers valued as the bricks they stand in for).
+	BricksToPlay []rummikub.Brick `json:"bricks_to_play"`
+	Value        int              `json:"value"`
+
+	// the number of hints the player has requested so far, including this one.
+	HintsUsed int `json:"hints_used"`
+}
+
+// HINT_BUDGET is the time the hint solver is given to come up with a hint. Once it runs out, the solver's fallback is suggested instead.
+const HINT_BUDGET = 5 * time.Second
+
+// newHintRequest takes a snapshot of the player's hand and the table to compute a hint from, using the solver.
+// Returns the errors of GameState.RecordHint, but does not count the hint yet (see recordHint).
+func newHintRequest(game *rummikub.GameState, playerName string, solver rummikub.Solver) (*rummikub.AIMoveRequest, error) {
+	return game.HintRequest(playerName, solver, HINT_BUDGET)
+}
+
+// recordHint counts the hint, the move computed for the request, in the game state and returns it.
+// Returns the errors of GameState.RecordHint, as the game may have changed since the request was taken.
+func recordHint(game *rummikub.GameState, request *rummikub.AIMoveRequest, move rummikub.Move) (*Hint, error) {
+	playerName := request.PlayerName()
+	if err := game.RecordHint(playerName); err != nil {
+		return nil, err
+	}
+
+	table := request.Table()
+	bricks := rummikub.BrickSliceDiff(rummikub.DissolveCombinations(table), move.Bricks())
+	value := game.Rules.PlacedValue(table, move.Arrangement)
+	return &Hint{Arrangement: move.Arrangement, BricksToPlay: bricks, Value: value, HintsUsed: game.GetPlayer(playerName).HintsUsed}, nil
+}
+
+// errHintBusy is returned when a hint is requested while another hint is computed for the same game (see ActiveGame.hint).
+var errHintBusy = errors.New("another hint is being computed for this game, try again later")
+
+// hintStatus maps the errors of GameState.RecordHint (and errHintBusy) to HTTP status codes.
+func hintStatus(err error) int {
+	var playerErr *rummikub.PlayerError
+	switch {
+	case err == errHintBusy:
+		return http.StatusTooManyRequests
+	case errors.Is(err, rummikub.ErrHintsDisabled):
+		return http.StatusForbidden
+	case errors.Is(err, rummikub.UNKNOWN_PLAYER):
+		return http.StatusNotFound
+	case errors.As(err, &playerErr):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// errHintAbandoned is returned when the game is closed while a hint is computed.
+var errHintAbandoned = errors.New("the game was closed before the hint was computed")
+
+// requestHint computes a hint for the player named in the URL. Hints are only given in active games (see ActiveGame.hint).
+func requestHint(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID, playerName := vars[GAME_RESOURCE], vars[PLAYER_RESOURCE]
+	log := logger.WithFields(logrus.Fields{
+		"user_ip":     r.RemoteAddr,
+		"url":         r.URL,
+		"game_id":     gameID,
+		"player_name": playerName,
+	})
+
+	type hintResult struct {
+		hint *Hint
+		err  error
+	}
+	results := make(chan hintResult, 1)
+
+	aGame := activeGamesStore.get(gameID)
+	err := errGameClosed
+	if aGame != nil {
+		err = aGame.Do(r.Context(), func() {
+			aGame.hint(playerName, func(hint *Hint, err error) { results <- hintResult{hint, err} })
+		})
+	}
+
+	var result hintResult
+	if err == nil {
+		select {
+		case result = <-results:
+		case <-aGame.Done():
+			err = errHintAbandoned
+		case <-r.Context().Done():
+			err = r.Context().Err()
+		}
+	}
+
+	switch {
+	case err == errGameClosed && gameDB.GetGame(gameID) == nil:
+		http.Error(w, GAME_NOT_FOUND, http.StatusNotFound)
+		log.Error(GAME_NOT_FOUND)
+	case err == errGameClosed:
+		http.Error(w, GAME_NOT_ACTIVE, http.StatusConflict)
+		log.Error(GAME_NOT_ACTIVE)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		log.WithField("error", err).Error("error requesting hint")
+	case result.err != nil:
+		http.Error(w, result.err.Error(), hintStatus(result.err))
+		log.WithField("error", result.err).Error("error computing hint")
+	default:
+		writeJSON(w, http.StatusOK, result.hint)
+		log.WithField("hints_used", result.hint.HintsUsed).Info("served hint")
+	}
+}
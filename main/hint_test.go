@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+// fixedSolver plays all of the hand as a single combination.
+type fixedSolver struct{}
+
+func (s *fixedSolver) Solve(hand []rummikub.Brick, table []rummikub.BrickCombination, maximizeValue bool) ([]rummikub.BrickCombination, []rummikub.Brick, error) {
+	return append(table, rummikub.NewBrickCombination(hand...)), hand, nil
+}
+
+// storeHintGame stores a game in which it is alice's first move, and alice holds a run worth 33 points.
+func storeHintGame(t *testing.T) (string, *rummikub.GameState) {
+	game, err := rummikub.NewEmptyGame(rummikub.NewDefaultRules(), rummikub.NewHumanPlayer("alice"), rummikub.NewHumanPlayer("bob"), rummikub.NewAIPlayer("bot", &fixedSolver{}))
+	assert.NoError(t, err, "error initiating game")
+	game.GetPlayer("alice").SetHand([]rummikub.Brick{{Value: 10, Color: "red"}, {Value: 11, Color: "red"}, {Value: 12, Color: "red"}})
+	game.GetPlayer("bob").SetHand([]rummikub.Brick{{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}})
+	game.GetPlayer("bot").SetHand([]rummikub.Brick{{Value: 1, Color: "blue"}})
+	return gameDB.StoreNewGame(&game), &game
+}
+
+// hintFor computes a hint for the player the way the gameManager does, but without leaving the calling goroutine.
+func hintFor(ctx context.Context, game *rummikub.GameState, playerName string, solver rummikub.Solver) (*Hint, error) {
+	request, err := newHintRequest(game, playerName, solver)
+	if err != nil {
+		return nil, err
+	}
+	move, err := request.Solve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return recordHint(game, request, move)
+}
+
+// activateHintGame activates the stored game, without connecting any players.
+func activateHintGame(gameID string, game *rummikub.GameState) *ActiveGame {
+	aGame := ActivateGame(game, gameID, nil, func(aGame *ActiveGame) {
+		activeGamesStore.remove(aGame.ID)
+		gameDB.SaveGame(gameID, aGame.gameState)
+	})
+	activeGamesStore.store(aGame)
+	return aGame
+}
+
+// closeGame closes the game, and waits for it to be closed.
+func closeGame(aGame *ActiveGame) {
+	aGame.Close()
+	<-aGame.Done()
+}
+
+func TestHint_ComputeHint(t *testing.T) {
+	_, game := storeHintGame(t)
+	ctx := context.Background()
+
+	hint, err := hintFor(ctx, game, "alice", &fixedSolver{})
+	assert.NoError(t, err)
+	assert.Len(t, hint.Arrangement, 1)
+	assert.Len(t, hint.BricksToPlay, 3)
+	assert.Equal(t, 33, hint.Value)
+	assert.Equal(t, 1, hint.HintsUsed)
+
+	// bob's bricks do not reach the first move threshold: the hint is to forfeit.
+	hint, err = hintFor(ctx, game, "bob", &fixedSolver{})
+	assert.NoError(t, err)
+	assert.Empty(t, hint.Arrangement)
+	assert.Empty(t, hint.BricksToPlay)
+	assert.Equal(t, 0, hint.Value)
+
+	hint, err = hintFor(ctx, game, "alice", &fixedSolver{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, hint.HintsUsed)
+	assert.Equal(t, 2, game.GetPlayer("alice").HintsUsed)
+	assert.Equal(t, 1, game.GetPlayer("bob").HintsUsed)
+
+	// a hint that could not be computed is not counted.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = hintFor(cancelled, game, "alice", &fixedSolver{})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 2, game.GetPlayer("alice").HintsUsed, "failed hints should not be counted")
+
+	// AI players and unknown players are refused.
+	_, err = hintFor(ctx, game, "bot", &fixedSolver{})
+	assert.Equal(t, http.StatusBadRequest, hintStatus(err))
+	_, err = hintFor(ctx, game, "carol", &fixedSolver{})
+	assert.Equal(t, http.StatusNotFound, hintStatus(err))
+
+	game.HintsDisabled = true
+	_, err = hintFor(ctx, game, "alice", &fixedSolver{})
+	assert.Equal(t, rummikub.ErrHintsDisabled, err)
+	assert.Equal(t, http.StatusForbidden, hintStatus(err))
+	assert.Equal(t, 2, game.GetPlayer("alice").HintsUsed, "refused hints should not be counted")
+}
+
+func TestHint_DoesNotBlock(t *testing.T) {
+	gameID, game := storeHintGame(t)
+	aGame := activateHintGame(gameID, game)
+	defer closeGame(aGame)
+
+	solver := newBlockingSolver()
+	hints := make(chan *Hint, 1)
+	assert.NoError(t, aGame.Do(context.Background(), func() {
+		aGame.hintSolver = solver
+		aGame.hint("alice", func(hint *Hint, err error) {
+			assert.NoError(t, err)
+			hints <- hint
+		})
+	}))
+	select {
+	case <-solver.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hint not started")
+	}
+
+	// the game keeps serving while the hint is computed, and the hint is not counted until it has been given.
+	var used int
+	assert.NoError(t, aGame.Do(context.Background(), func() { used = aGame.gameState.GetPlayer("alice").HintsUsed }))
+	assert.Equal(t, 0, used)
+
+	close(solver.release)
+	select {
+	case hint := <-hints:
+		assert.Equal(t, 1, hint.HintsUsed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("hint not given")
+	}
+}
+
+func TestHint_OneAtATime(t *testing.T) {
+	gameID, game := storeHintGame(t)
+	aGame := activateHintGame(gameID, game)
+	defer closeGame(aGame)
+
+	solver := newBlockingSolver()
+	hints := make(chan error, 3)
+	give := func(hint *Hint, err error) { hints <- err }
+	assert.NoError(t, aGame.Do(context.Background(), func() {
+		aGame.hintSolver = solver
+		aGame.hint("alice", give)
+	}))
+	select {
+	case <-solver.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hint not started")
+	}
+
+	// while the hint is computed, other hints are refused rather than piling up.
+	assert.NoError(t, aGame.Do(context.Background(), func() { aGame.hint("bob", give) }))
+	err := <-hints
+	assert.Equal(t, errHintBusy, err)
+	assert.Equal(t, http.StatusTooManyRequests, hintStatus(err))
+
+	// once it has been computed, the next hint is.
+	close(solver.release)
+	assert.NoError(t, <-hints)
+	assert.NoError(t, aGame.Do(context.Background(), func() { aGame.hint("bob", give) }))
+	assert.NoError(t, <-hints)
+}
+
+func TestHint_Disabled(t *testing.T) {
+	gameID, game := storeHintGame(t)
+	game.HintsDisabled = true
+	aGame := activateHintGame(gameID, game)
+	defer closeGame(aGame)
+
+	// hints that are refused do not build the hint solver.
+	hints := make(chan error, 1)
+	assert.NoError(t, aGame.Do(context.Background(), func() {
+		aGame.hint("alice", func(hint *Hint, err error) { hints <- err })
+		assert.Nil(t, aGame.hintSolver, "the hint solver was built")
+	}))
+	assert.Equal(t, rummikub.ErrHintsDisabled, <-hints)
+}
+
+func TestHint_Endpoint(t *testing.T) {
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	gameID, game := storeHintGame(t)
+	hintURL := func(player string) string {
+		return ts.URL + GAME_ROOT + "/" + gameID + "/" + player + GAME_HINT
+	}
+
+	// hints are only given in active games.
+	resp, err := http.Post(hintURL("alice"), CONTENT_JSON, nil)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Unexpected status code")
+	resp, err = http.Post(ts.URL+GAME_ROOT+"/unknown/alice"+GAME_HINT, CONTENT_JSON, nil)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
+	assert.Equal(t, 0, game.GetPlayer("alice").HintsUsed, "refused hints should not be counted")
+
+	aGame := activateHintGame(gameID, game)
+	defer closeGame(aGame)
+	resp, err = http.Post(hintURL("alice"), CONTENT_JSON, nil)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+	var hint Hint
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&hint))
+	resp.Body.Close()
+	assert.Equal(t, 33, hint.Value)
+	assert.Len(t, hint.BricksToPlay, 3)
+	assert.Equal(t, 1, hint.HintsUsed)
+
+	resp, err = http.Post(hintURL("carol"), CONTENT_JSON, nil)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Unexpected status code")
+
+	// the hints are recorded in the game history.
+	resp, err = http.Get(ts.URL + GAMES_ROOT + "/" + gameID + GAMES_HISTORY)
+	assert.NoError(t, err, "Error sending request to mock server")
+	var history GameHistory
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	resp.Body.Close()
+	assert.Equal(t, map[string]int{"alice": 1, "bob": 0}, history.Hints)
+
+	assert.NoError(t, aGame.Do(context.Background(), func() { game.HintsDisabled = true }))
+	resp, err = http.Post(hintURL("alice"), CONTENT_JSON, nil)
+	assert.NoError(t, err, "Error sending request to mock server")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Unexpected status code")
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+const (
+	// the time after which the empty seats of an open game are filled with AI players.
+	lobbyFillTimeout = 2 * time.Minute
+
+	// the time a started game remains listed in the lobby, so that players can look up its game ID.
+	lobbyRetention = 10 * time.Minute
+
+	// the number of lobby updates buffered per lobby client. Clients that fall behind are disconnected.
+	lobbyClientBuffer = 16
+
+	// open game statuses
+	LOBBY_OPEN    = "open"
+	LOBBY_STARTED = "started"
+	LOBBY_EXPIRED = "expired"
+
+	// outgoing lobby messages
+	LOBBY_SNAPSHOT = "lobby_snapshot"
+)
+
+var (
+	errOpenGameNotFound = errors.New("open game not found")
+	errNoOpenSeats      = errors.New("no open seats left in this game")
+	errSeatNameTaken    = errors.New("a player with this name has already claimed a seat in this game")
+)
+
+// Seat is a seat at an open game. A seat without a player name is empty.
+type Seat struct {
+	PlayerName string `json:"player_name"`
+	AI         bool   `json:"ai"`
+}
+
+// OpenGame is a game in the lobby, waiting for players to claim its seats.
+type OpenGame struct {
+	ID     string `json:"id"`
+	Seats  []Seat `json:"seats"`
+	Status string `json:"status"`
+
+	// the ID under which the game was stored when it started. Players subscribe to the game using this ID.
+	GameID string `json:"game_id,omitempty"`
+
+	// the moment the empty seats are filled with AI players.
+	FillDeadline time.Time `json:"fill_deadline"`
+
+	// fires at the fill deadline.
+	fillTimer *time.Timer
+}
+
+// openSeats returns the number of empty seats.
+func (og *OpenGame) openSeats() int {
+	n := 0
+	for _, s := range og.Seats {
+		if s.PlayerName == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// hasPlayer reports whether a player with the given name has claimed a seat.
+func (og *OpenGame) hasPlayer(name string) bool {
+	for _, s := range og.Seats {
+		if s.PlayerName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// view returns a copy of the open game that is safe to serialize outside of the lobby lock.
+func (og *OpenGame) view() OpenGame {
+	v := *og
+	v.Seats = append([]Seat{}, og.Seats...)
+	v.fillTimer = nil
+	return v
+}
+
+// Lobby lists the open games and matches players to their seats.
+// Once all seats of an open game are filled, the game is stored in the game database,
+// after which the players subscribe to it like any other game (see subscribeToGame).
+// The game is started by the ActiveGame as soon as all human players have subscribed.
+type Lobby struct {
+	sync.Mutex
+
+	// the rules of the games started from the lobby.
+	rules rummikub.Rules
+
+	// the time after which the empty seats are filled with AI players.
+	fillTimeout time.Duration
+
+	openGames map[string]*OpenGame
+
+	// the websocket clients receiving live updates.
+	subscribers map[*LobbyClient]bool
+}
+
+func NewLobby(rules rummikub.Rules, fillTimeout time.Duration) *Lobby {
+	return &Lobby{
+		rules:       rules,
+		fillTimeout: fillTimeout,
+		openGames:   make(map[string]*OpenGame),
+		subscribers: make(map[*LobbyClient]bool),
+	}
+}
+
+// Open adds a game with the given number of empty seats to the lobby.
+func (l *Lobby) Open(nSeats int) (OpenGame, error) {
+	if err := l.rules.Validate(nSeats); err != nil {
+		return OpenGame{}, err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	// generate an ID for the open game. Small built in check ensures ID is unique.
+	id := NewID(DEFAULT_ID_RANDOM_BYTES)
+	for l.openGames[id] != nil {
+		id = NewID(DEFAULT_ID_RANDOM_BYTES)
+	}
+
+	og := &OpenGame{
+		ID:           id,
+		Seats:        make([]Seat, nSeats),
+		Status:       LOBBY_OPEN,
+		FillDeadline: time.Now().Add(l.fillTimeout),
+	}
+	og.fillTimer = time.AfterFunc(l.fillTimeout, func() { l.fillWithAI(id) })
+	l.openGames[id] = og
+
+	l.broadcast()
+	return og.view(), nil
+}
+
+// List returns all games in the lobby, the ones closest to their fill deadline first.
+func (l *Lobby) List() []OpenGame {
+	l.Lock()
+	defer l.Unlock()
+	return l.list()
+}
+
+func (l *Lobby) list() []OpenGame {
+	games := []OpenGame{}
+	for _, og := range l.openGames {
+		games = append(games, og.view())
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].FillDeadline.Equal(games[j].FillDeadline) {
+			return games[i].ID < games[j].ID
+		}
+		return games[i].FillDeadline.Before(games[j].FillDeadline)
+	})
+	return games
+}
+
+// Join claims the first empty seat of the open game for the named player.
+// If it was the last empty seat, the game is started.
+func (l *Lobby) Join(id string, playerName string) (OpenGame, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	og, ok := l.openGames[id]
+	if !ok {
+		return OpenGame{}, errOpenGameNotFound
+	}
+	if og.Status != LOBBY_OPEN || og.openSeats() == 0 {
+		return og.view(), errNoOpenSeats
+	}
+	if og.hasPlayer(playerName) {
+		return og.view(), errSeatNameTaken
+	}
+
+	for i := range og.Seats {
+		if og.Seats[i].PlayerName == "" {
+			og.Seats[i].PlayerName = playerName
+			break
+		}
+	}
+
+	var err error
+	if og.openSeats() == 0 {
+		og.fillTimer.Stop()
+		err = l.start(og)
+	}
+
+	l.broadcast()
+	return og.view(), err
+}
+
+// fillWithAI fills the empty seats of the open game with AI players and starts it.
+// Games that nobody has joined are removed from the lobby instead.
+func (l *Lobby) fillWithAI(id string) {
+	l.Lock()
+	defer l.Unlock()
+
+	og, ok := l.openGames[id]
+	if !ok || og.Status != LOBBY_OPEN {
+		return
+	}
+
+	if og.openSeats() == len(og.Seats) {
+		logger.WithField("lobby_id", id).Info("Nobody joined the open game before the deadline. Removing it from the lobby.")
+		og.Status = LOBBY_EXPIRED
+		delete(l.openGames, id)
+		l.broadcast()
+		return
+	}
+
+	aiCount := 0
+	for i := range og.Seats {
+		if og.Seats[i].PlayerName != "" {
+			continue
+		}
+		name := ""
+		for name == "" || og.hasPlayer(name) {
+			aiCount++
+			name = fmt.Sprintf("AI player %v", aiCount)
+		}
+		og.Seats[i] = Seat{PlayerName: name, AI: true}
+	}
+
+	if err := l.start(og); err != nil {
+		logger.WithField("lobby_id", id).WithField("error", err).Error("Error starting the open game.")
+	}
+	l.broadcast()
+}
+
+// start stores the game in the game database and marks the open game as started.
+// Must be called with the lobby locked, once all seats are filled.
+func (l *Lobby) start(og *OpenGame) error {
+	players := []rummikub.Player{}
+	for _, s := range og.Seats {
+		if s.AI {
+			players = append(players, newAIPlayer(s.PlayerName, l.rules))
+		} else {
+			players = append(players, rummikub.NewHumanPlayer(s.PlayerName))
+		}
+	}
+
+	game, err := rummikub.NewGame(l.rules, time.Now().Unix(), players...)
+	if err != nil {
+		og.Status = LOBBY_EXPIRED
+		delete(l.openGames, og.ID)
+		return err
+	}
+
+	og.GameID = gameDB.StoreNewGame(game)
+	og.Status = LOBBY_STARTED
+	logger.WithFields(logrus.Fields{
+		"lobby_id": og.ID,
+		"game_id":  og.GameID,
+	}).Info("Open game started.")
+
+	// keep the started game listed for a while, so that the players can look up the game ID.
+	id := og.ID
+	time.AfterFunc(lobbyRetention, func() {
+		l.Lock()
+		defer l.Unlock()
+		delete(l.openGames, id)
+		l.broadcast()
+	})
+	return nil
+}
+
+// LobbyClient is a websocket connection receiving live lobby updates.
+type LobbyClient struct {
+	lobby *Lobby
+	conn  *websocket.Conn
+
+	// this channel feeds directly to the writePump. Closed by the lobby when the client is unsubscribed.
+	send chan []byte
+}
+
+// Subscribe registers the connection for live updates, sends it the current lobby and starts its I/O pumps.
+func (l *Lobby) Subscribe(conn *websocket.Conn) {
+	c := &LobbyClient{lobby: l, conn: conn, send: make(chan []byte, lobbyClientBuffer)}
+
+	l.Lock()
+	l.subscribers[c] = true
+	c.send <- l.snapshotMessage()
+	l.Unlock()
+
+	go c.readPump()
+	go c.writePump()
+}
+
+func (l *Lobby) unsubscribe(c *LobbyClient) {
+	l.Lock()
+	defer l.Unlock()
+	if l.subscribers[c] {
+		delete(l.subscribers, c)
+		close(c.send)
+	}
+}
+
+// snapshotMessage serializes the lobby. Must be called with the lobby locked.
+func (l *Lobby) snapshotMessage() []byte {
+	data, err := json.Marshal(Envelope{
+		Version:     PROTOCOL_VERSION,
+		MessageType: LOBBY_SNAPSHOT,
+		Payload:     l.list(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// broadcast sends the lobby to all subscribers. Must be called with the lobby locked.
+// Never blocks: subscribers that do not keep up are disconnected.
+func (l *Lobby) broadcast() {
+	if len(l.subscribers) == 0 {
+		return
+	}
+	msg := l.snapshotMessage()
+	for c := range l.subscribers {
+		select {
+		case c.send <- msg:
+		default:
+			delete(l.subscribers, c)
+			close(c.send)
+		}
+	}
+}
+
+// readPump discards incoming messages; it only serves to detect that the connection was closed.
+func (c *LobbyClient) readPump() {
+	defer func() {
+		c.lobby.unsubscribe(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
+				logger.WithField("error", err).Error("unexpected lobby websocket close error.")
+			}
+			return
+		}
+	}
+}
+
+// writePump writes the lobby updates to the websocket connection.
+func (c *LobbyClient) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The channel was closed by the lobby.
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestLobby_Join(t *testing.T) {
+	l := NewLobby(rummikub.NewDefaultRules(), time.Hour)
+
+	// too few seats
+	_, err := l.Open(0)
+	assert.Error(t, err, "a game without seats was opened")
+
+	og, err := l.Open(2)
+	assert.NoError(t, err)
+	assert.Equal(t, LOBBY_OPEN, og.Status)
+	assert.Len(t, l.List(), 1)
+
+	_, err = l.Join("nonexistent", "alice")
+	assert.Equal(t, errOpenGameNotFound, err)
+
+	og, err = l.Join(og.ID, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, LOBBY_OPEN, og.Status, "game started before all seats were filled")
+
+	_, err = l.Join(og.ID, "alice")
+	assert.Equal(t, errSeatNameTaken, err)
+
+	// claiming the last seat starts the game
+	og, err = l.Join(og.ID, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, LOBBY_STARTED, og.Status)
+	assert.NotEmpty(t, og.GameID)
+
+	_, err = l.Join(og.ID, "carol")
+	assert.Equal(t, errNoOpenSeats, err)
+
+	// the game is waiting in the database for the human players to subscribe
+	game := gameDB.GetGame(og.GameID)
+	if assert.NotNil(t, game, "started game not stored") {
+		for _, name := range []string{"alice", "bob"} {
+			p := game.GetPlayer(name)
+			if assert.NotNil(t, p, "player %v not in game", name) {
+				assert.True(t, p.Human)
+			}
+		}
+	}
+}
+
+func TestLobby_FillWithAI(t *testing.T) {
+	l := NewLobby(rummikub.NewDefaultRules(), 50*time.Millisecond)
+
+	joined, err := l.Open(3)
+	assert.NoError(t, err)
+	_, err = l.Join(joined.ID, "alice")
+	assert.NoError(t, err)
+
+	abandoned, err := l.Open(2)
+	assert.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	// the game nobody joined has been removed.
+	games := l.List()
+	if !assert.Len(t, games, 1) {
+		return
+	}
+
+	// the remaining seats have been filled with AI players.
+	og := games[0]
+	assert.Equal(t, joined.ID, og.ID)
+	assert.NotEqual(t, abandoned.ID, og.ID)
+	assert.Equal(t, LOBBY_STARTED, og.Status)
+	assert.Equal(t, Seat{PlayerName: "alice"}, og.Seats[0])
+	assert.True(t, og.Seats[1].AI)
+	assert.True(t, og.Seats[2].AI)
+	assert.NotEqual(t, og.Seats[1].PlayerName, og.Seats[2].PlayerName, "AI players share a name")
+
+	game := gameDB.GetGame(og.GameID)
+	if assert.NotNil(t, game, "started game not stored") {
+		assert.False(t, game.GetPlayer(og.Seats[1].PlayerName).Human)
+	}
+}
+
+func TestHandler_Lobby(t *testing.T) {
+	lobby = NewLobby(rummikub.NewDefaultRules(), time.Hour)
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	// subscribe to the live updates
+	conn, _, err := websocket.DefaultDialer.Dial("ws:"+trimHTTPproto(ts.URL)+LOBBY_SUBSCRIBE, nil)
+	if !assert.NoError(t, err, "error subscribing to lobby") {
+		return
+	}
+	defer conn.Close()
+	readSnapshot := func() []OpenGame {
+		var games []OpenGame
+		env := Envelope{Payload: &games}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(t, conn.ReadJSON(&env))
+		assert.Equal(t, LOBBY_SNAPSHOT, env.MessageType)
+		return games
+	}
+	assert.Empty(t, readSnapshot(), "lobby should be empty")
+
+	// invalid seat count
+	resp, err := http.Post(ts.URL+LOBBY_ROOT, CONTENT_JSON, bytes.NewBufferString(`{"seats": 0}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// open a game
+	resp, err = http.Post(ts.URL+LOBBY_ROOT, CONTENT_JSON, bytes.NewBufferString(`{"seats": 2}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var og OpenGame
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&og))
+	assert.Len(t, readSnapshot(), 1, "lobby update not received")
+
+	// list the games
+	resp, err = http.Get(ts.URL + LOBBY_ROOT)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var games []OpenGame
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&games))
+	if assert.Len(t, games, 1) {
+		assert.Equal(t, og.ID, games[0].ID)
+	}
+
+	// join it
+	join := func(lobbyID, name string) *http.Response {
+		resp, err := http.Post(ts.URL+LOBBY_ROOT+"/"+lobbyID+"/"+name, CONTENT_JSON, nil)
+		assert.NoError(t, err)
+		return resp
+	}
+	assert.Equal(t, http.StatusNotFound, join("nonexistent", "alice").StatusCode)
+	assert.Equal(t, http.StatusOK, join(og.ID, "alice").StatusCode)
+	assert.Equal(t, http.StatusConflict, join(og.ID, "alice").StatusCode)
+	readSnapshot()
+
+	resp = join(og.ID, "bob")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&og))
+	assert.Equal(t, LOBBY_STARTED, og.Status)
+	assert.True(t, gameDB.containsID(og.GameID), "started game not stored")
+
+	games = readSnapshot()
+	if assert.Len(t, games, 1) {
+		assert.Equal(t, og.GameID, games[0].GameID, "game ID not broadcast")
+	}
+	assert.Equal(t, http.StatusConflict, join(og.ID, "carol").StatusCode)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+// The metrics are exposed at METRICS in the Prometheus text exposition format (version 0.0.4).
+const (
+	METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+	// metric types
+	COUNTER   = "counter"
+	GAUGE     = "gauge"
+	HISTOGRAM = "histogram"
+
+	// the outcome label of moves that were rejected for a reason other than a rule violation.
+	MOVE_REJECTED_OTHER = "rejected"
+)
+
+// the histogram buckets (upper bounds in seconds) of the AI solve times.
+var solveTimeBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// the histogram buckets (upper bounds in seconds) of the time spent waiting for a client's write pump.
+var sendWaitBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// metricFamily is a set of samples of the same metric, distinguished by the value of a single label (if any).
+type metricFamily struct {
+	name  string
+	help  string
+	kind  string
+	label string // empty for unlabeled metrics, which have a single sample.
+
+	sync.Mutex
+	values map[string]float64 // by label value
+}
+
+func newMetricFamily(name string, kind string, label string, help string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: kind, label: label, values: make(map[string]float64)}
+}
+
+// Add adds delta to the sample with the given label value (empty for unlabeled metrics).
+func (f *metricFamily) Add(labelValue string, delta float64) {
+	f.Lock()
+	defer f.Unlock()
+	f.values[labelValue] += delta
+}
+
+// Inc adds 1 to the sample with the given label value.
+func (f *metricFamily) Inc(labelValue string) {
+	f.Add(labelValue, 1)
+}
+
+// Set sets the sample with the given label value. Only to be used on gauges.
+func (f *metricFamily) Set(labelValue string, value float64) {
+	f.Lock()
+	defer f.Unlock()
+	f.values[labelValue] = value
+}
+
+// Delete removes the sample with the given label value, e.g. once the game it describes has been closed.
+func (f *metricFamily) Delete(labelValue string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.values, labelValue)
+}
+
+// Value returns the sample with the given label value.
+func (f *metricFamily) Value(labelValue string) float64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.values[labelValue]
+}
+
+func (f *metricFamily) writeTo(w io.Writer) {
+	f.Lock()
+	defer f.Unlock()
+
+	writeHeader(w, f.name, f.kind, f.help)
+	if f.label == "" {
+		fmt.Fprintf(w, "%v %v\n", f.name, formatValue(f.values[""]))
+		return
+	}
+
+	labelValues := make([]string, 0, len(f.values))
+	for v := range f.values {
+		labelValues = append(labelValues, v)
+	}
+	sort.Strings(labelValues)
+	for _, v := range labelValues {
+		fmt.Fprintf(w, "%v{%v=\"%v\"} %v\n", f.name, f.label, escapeLabelValue(v), formatValue(f.values[v]))
+	}
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64 // upper bounds, ascending.
+
+	sync.Mutex
+	counts []uint64 // per bucket, not cumulative.
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name string, buckets []float64, help string) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe records a single observation.
+func (h *histogram) Observe(value float64) {
+	h.Lock()
+	defer h.Unlock()
+	h.count++
+	h.sum += value
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		h.counts[i]++
+	}
+}
+
+// ObserveDuration records a duration in seconds.
+func (h *histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *histogram) writeTo(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	writeHeader(w, h.name, HISTOGRAM, h.help)
+	var cumulative uint64
+	for i, upperBound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", h.name, formatValue(upperBound), cumulative)
+	}
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n", h.name, h.count)
+	fmt.Fprintf(w, "%v_sum %v\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(w, "%v_count %v\n", h.name, h.count)
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %v %v\n", name, kind)
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Metrics holds the server metrics.
+type Metrics struct {
+	gamesCreated     *metricFamily
+	gamesActive      *metricFamily
+	gamesFinished    *metricFamily
+	connectedClients *metricFamily
+	moves            *metricFamily
+	aiSolveTime      *histogram
+	sendsPending     *metricFamily
+	sendWait         *histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		gamesCreated:     newMetricFamily("rummigo_games_created_total", COUNTER, "", "Number of games created."),
+		gamesActive:      newMetricFamily("rummigo_games_active", GAUGE, "", "Number of games with connected players."),
+		gamesFinished:    newMetricFamily("rummigo_games_finished_total", COUNTER, "", "Number of games that have been won."),
+		connectedClients: newMetricFamily("rummigo_connected_clients", GAUGE, "game_id", "Number of clients connected to each active game."),
+		moves:            newMetricFamily("rummigo_moves_total", COUNTER, "outcome", "Number of moves processed, by outcome or rule violation."),
+		aiSolveTime:      newHistogram("rummigo_ai_solve_seconds", solveTimeBuckets, "Time taken by the AI players to come up with a move."),
+		sendsPending:     newMetricFamily("rummigo_websocket_sends_pending", GAUGE, "", "Number of messages waiting for a client's write pump."),
+		sendWait:         newHistogram("rummigo_websocket_send_wait_seconds", sendWaitBuckets, "Time a message waited for a client's write pump to accept it."),
+	}
+}
+
+// the server metrics.
+var metrics = NewMetrics()
+
+// Write writes all metrics in the text exposition format.
+func (m *Metrics) Write(w io.Writer) {
+	m.gamesCreated.writeTo(w)
+	m.gamesActive.writeTo(w)
+	m.gamesFinished.writeTo(w)
+	m.connectedClients.writeTo(w)
+	m.moves.writeTo(w)
+	m.aiSolveTime.writeTo(w)
+	m.sendsPending.writeTo(w)
+	m.sendWait.writeTo(w)
+}
+
+// ObserveMove counts a processed move under its outcome, or under the code of the rule it violates.
+func (m *Metrics) ObserveMove(outcome rummikub.Outcome, err error) {
+	if err == nil {
+		m.moves.Inc(string(outcome))
+		return
+	}
+
+	var violation *rummikub.RuleViolation
+	var code rummikub.ViolationCode
+	switch {
+	case errors.As(err, &violation):
+		m.moves.Inc(string(violation.Code))
+	case errors.As(err, &code):
+		m.moves.Inc(string(code))
+	default:
+		m.moves.Inc(MOVE_REJECTED_OTHER)
+	}
+}
+
+// ObserveAITurn records the solve time and outcome of an AI player's turn.
+func (m *Metrics) ObserveAITurn(turn rummikub.AITurn) {
+	m.aiSolveTime.ObserveDuration(turn.SolveTime)
+	m.ObserveMove(turn.Outcome, nil)
+}
+
+// serveMetrics serves the server metrics in the text exposition format.
+func serveMetrics(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	metrics.gamesActive.Set("", float64(activeGamesStore.count()))
+
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	metrics.Write(w)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestMetrics_Exposition(t *testing.T) {
+	m := NewMetrics()
+	m.gamesCreated.Add("", 3)
+	m.connectedClients.Set("game-b", 2)
+	m.connectedClients.Set("game-a", 1)
+	m.connectedClients.Set("game-\"c\"", 1)
+	m.connectedClients.Delete("game-\"c\"")
+	m.ObserveMove(rummikub.LEGAL_MOVE, nil)
+	m.ObserveAITurn(rummikub.AITurn{PlayerName: "AI", SolveTime: 300 * time.Millisecond, Outcome: rummikub.FORFEITED})
+	m.aiSolveTime.Observe(120)
+
+	var b bytes.Buffer
+	m.Write(&b)
+	out := b.String()
+
+	assert.Contains(t, out, "# HELP rummigo_games_created_total Number of games created.\n# TYPE rummigo_games_created_total counter\nrummigo_games_created_total 3\n")
+	assert.Contains(t, out, "# TYPE rummigo_games_active gauge\nrummigo_games_active 0\n")
+	assert.Contains(t, out, "rummigo_connected_clients{game_id=\"game-a\"} 1\nrummigo_connected_clients{game_id=\"game-b\"} 2\n# HELP")
+	assert.Contains(t, out, "rummigo_moves_total{outcome=\"forfeited\"} 1\nrummigo_moves_total{outcome=\"legal_move\"} 1\n")
+
+	// the buckets are cumulative, and observations above the largest bucket only count towards +Inf.
+	assert.Contains(t, out, "rummigo_ai_solve_seconds_bucket{le=\"0.25\"} 0\nrummigo_ai_solve_seconds_bucket{le=\"0.5\"} 1\n")
+	assert.Contains(t, out, "rummigo_ai_solve_seconds_bucket{le=\"60\"} 1\nrummigo_ai_solve_seconds_bucket{le=\"+Inf\"} 2\nrummigo_ai_solve_seconds_sum 120.3\nrummigo_ai_solve_seconds_count 2\n")
+
+	// every line is a comment or a sample.
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		assert.True(t, strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "rummigo_"), "unexpected line %q", line)
+	}
+}
+
+func TestMetrics_EscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabelValue("a\"b\\c\nd"))
+}
+
+func TestMetrics_ObserveMove(t *testing.T) {
+	m := NewMetrics()
+	m.ObserveMove(rummikub.GAME_WON, nil)
+	m.ObserveMove("", &rummikub.RuleViolation{Code: rummikub.NOT_OWNED})
+	m.ObserveMove("", rummikub.NOT_YOUR_TURN)
+	m.ObserveMove("", errors.New("something else"))
+
+	assert.Equal(t, 1.0, m.moves.Value(string(rummikub.GAME_WON)))
+	assert.Equal(t, 1.0, m.moves.Value(string(rummikub.NOT_OWNED)))
+	assert.Equal(t, 1.0, m.moves.Value(string(rummikub.NOT_YOUR_TURN)))
+	assert.Equal(t, 1.0, m.moves.Value(MOVE_REJECTED_OTHER))
+}
+
+func TestMetrics_Endpoint(t *testing.T) {
+	shutdownActiveGames(t)
+	metrics = NewMetrics()
+	activeGamesStore = &ActiveGameStore{runningGames: make(map[string]*ActiveGame)}
+	defer func() {
+		shutdownActiveGames(t)
+		metrics = NewMetrics()
+		activeGamesStore = &ActiveGameStore{runningGames: make(map[string]*ActiveGame)}
+	}()
+
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	scrape := func() string {
+		resp, err := http.Get(ts.URL + METRICS)
+		assert.NoError(t, err, "Error sending request to mock server")
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+		assert.Equal(t, METRICS_CONTENT_TYPE, resp.Header.Get("Content-Type"))
+		return bodyToString(resp.Body)
+	}
+
+	gameID, game := storeTestGame(t)
+	assert.Contains(t, scrape(), "rummigo_games_created_total 1\n")
+
+	// connect the player whose turn it is, and have it forfeit its turn.
+	player := game.CurrentPlayer().Name
+	conn, _, err := websocket.DefaultDialer.Dial("ws:"+trimHTTPproto(ts.URL)+SUBSCRIBE+"/"+gameID+"/"+player, nil)
+	if !assert.NoError(t, err, "error subscribing to game") {
+		return
+	}
+	defer conn.Close()
+	assert.NoError(t, conn.WriteJSON(Envelope{Version: PROTOCOL_VERSION, RequestID: "1", MessageType: FORFEIT}))
+	for {
+		var env Envelope
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if !assert.NoError(t, conn.ReadJSON(&env)) || env.RequestID == "1" {
+			assert.Equal(t, MOVE_ACCEPTED, env.MessageType)
+			break
+		}
+	}
+
+	out := scrape()
+	assert.Contains(t, out, "rummigo_games_active 1\n")
+	assert.Contains(t, out, "rummigo_connected_clients{game_id=\""+gameID+"\"} 1\n")
+	assert.Contains(t, out, "rummigo_moves_total{outcome=\"forfeited\"} 1\n")
+	assert.Contains(t, out, "rummigo_websocket_sends_pending 0\n")
+	assert.NotContains(t, out, "rummigo_websocket_send_wait_seconds_count 0\n")
+
+	// the per-game sample is removed once the game has been closed.
+	aGame := activeGamesStore.get(gameID)
+	if assert.NotNil(t, aGame) {
+		aGame.Close()
+		<-aGame.Done()
+	}
+	assert.NotContains(t, scrape(), "game_id=\""+gameID+"\"")
+}
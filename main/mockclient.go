@@ -11,21 +11,28 @@ import (
 	"time"
 )
 
-// TODO clean up race conditions in this code
-
 type MockClient struct {
 	playerImage *rummikub.Player
 	isFirstMove bool
 	connection  *websocket.Conn
 	rules       rummikub.Rules
 
-	// the unbuffered channel to store the moves on
-	movesToSend chan rummikub.Move
+	// the unbuffered channel to store the outgoing messages on
+	outbox chan Envelope
+
+	// buffered channel on which all responses to requests (i.e. messages with a request ID) are stored.
+	responses chan Envelope
+
+	// counter used to generate request IDs
+	requestCount int
 
 	// buffered (1) channel that is sent on the moment it is this player's turn
 	turnAwaiter chan bool
 
-	//lockable image of the game
+	// closed when the listener has returned, after the connection has been closed.
+	done chan struct{}
+
+	//lockable image of the game. The lock also guards the hand in the playerImage and isFirstMove, which the listener updates.
 	gameSnap struct {
 		sync.Mutex
 		state GameSnapshot
@@ -35,7 +42,7 @@ type MockClient struct {
 // Initiate a mock client instance by connecting to an ActiveGame server.
 func ConnectMockClient(name, url string, gamerules rummikub.Rules, t *testing.T) *MockClient {
 	// initiate a MockClient instance
-	m := MockClient{rules: gamerules, movesToSend: make(chan rummikub.Move), turnAwaiter: make(chan bool, 1)}
+	m := MockClient{rules: gamerules, outbox: make(chan Envelope), responses: make(chan Envelope, 100), turnAwaiter: make(chan bool, 1), done: make(chan struct{})}
 
 	// initiate a Player to hold the hand state and to back the move-making methods
 	// Also serves to hold the player name
@@ -59,6 +66,7 @@ func ConnectMockClient(name, url string, gamerules rummikub.Rules, t *testing.T)
 
 	// start the listener
 	go func() {
+		defer close(m.done)
 		for {
 			_, messageBytes, err := conn.ReadMessage()
 
@@ -78,36 +86,58 @@ func ConnectMockClient(name, url string, gamerules rummikub.Rules, t *testing.T)
 			}
 
 			if err := json.Unmarshal(messageBytes, &env); err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
+			}
+			if env.Version != PROTOCOL_VERSION {
+				t.Errorf("unexpected protocol version: %v", env.Version)
 			}
 
 			switch env.MessageType {
 			case HAND_UPDATE:
 				var s HandSnapshot
 				if err := json.Unmarshal(msg, &s); err != nil {
-					t.Fatal(err)
+					t.Error(err)
+					return
 				}
 
+				m.gameSnap.Lock()
 				m.playerImage.SetHand(s.Hand)
 				m.isFirstMove = s.IsFirstMove
+				m.gameSnap.Unlock()
 
 			case GAME_SNAPSHOT:
 				var s GameSnapshot
 				if err := json.Unmarshal(msg, &s); err != nil {
-					t.Fatal(err)
+					t.Error(err)
+					return
 				}
 
 				m.gameSnap.Lock()
 				m.gameSnap.state = s
 				t.Log(m.gameSnap.state.Table)
 				m.gameSnap.Unlock()
-				myTurn := m.gameSnap.state.CurrentPlayer == m.playerImage.Name
+				myTurn := s.CurrentPlayer == m.playerImage.Name
 				if myTurn {
-					m.turnAwaiter <- true
+					// a pending signal already tells that it is this player's turn.
+					select {
+					case m.turnAwaiter <- true:
+					default:
+					}
 				}
 
+			case MOVE_ACCEPTED, MOVE_REJECTION, HINT, RESIGNED, PONG, ERROR_MESSAGE, CHAT_MESSAGE, CHAT_HISTORY, SERVER_SHUTDOWN, GAME_ABORTED:
+				// handled by the caller through the responses channel.
+
 			default:
-				t.Fatalf("unknown message type: %q", env.MessageType)
+				t.Errorf("unknown message type: %q", env.MessageType)
+				return
+			}
+
+			// keep the responses to requests (with the raw payload) for inspection.
+			if env.RequestID != "" {
+				env.Payload = msg
+				m.responses <- env
 			}
 		}
 	}()
@@ -115,9 +145,9 @@ func ConnectMockClient(name, url string, gamerules rummikub.Rules, t *testing.T)
 	// start the sender (to make sure there is only one goroutine writing to the channel
 	go func() {
 		for {
-			move, ok := <-m.movesToSend
+			env, ok := <-m.outbox
 			if !ok {
-				t.Logf("Move sending channel closed. Terminating mock client.")
+				t.Logf("Sending channel closed. Terminating mock client.")
 				return
 			}
 
@@ -125,12 +155,7 @@ func ConnectMockClient(name, url string, gamerules rummikub.Rules, t *testing.T)
 			if err != nil {
 				return
 			}
-			msg, err := json.Marshal(Envelope{
-				MessageType: MOVE_PROPOSAL,
-				Payload: struct {
-					Table []rummikub.BrickCombination `json:"table"`
-				}{move.Arrangement},
-			})
+			msg, err := json.Marshal(env)
 
 			fmt.Println(string(msg))
 			if err != nil {
@@ -171,24 +196,56 @@ func (m *MockClient) AwaitTurn() {
 
 // Makes a move based on the reflection of the game state in the struct.
 func (m *MockClient) MakeMove() {
-	gameImage := m.GetGameImage()
+	m.gameSnap.Lock()
+	gameImage := m.gameSnap.state
+	player := *m.playerImage
+	isFirstMove := m.isFirstMove
+	m.gameSnap.Unlock()
+
 	// if the game has been won, close the send channel
 	if gameImage.HasBeenWon {
-		close(m.movesToSend)
+		close(m.outbox)
 		return
 	}
 
-	// check if it is the player's first move
-	minVal := 0
-	if m.isFirstMove {
-		minVal = m.rules.FirstMoveValue
-	}
-
 	// build the move using the solver.
-	move := m.playerImage.MakeMove(gameImage.Table, minVal)
+	move := player.MakeMove(gameImage.Table, m.rules, isFirstMove)
 
 	// put the move in the send queue
-	m.movesToSend <- move
+	m.Request(MOVE_PROPOSAL, struct {
+		Table []rummikub.BrickCombination `json:"table"`
+	}{move.Arrangement})
+}
+
+// Request queues a message of the given type for sending and returns its request ID.
+// The response can be read from the responses channel (see AwaitResponse).
+func (m *MockClient) Request(messageType string, payload interface{}) string {
+	m.requestCount++
+	requestID := fmt.Sprintf("%v-%v", m.playerImage.Name, m.requestCount)
+	m.outbox <- Envelope{
+		Version:     PROTOCOL_VERSION,
+		RequestID:   requestID,
+		MessageType: messageType,
+		Payload:     payload,
+	}
+	return requestID
+}
+
+// AwaitResponse blocks until a response is received, or until the timeout expires.
+// The payload of the returned envelope is the raw json.RawMessage.
+func (m *MockClient) AwaitResponse(timeout time.Duration) (Envelope, bool) {
+	select {
+	case env := <-m.responses:
+		return env, true
+	case <-time.After(timeout):
+		return Envelope{}, false
+	}
+}
+
+// Close closes the connection, and waits for the listener to return.
+func (m *MockClient) Close() {
+	m.connection.Close()
+	<-m.done
 }
 
 // retrieve the image that the mock client has of the game state
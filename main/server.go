@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,14 +23,43 @@ const (
 
 	CONTENT_JSON = "application/json; charset=utf-8"
 
+	// the time allowed to save the active games and close the connections on shutdown.
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+
+	// the notice sent to all clients on shutdown.
+	SHUTDOWN_NOTICE = "The server is shutting down. Your game has been saved."
+
+	// the environment variable holding the minimum time an AI player takes for its move (e.g. "1.5s"). None if not set.
+	AI_THINKING_DELAY_ENV = "RUMMIGO_AI_THINKING_DELAY"
+
 	// API resources
 	GAME_RESOURCE   = "game_id"
 	PLAYER_RESOURCE = "player_name"
+	LOBBY_RESOURCE  = "lobby_id"
 
 	// endpoints
 	GAME_ROOT = "/game"
+	GAME_HINT = "/hint"
+
+	GAMES_ROOT     = "/games"
+	GAMES_HISTORY  = "/history"
+	GAMES_SCORES   = "/scores"
+	GAMES_ANALYSIS = "/analysis"
+	GAMES_ABORT    = "/abort"
+	GAMES_SAVE     = "/save"
+
+	OPENAPI = "/openapi.json"
 
 	SUBSCRIBE = "/subscribe"
+
+	LOBBY_ROOT      = "/lobby"
+	LOBBY_SUBSCRIBE = "/lobby/subscribe"
+
+	// the JSON schema describing the websocket protocol
+	PROTOCOL_SCHEMA = "/protocol/schema.json"
+
+	// the server metrics, in the Prometheus text exposition format
+	METRICS = "/metrics"
 )
 
 // declare the logger globally
@@ -39,6 +71,9 @@ var gameDB *GameDatabase
 // store the running games in memory
 var activeGamesStore *ActiveGameStore
 
+// the lobby in which players find each other
+var lobby *Lobby
+
 // declare the upgrader globally
 // TODO security; origin policy
 var upgrader = websocket.Upgrader{}
@@ -78,8 +113,34 @@ func buildServeMux() *mux.Router {
 	// register the game flow handlers
 	mux.Handle(GAME_ROOT, baseChain.Then(apollo.HandlerFunc(newGame))).Methods("POST")
 	mux.Handle(fmt.Sprintf("%v/{%v}/{%v}", GAME_ROOT, GAME_RESOURCE, PLAYER_RESOURCE), baseChain.Then(apollo.HandlerFunc(getHand))).Methods("GET")
+	mux.Handle(fmt.Sprintf("%v/{%v}/{%v}%v", GAME_ROOT, GAME_RESOURCE, PLAYER_RESOURCE, GAME_HINT), baseChain.Then(apollo.HandlerFunc(requestHint))).Methods("POST")
 	//mux.Handle(fmt.Sprintf("%v/{%v}", GAME_ROOT, GAME_RESOURCE), baseChain.Then(apollo.HandlerFunc(getState))).Methods("GET")
 
+	// register the REST API
+	gameResource := fmt.Sprintf("%v/{%v}", GAMES_ROOT, GAME_RESOURCE)
+	adminChain := apollo.New(requireAdmin).With(ctx)
+	mux.Handle(GAMES_ROOT, baseChain.Then(apollo.HandlerFunc(listGames))).Methods("GET")
+	mux.Handle(gameResource, baseChain.Then(apollo.HandlerFunc(getGameState))).Methods("GET")
+	mux.Handle(gameResource+GAMES_HISTORY, baseChain.Then(apollo.HandlerFunc(getGameHistory))).Methods("GET")
+	mux.Handle(gameResource+GAMES_SCORES, baseChain.Then(apollo.HandlerFunc(getGameScores))).Methods("GET")
+	mux.Handle(gameResource+GAMES_ANALYSIS, baseChain.Then(apollo.HandlerFunc(getGameAnalysis))).Methods("GET")
+	mux.Handle(gameResource, adminChain.Then(apollo.HandlerFunc(deleteGame))).Methods("DELETE")
+	mux.Handle(gameResource+GAMES_ABORT, adminChain.Then(apollo.HandlerFunc(abortGame))).Methods("POST")
+	mux.Handle(gameResource+GAMES_SAVE, adminChain.Then(apollo.HandlerFunc(saveGame))).Methods("POST")
+	mux.Handle(OPENAPI, baseChain.Then(apollo.HandlerFunc(openAPI))).Methods("GET")
+
+	// register the protocol description
+	mux.Handle(PROTOCOL_SCHEMA, baseChain.Then(apollo.HandlerFunc(protocolSchema))).Methods("GET")
+
+	// register the metrics
+	mux.Handle(METRICS, baseChain.Then(apollo.HandlerFunc(serveMetrics))).Methods("GET")
+
+	// register the lobby handlers
+	mux.Handle(LOBBY_ROOT, baseChain.Then(apollo.HandlerFunc(listOpenGames))).Methods("GET")
+	mux.Handle(LOBBY_ROOT, baseChain.Then(apollo.HandlerFunc(openGame))).Methods("POST")
+	mux.Handle(LOBBY_SUBSCRIBE, baseChain.Then(apollo.HandlerFunc(subscribeToLobby)))
+	mux.Handle(fmt.Sprintf("%v/{%v}/{%v}", LOBBY_ROOT, LOBBY_RESOURCE, PLAYER_RESOURCE), baseChain.Then(apollo.HandlerFunc(joinOpenGame))).Methods("POST")
+
 	// the upgrade route handler.
 	mux.Handle(fmt.Sprintf("%v/{%v}/{%v}", SUBSCRIBE, GAME_RESOURCE, PLAYER_RESOURCE), baseChain.Then(apollo.HandlerFunc(subscribeToGame))) //.Methods("UPGRADE")
 
@@ -88,12 +149,17 @@ func buildServeMux() *mux.Router {
 
 func init() {
 	gameDB = &GameDatabase{
-		gameStore: make(map[string]*rummikub.GameState),
+		gameStore:     make(map[string]*rummikub.GameState),
+		chatStore:     make(map[string][]ChatMessage),
+		idRandomBytes: DEFAULT_ID_RANDOM_BYTES,
+		aborted:       make(map[string]bool),
 	}
 
 	activeGamesStore = &ActiveGameStore{
 		runningGames: make(map[string]*ActiveGame),
 	}
+
+	lobby = NewLobby(rummikub.NewDefaultRules(), lobbyFillTimeout)
 }
 
 func main() {
@@ -108,12 +174,73 @@ func main() {
 	// print startup message
 	logger.Info("Initiating application state...")
 
+	// log the positions on which a solver came up with an arrangement that can not be played, so that they can be reproduced.
+	rummikub.LogInvalidSolution = func(c *rummikub.InvalidSolution) {
+		logger.WithFields(logrus.Fields{
+			"reason": c.Reason,
+			"case":   c.String(),
+		}).Error("AI player's solver returned an invalid arrangement. Forfeiting instead.")
+	}
+
+	// enable the admin endpoints if a token has been configured.
+	adminToken = os.Getenv(ADMIN_TOKEN_ENV)
+	if adminToken == "" {
+		logger.Warnf("%v not set. The admin endpoints are disabled.", ADMIN_TOKEN_ENV)
+	}
+
+	// slow the AI players down if a thinking delay has been configured.
+	if delay := os.Getenv(AI_THINKING_DELAY_ENV); delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			logger.WithField("error", err).Fatalf("Invalid %v.", AI_THINKING_DELAY_ENV)
+		}
+		aiThinkingDelay = d
+	}
+
+	// lengthen (or shorten) the game IDs if configured.
+	if n := os.Getenv(ID_RANDOM_BYTES_ENV); n != "" {
+		randomBytes, err := strconv.Atoi(n)
+		if err == nil {
+			err = gameDB.SetIDRandomBytes(randomBytes)
+		}
+		if err != nil {
+			logger.WithField("error", err).Fatalf("Invalid %v.", ID_RANDOM_BYTES_ENV)
+		}
+	}
+
 	// start the server
 	logger.Info("Starting http server at ", PORT)
 
 	// initiate the server object
 	server := NewServer()
 
-	// run it, blocking the main thread.
-	server.ListenAndServe()
+	// run it in the background, so that the main thread can wait for the termination signal.
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.WithField("error", err).Fatal("Server stopped unexpectedly.")
+		}
+	}()
+
+	// block until the server is told to terminate.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	logger.Infof("Received %v. Shutting down...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := shutdown(ctx, server); err != nil {
+		logger.WithField("error", err).Error("Server was not shut down gracefully.")
+		os.Exit(1)
+	}
+	logger.Info("Server shut down.")
+}
+
+// shutdown saves all active games, after notifying their clients, and then stops the server.
+// The active games are closed first, as the server does not keep track of the (hijacked) websocket connections.
+func shutdown(ctx context.Context, server *http.Server) error {
+	if err := activeGamesStore.Shutdown(ctx, SHUTDOWN_NOTICE); err != nil {
+		return err
+	}
+	return server.Shutdown(ctx)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestMain(m *testing.M) {
+	// the tests share a single logger, since the games and lobby clients of earlier tests may still be logging.
+	logger, _ = test.NewNullLogger()
+	os.Exit(m.Run())
+}
+
+// shutdownActiveGames closes the games that earlier tests left in the active game store, so that the global
+// state they use can be replaced.
+func shutdownActiveGames(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, activeGamesStore.Shutdown(ctx, "test cleanup"), "not all active games were closed")
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	shutdownActiveGames(t)
+	activeGamesStore = &ActiveGameStore{runningGames: make(map[string]*ActiveGame)}
+	defer func() {
+		activeGamesStore = &ActiveGameStore{runningGames: make(map[string]*ActiveGame)}
+	}()
+
+	ts := httptest.NewServer(buildServeMux())
+	defer ts.Close()
+
+	// two games with a single connected player each (so that the AI never moves and the games never start).
+	gameIDs := []string{}
+	conns := []*websocket.Conn{}
+	for i := 0; i < 2; i++ {
+		gamestate, err := rummikub.NewGame(rummikub.NewDefaultRules(), 88, rummikub.NewHumanPlayer("alice"), rummikub.NewHumanPlayer("bob"))
+		assert.NoError(t, err, "error initiating game")
+		gameID := gameDB.StoreNewGame(gamestate)
+		gameIDs = append(gameIDs, gameID)
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws:"+trimHTTPproto(ts.URL)+SUBSCRIBE+"/"+gameID+"/alice", nil)
+		if !assert.NoError(t, err, "error subscribing to game") {
+			return
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	// wait for both subscriptions to be processed
+	for _, conn := range conns {
+		var env Envelope
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(t, conn.ReadJSON(&env))
+	}
+
+	// shut down, and make sure every client receives the notice before its connection is closed.
+	shutdownErr := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- shutdown(ctx, ts.Config)
+	}()
+
+	for i, conn := range conns {
+		noticed := false
+		for {
+			var env Envelope
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.ReadJSON(&env); err != nil {
+				break
+			}
+			if env.MessageType == SERVER_SHUTDOWN {
+				noticed = true
+				assert.Equal(t, SHUTDOWN_NOTICE, env.Payload)
+			}
+		}
+		assert.True(t, noticed, "client %v did not receive the shutdown notice", i)
+	}
+	assert.NoError(t, <-shutdownErr, "shutdown not completed")
+
+	// every game was closed and saved.
+	for _, gameID := range gameIDs {
+		assert.False(t, activeGamesStore.contains(gameID), "game %v still active", gameID)
+		assert.NotNil(t, gameDB.GetChatHistory(gameID), "game %v not saved", gameID)
+	}
+
+	// no new subscriptions are accepted.
+	ts2 := httptest.NewServer(buildServeMux())
+	defer ts2.Close()
+	_, resp, err := websocket.DefaultDialer.Dial("ws:"+trimHTTPproto(ts2.URL)+SUBSCRIBE+"/"+gameIDs[0]+"/alice", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestActiveGameStore_Shutdown_Deadline(t *testing.T) {
+	store := &ActiveGameStore{runningGames: make(map[string]*ActiveGame)}
+
+	// a game whose gameManager never responds
+	stuck := &ActiveGame{ID: "stuck", closer: make(chan *Envelope), done: make(chan struct{})}
+	store.runningGames[stuck.ID] = stuck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, store.Shutdown(ctx, SHUTDOWN_NOTICE), "shutdown should fail after the deadline")
+	assert.True(t, store.isShuttingDown())
+}
+
+func TestActiveGameStore_GetOrActivate(t *testing.T) {
+	store := &ActiveGameStore{runningGames: make(map[string]*ActiveGame)}
+
+	// games are activated once, and only if they exist.
+	activations := 0
+	activate := func() *ActiveGame {
+		activations++
+		return &ActiveGame{ID: "game", closer: make(chan *Envelope), done: make(chan struct{})}
+	}
+	aGame, err := store.getOrActivate("game", activate)
+	assert.NoError(t, err)
+	again, err := store.getOrActivate("game", activate)
+	assert.NoError(t, err)
+	assert.True(t, aGame == again, "the game was activated twice")
+	assert.Equal(t, 1, activations)
+
+	missing, err := store.getOrActivate("missing", func() *ActiveGame { return nil })
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+	assert.False(t, store.contains("missing"))
+
+	// once the store is shutting down, no game is activated (it would never be saved), and no active game is handed out.
+	store.remove("game")
+	assert.NoError(t, store.Shutdown(context.Background(), SHUTDOWN_NOTICE))
+	_, err = store.getOrActivate("game", activate)
+	assert.True(t, errors.Is(err, errShuttingDown))
+	assert.Equal(t, 1, activations)
+	assert.Equal(t, 0, store.count())
+}
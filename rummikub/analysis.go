@@ -0,0 +1,228 @@
+package rummikub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Play sums up the bricks put on the table in a move.
+type Play struct {
+	Bricks int `json:"bricks"`
+	Value  int `json:"value"`
+}
+
+// newPlay sums up the move from the table to the arrangement, valuing the jokers as the bricks they stand in for (see Rules.PlacedValue).
+func (game *GameState) newPlay(table []BrickCombination, arrangement []BrickCombination) Play {
+	return Play{
+		Bricks: len(BrickSliceDiff(DissolveCombinations(table), DissolveCombinations(arrangement))),
+		Value:  game.getRules().PlacedValue(table, arrangement),
+	}
+}
+
+// MoveAnalysis compares a move made by a human player with the moves the solver would have made in its place.
+type MoveAnalysis struct {
+	// the index of the move in the move history.
+	Turn       int    `json:"turn"`
+	PlayerName string `json:"player_name"`
+	FirstMove  bool   `json:"first_move"`
+
+	// the bricks the player put on the table.
+	Played Play `json:"played"`
+
+	// the optimal moves, putting the most bricks or the most value on the table.
+	// On a first move, only moves that satisfy the first move rules are considered (see SolveFirstMove).
+	MostBricks Play `json:"most_bricks"`
+	MostValue  Play `json:"most_value"`
+
+	// the number and value of the bricks the player could have put on the table in addition to the ones it did.
+	BricksLeft int `json:"bricks_left"`
+	ValueLeft  int `json:"value_left"`
+
+	// whether the player could have emptied its hand, but did not.
+	MissedWin bool `json:"missed_win"`
+
+	// whether the player forfeited its first move, while it could have made a legal first move.
+	MissedFirstMove bool `json:"missed_first_move"`
+}
+
+// PlayerAnalysis sums up the move analyses of a single player.
+type PlayerAnalysis struct {
+	Moves            int `json:"moves"`
+	BricksLeft       int `json:"bricks_left"`
+	ValueLeft        int `json:"value_left"`
+	MissedWins       int `json:"missed_wins"`
+	MissedFirstMoves int `json:"missed_first_moves"`
+}
+
+// GameAnalysis is the post-game analysis of the moves made by the human players.
+type GameAnalysis struct {
+	Moves   []MoveAnalysis            `json:"moves"`
+	Players map[string]PlayerAnalysis `json:"players"`
+}
+
+// ErrInconsistentHistory is returned by Analyze if the hand histories of the players do not match the move history.
+var ErrInconsistentHistory = errors.New("the hand histories do not match the move history")
+
+// Analyze replays the game, and compares each move made by a human player with the optima found by the solver
+// (maximizing both the number of bricks and the value put on the table) for the hand and table of that turn.
+// Returns ErrInconsistentHistory if the game can not be replayed, or the solver's error.
+func (game *GameState) Analyze(solver Solver) (*GameAnalysis, error) {
+	hands, err := game.handsBeforeMoves()
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &GameAnalysis{Moves: []MoveAnalysis{}, Players: make(map[string]PlayerAnalysis)}
+	for _, p := range game.Players {
+		if p.Human {
+			analysis.Players[p.Name] = PlayerAnalysis{}
+		}
+	}
+
+	firstMoves := make(map[string]bool)
+	table := []BrickCombination{}
+	for turn, move := range game.MoveHistory {
+		// a player's first move is the first one to put bricks on the table; forfeits do not count.
+		firstMove := !firstMoves[move.PlayerName]
+		if len(BrickSliceDiff(DissolveCombinations(table), move.Bricks())) > 0 {
+			firstMoves[move.PlayerName] = true
+		}
+
+		if summary, ok := analysis.Players[move.PlayerName]; ok {
+			ma, err := game.analyzeMove(solver, turn, hands[turn], table, firstMove)
+			if err != nil {
+				return nil, err
+			}
+			analysis.Moves = append(analysis.Moves, ma)
+
+			summary.Moves++
+			summary.BricksLeft += ma.BricksLeft
+			summary.ValueLeft += ma.ValueLeft
+			if ma.MissedWin {
+				summary.MissedWins++
+			}
+			if ma.MissedFirstMove {
+				summary.MissedFirstMoves++
+			}
+			analysis.Players[move.PlayerName] = summary
+		}
+
+		table = move.Arrangement
+	}
+
+	return analysis, nil
+}
+
+// AnalyzeContext is Analyze, giving up once the context is done: the error is then the context's error.
+// As the solver can not be interrupted, the solve that is running at that time finishes in the background (see solveWithin).
+func (game *GameState) AnalyzeContext(ctx context.Context, solver Solver) (*GameAnalysis, error) {
+	return game.Analyze(&contextBoundSolver{ctx: ctx, solver: solver, rules: game.getRules()})
+}
+
+// contextBoundSolver runs each solve of its solver (including its first moves, see SolveFirstMove) until the context is done.
+type contextBoundSolver struct {
+	ctx    context.Context
+	solver Solver
+	rules  Rules
+}
+
+func (s *contextBoundSolver) Solve(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	return s.solveWithin(hand, table, maximizeValue, s.solver.Solve)
+}
+
+func (s *contextBoundSolver) SolveFirstMove(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	return s.solveWithin(hand, table, maximizeValue, func(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+		return SolveFirstMove(s.solver, s.rules, hand, table, maximizeValue)
+	})
+}
+
+func (s *contextBoundSolver) solveWithin(hand []Brick, table []BrickCombination, maximizeValue bool,
+	solve func(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error)) ([]BrickCombination, []Brick, error) {
+
+	result, err := solveWithin(s.ctx, 0, solveSlotOf(s.solver), forfeitResult(hand, table, maximizeValue), func(func(SolveResult)) (SolveResult, error) {
+		arrangement, bricks, err := solve(hand, table, maximizeValue)
+		return optimalResult(arrangement, bricks, maximizeValue), err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Arrangement, result.BricksToPut, nil
+}
+
+// analyzeMove compares the move at the given turn with the solver's optima.
+func (game *GameState) analyzeMove(solver Solver, turn int, hand []Brick, table []BrickCombination, firstMove bool) (MoveAnalysis, error) {
+	move := game.MoveHistory[turn]
+	ma := MoveAnalysis{
+		Turn:       turn,
+		PlayerName: move.PlayerName,
+		FirstMove:  firstMove,
+		Played:     game.newPlay(table, move.Arrangement),
+	}
+
+	solve := solver.Solve
+	if firstMove {
+		// only moves that satisfy the first move rules are considered.
+		solve = func(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+			return SolveFirstMove(solver, game.getRules(), hand, table, maximizeValue)
+		}
+	}
+
+	arrangement, _, err := solve(hand, table, false)
+	if err != nil {
+		return ma, err
+	}
+	ma.MostBricks = game.newPlay(table, arrangement)
+
+	arrangement, _, err = solve(hand, table, true)
+	if err != nil {
+		return ma, err
+	}
+	ma.MostValue = game.newPlay(table, arrangement)
+
+	if firstMove {
+		ma.MissedFirstMove = ma.Played.Bricks == 0 && ma.MostValue.Bricks > 0
+	}
+
+	// a solver that does not find the optimum may be outplayed.
+	ma.BricksLeft = maxInt(ma.MostBricks.Bricks-ma.Played.Bricks, 0)
+	ma.ValueLeft = maxInt(ma.MostValue.Value-ma.Played.Value, 0)
+	ma.MissedWin = ma.MostBricks.Bricks == len(hand) && ma.Played.Bricks < len(hand)
+
+	return ma, nil
+}
+
+// handsBeforeMoves returns, for each move in the move history, the hand the player made it from.
+// Every move that puts bricks on the table adds a hand to the player's hand history, as does every forfeit on which a brick is drawn.
+func (game *GameState) handsBeforeMoves() ([][]Brick, error) {
+	next := make(map[string]int) // the index of each player's current hand in its hand history.
+	hands := make([][]Brick, 0, len(game.MoveHistory))
+
+	table := []BrickCombination{}
+	for turn, move := range game.MoveHistory {
+		player := game.GetPlayer(move.PlayerName)
+		if player == nil || next[move.PlayerName] >= len(player.HandHistory) {
+			return nil, fmt.Errorf("move %v by %q: %w", turn, move.PlayerName, ErrInconsistentHistory)
+		}
+		i := next[move.PlayerName]
+		hand := player.HandHistory[i]
+		hands = append(hands, hand)
+
+		played := len(BrickSliceDiff(DissolveCombinations(table), move.Bricks())) > 0
+		drew := !played && i+1 < len(player.HandHistory) && len(player.HandHistory[i+1]) == len(hand)+1
+		if played || drew {
+			next[move.PlayerName]++
+		}
+
+		table = move.Arrangement
+	}
+
+	return hands, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
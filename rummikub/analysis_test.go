@@ -0,0 +1,132 @@
+package rummikub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGame_Analyze(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+
+	run := []Brick{{Value: 10, Color: "red"}, {Value: 11, Color: "red"}, {Value: 12, Color: "red"}, {Value: 13, Color: "red"}}
+	playerA := NewHumanPlayer("A")
+	playerA.SetHand(run)
+	playerB := NewHumanPlayer("B")
+	playerB.SetHand([]Brick{{Value: 1, Color: "yellow"}, {Value: 2, Color: "yellow"}, {Value: 3, Color: "yellow"}})
+	game, err := NewEmptyGame(NewDefaultRules(), playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
+
+	// the first forfeit draws the last brick from the pile; the second one does not draw anything.
+	game.Pile = []Brick{{Value: 5, Color: "green"}}
+	moves := []Move{
+		NewMove("A", []BrickCombination{}),
+		NewMove("B", []BrickCombination{}),
+		NewMove("A", []BrickCombination{NewBrickCombination(run[:3]...)}),
+		NewMove("B", []BrickCombination{NewBrickCombination(run[:3]...)}),
+	}
+	for _, m := range moves {
+		_, err := game.ProcessMove(m)
+		assert.NoError(t, err)
+	}
+
+	analysis, err := game.Analyze(solver)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, analysis.Moves, 4)
+
+	// A forfeited while it could have played its run.
+	a := analysis.Moves[0]
+	assert.True(t, a.FirstMove)
+	assert.Equal(t, Play{}, a.Played)
+	assert.Equal(t, Play{4, 46}, a.MostBricks)
+	assert.Equal(t, Play{4, 46}, a.MostValue)
+	assert.Equal(t, 4, a.BricksLeft)
+	assert.Equal(t, 46, a.ValueLeft)
+	assert.True(t, a.MissedFirstMove)
+	assert.True(t, a.MissedWin)
+
+	// B could not reach the first move threshold.
+	b := analysis.Moves[1]
+	assert.Equal(t, Play{}, b.MostValue)
+	assert.False(t, b.MissedFirstMove)
+	assert.Equal(t, 0, b.BricksLeft)
+
+	// A played three bricks of its run, and kept the last one and the drawn brick.
+	a = analysis.Moves[2]
+	assert.Equal(t, Play{3, 33}, a.Played)
+	assert.Equal(t, Play{4, 46}, a.MostBricks)
+	assert.Equal(t, 1, a.BricksLeft)
+	assert.Equal(t, 13, a.ValueLeft)
+	assert.False(t, a.MissedWin, "the drawn brick could not have been played")
+	assert.False(t, a.MissedFirstMove)
+
+	// B has only forfeited so far: the threshold still applies, even though B could have laid its run next to A's.
+	b = analysis.Moves[3]
+	assert.True(t, b.FirstMove)
+	assert.Equal(t, Play{}, b.MostBricks)
+	assert.Equal(t, 0, b.BricksLeft)
+	assert.False(t, b.MissedWin)
+
+	assert.Equal(t, PlayerAnalysis{Moves: 2, BricksLeft: 5, ValueLeft: 59, MissedWins: 1, MissedFirstMoves: 1}, analysis.Players["A"])
+	assert.Equal(t, PlayerAnalysis{Moves: 2}, analysis.Players["B"])
+}
+
+func TestGame_Analyze_SkipsAIPlayers(t *testing.T) {
+	playerA := NewHumanPlayer("A")
+	playerA.SetHand([]Brick{{Value: 1, Color: "yellow"}})
+	playerB := NewAIPlayer("B", &DummySolver{})
+	playerB.SetHand([]Brick{{Value: 2, Color: "yellow"}})
+	game, err := NewEmptyGame(NewDefaultRules(), playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
+	game.Pile = []Brick{}
+	game.ProcessMove(NewMove("A", []BrickCombination{}))
+	game.ProcessMove(NewMove("B", []BrickCombination{}))
+
+	analysis, err := game.Analyze(&DummySolver{})
+	assert.NoError(t, err)
+	if assert.Len(t, analysis.Moves, 1) {
+		assert.Equal(t, "A", analysis.Moves[0].PlayerName)
+	}
+	assert.NotContains(t, analysis.Players, "B")
+}
+
+func TestGame_Analyze_InconsistentHistory(t *testing.T) {
+	game, err := NewEmptyGame(NewDefaultRules(), NewHumanPlayer("A"))
+	assert.NoError(t, err, "error initiating game")
+	game.MoveHistory = []Move{NewMove("A", []BrickCombination{})}
+
+	_, err = game.Analyze(&DummySolver{})
+	assert.True(t, errors.Is(err, ErrInconsistentHistory))
+}
+
+func TestGame_AnalyzeContext(t *testing.T) {
+	playerA := NewHumanPlayer("A")
+	playerA.SetHand(contextTestHand)
+	playerB := NewHumanPlayer("B")
+	playerB.SetHand([]Brick{{Value: 1, Color: "yellow"}})
+	game, err := NewEmptyGame(NewDefaultRules(), playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
+	game.Pile = []Brick{}
+	game.ProcessMove(NewMove("A", []BrickCombination{}))
+
+	// the analysis gives up on a solver that does not finish before the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = game.AnalyzeContext(ctx, &slowSolver{delay: time.Second})
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the analysis did not respect the context")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+
+	// a solver that finishes in time analyzes the game as Analyze does.
+	solver := NewILPSolver(NewDefaultRules())
+	expected, err := game.Analyze(solver)
+	assert.NoError(t, err)
+	analysis, err := game.AnalyzeContext(context.Background(), solver)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, analysis)
+}
@@ -2,14 +2,16 @@ package rummikub
 
 import (
 	"hash/fnv"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 const JokerColor string = "joker"
 
 // the central Brick struct.
 type Brick struct {
-	Value int    `json:"value"` // 1 or higher, jokers are valued at 0.
+	Value int    `json:"value"` // 1 or higher, jokers are valued at 1 (see BrickCombination.Jokers for the bricks they stand in for).
 	Color string `json:"color"` // any valid string.
 }
 
@@ -76,6 +78,10 @@ func DissolveCombinations(combinations []BrickCombination) []Brick {
 type BrickCombination struct {
 	// a legal combination of uniqueBricks (a row or a set)
 	Bricks []Brick `json:"bricks"`
+
+	// the bricks the jokers stand in for, in the order in which the jokers appear in Bricks.
+	// Either annotated by the player, or resolved by the game (see Rules.ResolveJokers).
+	Jokers []Brick `json:"jokers,omitempty"`
 }
 
 func NewBrickCombination(b ...Brick) BrickCombination {
@@ -96,20 +102,71 @@ func (c *BrickCombination) AddBrick(b ...Brick) {
 
 func (c *BrickCombination) Copy() BrickCombination {
 	// make a copy of the BrickCombination
-	return NewBrickCombination(c.Bricks...)
+	copied := NewBrickCombination(c.Bricks...)
+	if len(c.Jokers) > 0 {
+		copied.Jokers = append([]Brick{}, c.Jokers...)
+	}
+	return copied
+}
+
+// jokerCount returns the number of jokers in the combination.
+func (c *BrickCombination) jokerCount() int {
+	jokers := 0
+	for _, b := range c.Bricks {
+		if b.Color == JokerColor {
+			jokers++
+		}
+	}
+	return jokers
+}
+
+// Resolved returns the bricks of the combination, with the jokers replaced by the bricks they stand in for (see Rules.ResolveJokers).
+// Jokers that have not been resolved are left as they are.
+func (c *BrickCombination) Resolved() []Brick {
+	bricks := make([]Brick, len(c.Bricks))
+	j := 0
+	for i, b := range c.Bricks {
+		if b.Color == JokerColor && j < len(c.Jokers) {
+			b = c.Jokers[j]
+			j++
+		}
+		bricks[i] = b
+	}
+	return bricks
 }
 
-type CombinationIdentity uint32
+// CombinationIdentity is the exact identity of a BrickCombination: the multiset of its bricks.
+// Two combinations have the same identity if and only if they hold the same bricks, the same number of times.
+type CombinationIdentity string
 
-// Hash the BrickCombination (e.game. for use in de-duplication).
-//NOTE uses bitwise XOR to ignore brick order.
+// Hash returns the identity of the BrickCombination (e.g. for use in de-duplication).
+// NOTE the bricks are sorted to ignore brick order. What the jokers stand in for is not part of the identity.
 func (c *BrickCombination) Hash() CombinationIdentity {
-	var h uint32
-	for _, b := range c.Bricks {
-		h = h ^ b.Hash()
+	keys := make([]string, len(c.Bricks))
+	for i, b := range c.Bricks {
+		// the quoted color can not be confused with the value or the separators.
+		keys[i] = strconv.Quote(b.Color) + strconv.Itoa(b.Value)
+	}
+	sort.Strings(keys)
+	return CombinationIdentity(strings.Join(keys, ","))
+}
+
+// TableIdentity is the exact identity of a table: the multiset of its combinations.
+type TableIdentity string
+
+// TableHash returns the identity of the table, ignoring the order of the combinations (and of the bricks in them).
+func TableHash(table []BrickCombination) TableIdentity {
+	keys := make([]string, len(table))
+	for i, c := range table {
+		keys[i] = "[" + string(c.Hash()) + "]"
 	}
+	sort.Strings(keys)
+	return TableIdentity(strings.Join(keys, ""))
+}
 
-	return CombinationIdentity(h)
+// violation builds a RuleViolation that refers to the bricks of the combination.
+func (c *BrickCombination) violation(code ViolationCode) *RuleViolation {
+	return newViolation(code, c.getBricks()...)
 }
 
 // Contains checks if the combination contains a brick matching the provided value and color
@@ -122,27 +179,15 @@ func (c *BrickCombination) Contains(value int, color string) bool {
 	return false
 }
 
-// outcomes of matching BrickCombinations to game rules
-const (
-	COMBINATION_TOO_SMALL     = "combination is smaller than 3"
-	VALID_RUN                 = "combination is a valid run"
-	VALID_GROUP               = "combination is a valid group"
-	CONTAINS_ONLY_JOKERS      = "combination contains only jokers"
-	CONTAINS_MULTIPLE_VALUES  = "not a group: combination contains more than one unique value"
-	CONTAINS_MULTIPLE_COLORS  = "not a run: combination contains uniqueBricks of more than one color"
-	CONTAINS_DUPLICATE_VALUES = "not a run: combination contains duplicate values"
-	COLORS_NOT_UNIQUE         = "not a group: combination contains duplicates of a color"
-	NOT_CONSECUTIVE           = "not a run: brick values not consecutive or semi-consecutive (i.e. with joker)"
-)
-
-func (c *BrickCombination) IsValidGroup() (bool, string) {
+// IsValidGroup returns a *RuleViolation if the combination is not a valid group, or nil if it is.
+func (c *BrickCombination) IsValidGroup() error {
 	// check if the combination is a valid group
 
 	// is longer than 3?
 	// NOTE that runs longer than len(allowedColors) are caught either here or at the game rules legality-level check
 	// due to their colors not being unique (this function) or their colors not being in allowedColors (game rules legality check).
 	if len(c.Bricks) < 3 {
-		return false, COMBINATION_TOO_SMALL
+		return c.violation(COMBINATION_TOO_SMALL)
 	}
 
 	// contains something else than just jokers?
@@ -155,7 +200,7 @@ func (c *BrickCombination) IsValidGroup() (bool, string) {
 		}
 	}
 	if !ok {
-		return false, CONTAINS_ONLY_JOKERS
+		return c.violation(CONTAINS_ONLY_JOKERS)
 	}
 
 	// are all values the same (ignoring the value of a joker )?
@@ -166,7 +211,7 @@ func (c *BrickCombination) IsValidGroup() (bool, string) {
 		}
 	}
 	if len(valueMap) > 1 { // number of keys
-		return false, CONTAINS_MULTIPLE_VALUES
+		return c.violation(CONTAINS_MULTIPLE_VALUES)
 	}
 
 	// are all colors unique?
@@ -174,21 +219,22 @@ func (c *BrickCombination) IsValidGroup() (bool, string) {
 	colorMap := map[string]bool{}
 	for _, b := range c.Bricks {
 		if _, ok := colorMap[b.Color]; ok && b.Color != JokerColor {
-			return false, COLORS_NOT_UNIQUE
+			return c.violation(COLORS_NOT_UNIQUE)
 		} else {
 			colorMap[b.Color] = true
 		}
 	}
 
-	return true, VALID_GROUP
+	return nil
 }
 
-func (c *BrickCombination) IsValidRun() (bool, string) {
+// IsValidRun returns a *RuleViolation if the combination is not a valid run, or nil if it is.
+func (c *BrickCombination) IsValidRun() error {
 	// check if the combination is a valid run
 
 	// contains at least 3 uniqueBricks?
 	if len(c.Bricks) < 3 {
-		return false, COMBINATION_TOO_SMALL
+		return c.violation(COMBINATION_TOO_SMALL)
 	}
 
 	// contains something else than just jokers?
@@ -201,7 +247,7 @@ func (c *BrickCombination) IsValidRun() (bool, string) {
 		}
 	}
 	if !ok {
-		return false, CONTAINS_ONLY_JOKERS
+		return c.violation(CONTAINS_ONLY_JOKERS)
 	}
 
 	// contains only uniqueBricks of a single color ( not counting JokerColor )
@@ -212,7 +258,7 @@ func (c *BrickCombination) IsValidRun() (bool, string) {
 		}
 	}
 	if len(colorMap) > 1 { // number of keys
-		return false, CONTAINS_MULTIPLE_COLORS
+		return c.violation(CONTAINS_MULTIPLE_COLORS)
 	}
 
 	// does the combination only contain unique values?
@@ -220,7 +266,7 @@ func (c *BrickCombination) IsValidRun() (bool, string) {
 	valueMap := map[int]bool{}
 	for _, b := range c.Bricks {
 		if _, ok := valueMap[b.Value]; ok && b.Color != JokerColor {
-			return false, CONTAINS_DUPLICATE_VALUES
+			return c.violation(CONTAINS_DUPLICATE_VALUES)
 		} else {
 			valueMap[b.Value] = true
 		}
@@ -263,11 +309,11 @@ func (c *BrickCombination) IsValidRun() (bool, string) {
 			if jokerCount > 0 {
 				jokerCount--
 			} else {
-				return false, NOT_CONSECUTIVE
+				return c.violation(NOT_CONSECUTIVE)
 			}
 		}
 	}
 
-	return true, VALID_RUN
+	return nil
 
 }
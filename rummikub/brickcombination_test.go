@@ -1,6 +1,7 @@
 package rummikub
 
 import (
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -87,6 +88,38 @@ func TestBrickCombination_HashEquivalence(t *testing.T) {
 	assert.NotEqual(t, ComboA.Hash(), ComboB.Hash(), "BrickCombination Hash function incorrectly returns the same hash for dissimilar combinations!")
 }
 
+func TestBrickCombination_HashDuplicates(t *testing.T) {
+	// identical bricks must not cancel each other out.
+	r5 := Brick{Color: "red", Value: 5}
+	jokers := NewBrickCombination(MakeJoker(), MakeJoker(), r5)
+	single := NewBrickCombination(r5)
+	assert.NotEqual(t, jokers.Hash(), single.Hash(), "two jokers cancelled each other out")
+	onlyJokers, empty, oneJoker := NewBrickCombination(MakeJoker(), MakeJoker()), NewBrickCombination(), NewBrickCombination(MakeJoker(), r5)
+	assert.NotEqual(t, onlyJokers.Hash(), empty.Hash())
+	assert.NotEqual(t, oneJoker.Hash(), jokers.Hash(), "the number of jokers is not part of the hash")
+
+	// nor can colors and values be confused.
+	a := NewBrickCombination(Brick{Color: "red1", Value: 1})
+	b := NewBrickCombination(Brick{Color: "red", Value: 11})
+	assert.NotEqual(t, a.Hash(), b.Hash())
+	c := NewBrickCombination(Brick{Color: `red"1,"red`, Value: 1})
+	d := NewBrickCombination(Brick{Color: "red", Value: 1}, Brick{Color: "red", Value: 1})
+	assert.NotEqual(t, c.Hash(), d.Hash())
+}
+
+func TestTableHash(t *testing.T) {
+	a := NewBrickCombination(Brick{Color: "red", Value: 1}, Brick{Color: "red", Value: 2}, Brick{Color: "red", Value: 3})
+	b := NewBrickCombination(Brick{Color: "blue", Value: 1}, MakeJoker(), Brick{Color: "blue", Value: 3})
+
+	assert.Equal(t, TableHash([]BrickCombination{a, b}), TableHash([]BrickCombination{b, a}), "TableHash is not insensitive to combination order")
+	assert.NotEqual(t, TableHash([]BrickCombination{a, a}), TableHash([]BrickCombination{a}), "duplicate combinations cancelled each other out")
+	assert.NotEqual(t, TableHash([]BrickCombination{}), TableHash([]BrickCombination{NewBrickCombination()}))
+
+	// the same bricks, split up differently.
+	ab := NewBrickCombination(append(append([]Brick{}, a.Bricks...), b.Bricks...)...)
+	assert.NotEqual(t, TableHash([]BrickCombination{a, b}), TableHash([]BrickCombination{ab}))
+}
+
 func TestBrickCombination_GroupValidityChecker(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 
@@ -94,40 +127,40 @@ func TestBrickCombination_GroupValidityChecker(t *testing.T) {
 	groupToTest := NewBrickCombination()
 	groupToTest.AddBrick(Brick{Color: "red", Value: 1},
 		Brick{Color: "red", Value: 1})
-	valid, why := groupToTest.IsValidGroup()
+	err := groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, COMBINATION_TOO_SMALL, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, COMBINATION_TOO_SMALL))
-	assert.Equal(t, valid, false, "IsValidGroup: combination has duplicates but was still tagged as a group")
+	assert.True(t, errors.Is(err, COMBINATION_TOO_SMALL), fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, COMBINATION_TOO_SMALL))
+	assert.Error(t, err, "IsValidGroup: combination has duplicates but was still tagged as a group")
 
 	// not all colors are unique
 	groupToTest = NewBrickCombination()
 	groupToTest.AddBrick(Brick{Color: "red", Value: 1},
 		Brick{Color: "red", Value: 1},
 		Brick{Color: "green", Value: 1})
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, COLORS_NOT_UNIQUE, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, COLORS_NOT_UNIQUE))
-	assert.Equal(t, valid, false, "IsValidGroup: combination has duplicates but was still tagged as a group")
+	assert.True(t, errors.Is(err, COLORS_NOT_UNIQUE), fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, COLORS_NOT_UNIQUE))
+	assert.Error(t, err, "IsValidGroup: combination has duplicates but was still tagged as a group")
 
 	// not all values are the same (1/2)
 	groupToTest = NewBrickCombination()
 	groupToTest.AddBrick(Brick{Color: "red", Value: 1},
 		Brick{Color: "black", Value: 1},
 		Brick{Color: "green", Value: 2})
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, CONTAINS_MULTIPLE_VALUES, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, CONTAINS_MULTIPLE_VALUES))
-	assert.Equal(t, valid, false, "IsValidGroup: brickcombination has multiple unique values but was still tagged as a group (1/2)")
+	assert.True(t, errors.Is(err, CONTAINS_MULTIPLE_VALUES), fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, CONTAINS_MULTIPLE_VALUES))
+	assert.Error(t, err, "IsValidGroup: brickcombination has multiple unique values but was still tagged as a group (1/2)")
 
 	// not all values are the same (2/2)
 	groupToTest = NewBrickCombination()
 	groupToTest.AddBrick(Brick{Color: "green", Value: 1},
 		Brick{Color: "green", Value: 1},
 		Brick{Color: "green", Value: 2})
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, CONTAINS_MULTIPLE_VALUES, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, CONTAINS_MULTIPLE_VALUES))
-	assert.Equal(t, valid, false, "IsValidGroup: brickcombination has multiple unique values but was still tagged as a group (2/2)")
+	assert.True(t, errors.Is(err, CONTAINS_MULTIPLE_VALUES), fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, CONTAINS_MULTIPLE_VALUES))
+	assert.Error(t, err, "IsValidGroup: brickcombination has multiple unique values but was still tagged as a group (2/2)")
 
 	// contains only jokers
 	groupToTest = NewBrickCombination()
@@ -135,29 +168,28 @@ func TestBrickCombination_GroupValidityChecker(t *testing.T) {
 		Brick{Color: JokerColor, Value: 1},
 		Brick{Color: JokerColor, Value: 1},
 		Brick{Color: JokerColor, Value: 1})
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, CONTAINS_ONLY_JOKERS, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, CONTAINS_ONLY_JOKERS))
-	assert.Equal(t, valid, false, "IsValidGroup: brickcombination contains only jokers but was still tagged as a group")
+	assert.True(t, errors.Is(err, CONTAINS_ONLY_JOKERS), fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, CONTAINS_ONLY_JOKERS))
+	assert.Error(t, err, "IsValidGroup: brickcombination contains only jokers but was still tagged as a group")
 
 	// is too small
 	groupToTest = NewBrickCombination()
 	groupToTest.AddBrick(Brick{Color: "yellow", Value: 1},
 		Brick{Color: "blueish", Value: 1})
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, COMBINATION_TOO_SMALL, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, COMBINATION_TOO_SMALL))
-	assert.Equal(t, valid, false, "IsValidGroup: brickcombination is too small but was still tagged as a group")
+	assert.True(t, errors.Is(err, COMBINATION_TOO_SMALL), fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, COMBINATION_TOO_SMALL))
+	assert.Error(t, err, "IsValidGroup: brickcombination is too small but was still tagged as a group")
 
 	// is VALID
 	groupToTest = NewBrickCombination()
 	groupToTest.AddBrick(Brick{Color: "yellow", Value: 1},
 		Brick{Color: "blueish", Value: 1},
 		Brick{Color: "green", Value: 1})
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, VALID_GROUP, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, VALID_GROUP))
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
+	assert.NoError(t, err, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
 
 	// is VALID with joker
 	groupToTest = NewBrickCombination()
@@ -165,10 +197,9 @@ func TestBrickCombination_GroupValidityChecker(t *testing.T) {
 		Brick{Color: "blueish", Value: 1},
 		Brick{Color: "green", Value: 1},
 		MakeJoker())
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, VALID_GROUP, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, VALID_GROUP))
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
+	assert.NoError(t, err, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
 
 	// Regression test: is VALID with joker (combos starting with a joker broke previous tests)
 	groupToTest = NewBrickCombination()
@@ -177,10 +208,9 @@ func TestBrickCombination_GroupValidityChecker(t *testing.T) {
 		Brick{Color: "blue", Value: 2},
 		Brick{Color: "green", Value: 2},
 	)
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, VALID_GROUP, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, VALID_GROUP))
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
+	assert.NoError(t, err, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
 
 	// is VALID with 2 jokers
 	groupToTest = NewBrickCombination()
@@ -189,10 +219,9 @@ func TestBrickCombination_GroupValidityChecker(t *testing.T) {
 		Brick{Color: "green", Value: 1},
 		MakeJoker(),
 		MakeJoker())
-	valid, why = groupToTest.IsValidGroup()
+	err = groupToTest.IsValidGroup()
 	t.Logf("testing: %v", groupToTest)
-	assert.Equal(t, why, VALID_GROUP, fmt.Sprintf("group was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, VALID_GROUP))
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
+	assert.NoError(t, err, fmt.Sprintf("IsValidGroup: %v should be valid!", groupToTest))
 }
 
 func TestBrickCombination_RunValidityChecker(t *testing.T) {
@@ -204,79 +233,79 @@ func TestBrickCombination_RunValidityChecker(t *testing.T) {
 		Brick{Color: JokerColor, Value: 1},
 		Brick{Color: JokerColor, Value: 1},
 		Brick{Color: JokerColor, Value: 1})
-	valid, why := runToTest.IsValidRun()
+	err := runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, false, "IsValidRun: brickcombination contains only jokers but was still tagged as a run")
+	t.Logf("%v", err)
+	assert.Error(t, err, "IsValidRun: brickcombination contains only jokers but was still tagged as a run")
 
 	// is too small
 	runToTest = NewBrickCombination()
 	runToTest.AddBrick(Brick{Color: "yellow", Value: 1},
 		Brick{Color: "blueish", Value: 1})
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, false, "IsValidRun: brickcombination is too small but was still tagged as a run")
+	t.Logf("%v", err)
+	assert.Error(t, err, "IsValidRun: brickcombination is too small but was still tagged as a run")
 
 	// contains multiple colors
 	runToTest = NewBrickCombination()
 	runToTest.AddBrick(Brick{Color: "yellow", Value: 1},
 		Brick{Color: "blueish", Value: 1},
 		Brick{Color: "blueish", Value: 1})
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, false, "IsValidRun: brickcombination contains multiple colors but was still tagged as a run")
+	t.Logf("%v", err)
+	assert.Error(t, err, "IsValidRun: brickcombination contains multiple colors but was still tagged as a run")
 
 	// contains non-unique numbers
 	runToTest = NewBrickCombination()
 	runToTest.AddBrick(Brick{Color: "green", Value: 1},
 		Brick{Color: "green", Value: 1},
 		Brick{Color: "green", Value: 2})
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, false, "IsValidRun: brickcombination contains non-unique numbers but was still tagged as run")
+	t.Logf("%v", err)
+	assert.Error(t, err, "IsValidRun: brickcombination contains non-unique numbers but was still tagged as run")
 
 	// does not contain consecutive numbers
 	runToTest = NewBrickCombination()
 	runToTest.AddBrick(Brick{Color: "yellow", Value: 1},
 		Brick{Color: "blueish", Value: 1},
 		Brick{Color: "blueish", Value: 1})
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, false, "IsValidRun: brickcombination does not contain consecutive values but was still tagged as a run")
+	t.Logf("%v", err)
+	assert.Error(t, err, "IsValidRun: brickcombination does not contain consecutive values but was still tagged as a run")
 
 	// is VALID
 	runToTest = NewBrickCombination()
 	runToTest.AddBrick(Brick{Color: "blue", Value: 1},
 		Brick{Color: "blue", Value: 2},
 		Brick{Color: "blue", Value: 3})
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
+	t.Logf("%v", err)
+	assert.NoError(t, err, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
 
 	// is VALID
 	runToTest = NewBrickCombination()
 	runToTest.AddBrick(Brick{Color: "blue", Value: 2},
 		Brick{Color: "blue", Value: 3},
 		Brick{Color: "blue", Value: 4})
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
+	t.Logf("%v", err)
+	assert.NoError(t, err, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
 
 	// is VALID with joker
 	runToTest = NewBrickCombination()
 	runToTest.AddBrick(Brick{Color: "blue", Value: 1},
 		Brick{Color: "blue", Value: 2},
 		MakeJoker())
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
+	t.Logf("%v", err)
+	assert.NoError(t, err, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
 
 	// is VALID with 2 jokers
 	runToTest = NewBrickCombination()
@@ -284,10 +313,10 @@ func TestBrickCombination_RunValidityChecker(t *testing.T) {
 		Brick{Color: "blue", Value: 2},
 		MakeJoker(),
 		MakeJoker())
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
+	t.Logf("%v", err)
+	assert.NoError(t, err, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
 
 	// regression test: is VALID but starts with a joker. These runs broke tests in the past.
 	runToTest = NewBrickCombination()
@@ -296,9 +325,9 @@ func TestBrickCombination_RunValidityChecker(t *testing.T) {
 		Brick{Color: "yellow", Value: 3},
 		Brick{Color: "yellow", Value: 4},
 	)
-	valid, why = runToTest.IsValidRun()
+	err = runToTest.IsValidRun()
 	t.Logf("testing: %v", runToTest)
-	t.Logf(why)
-	assert.Equal(t, valid, true, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
+	t.Logf("%v", err)
+	assert.NoError(t, err, fmt.Sprintf("IsValidRun: %v should be valid!", runToTest))
 
 }
@@ -0,0 +1,362 @@
+package rummikub
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// CombinationSpace is the validated (de-duplicated, legal, etc.) set of BrickCombinations that can be made given a set of game rules.
+// It is immutable once built, and thus safe to share between solvers, players and games (see NewCombinationSpace).
+type CombinationSpace struct {
+	combinations []BrickCombination
+	uniqueBricks []Brick
+
+	// the maximum number of times each combination can be on the table at once, given the bricks in play.
+	combinationBounds []int
+
+	// the sparse brick-combination incidence index: the index of each unique brick, the bricks each combination contains
+	// and the combinations each brick occurs in (both with the number of times it does). Built once, used by every solve.
+	brickIndex          map[Brick]int
+	bricksByCombination [][]incidence
+	combinationsByBrick [][]incidence
+
+	// the rules the space is built from. Only the fields that determine the space are set (see combinationSpaceKey).
+	rules Rules
+
+	// To facilitate quick lookup using the Contains method.
+	combinationHashes map[CombinationIdentity]bool
+
+	// some stats about the search space.
+	totalRuns   int
+	totalGroups int
+	groupSizes  map[int]int // how many sets of a certain length
+	runSizes    map[int]int // how many rows of a certain length
+}
+
+// combinationSpaceMemoSize is the number of combination spaces that are memoized at most.
+const combinationSpaceMemoSize = 16
+
+// combinationSpaces memoizes the combination spaces by the key of the rules they are built from.
+var combinationSpaces = newCombinationSpaceMemo(combinationSpaceMemoSize)
+
+// combinationSpaceMemo is a bounded memo of combination spaces, keyed by combinationSpaceKey.
+// Once full, the least recently used space is evicted when another one has been built. Spaces that could not be built are not memoized.
+type combinationSpaceMemo struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // of *combinationSpaceEntry, the most recently used first.
+}
+
+type combinationSpaceEntry struct {
+	key   string
+	once  sync.Once
+	space *CombinationSpace
+	err   error
+}
+
+func newCombinationSpaceMemo(size int) *combinationSpaceMemo {
+	return &combinationSpaceMemo{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the combination space for the game rules, building it if it is not memoized (see NewCombinationSpace).
+func (memo *combinationSpaceMemo) get(gameRules Rules) (*CombinationSpace, error) {
+	entry := memo.entry(combinationSpaceKey(gameRules))
+
+	// build the space outside of the lock, so that spaces for other rules can be built at the same time.
+	entry.once.Do(func() {
+		entry.space, entry.err = buildCombinationSpace(gameRules)
+	})
+	if entry.err != nil {
+		memo.forget(entry)
+	} else {
+		memo.evict()
+	}
+	return entry.space, entry.err
+}
+
+// entry returns the entry for the key, adding an (unbuilt) one if there is none.
+func (memo *combinationSpaceMemo) entry(key string) *combinationSpaceEntry {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	if element, ok := memo.entries[key]; ok {
+		memo.order.MoveToFront(element)
+		return element.Value.(*combinationSpaceEntry)
+	}
+
+	entry := &combinationSpaceEntry{key: key}
+	memo.entries[key] = memo.order.PushFront(entry)
+	return entry
+}
+
+// evict evicts the least recently used entries while the memo holds more than its size.
+func (memo *combinationSpaceMemo) evict() {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	for memo.order.Len() > memo.size {
+		oldest := memo.order.Back()
+		memo.order.Remove(oldest)
+		delete(memo.entries, oldest.Value.(*combinationSpaceEntry).key)
+	}
+}
+
+// forget removes the entry from the memo, if it is still in it.
+func (memo *combinationSpaceMemo) forget(entry *combinationSpaceEntry) {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	if element, ok := memo.entries[entry.key]; ok && element.Value.(*combinationSpaceEntry) == entry {
+		memo.order.Remove(element)
+		delete(memo.entries, entry.key)
+	}
+}
+
+// len returns the number of memoized spaces.
+func (memo *combinationSpaceMemo) len() int {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	return memo.order.Len()
+}
+
+// combinationSpaceKey returns the canonical key of the rules that determine the combination space:
+// the values, the colors (in order), the number of replicates and the jokers. Other rules (e.g. the first move rules) do not matter.
+func combinationSpaceKey(rules Rules) string {
+	return fmt.Sprintf("%d|%q|%d|%d|%d", rules.Values, rules.Colors, rules.Replicates, rules.JokersInPlay, rules.JokersPerCombination)
+}
+
+// NewCombinationSpace returns the combination space for the game rules. It is built only once for each set of rules
+// that determine the space (see combinationSpaceKey), after which the same space is returned, also to concurrent callers,
+// for as long as it is one of the combinationSpaceMemoSize most recently used spaces.
+// Returns ErrInconsistentSearchSpace if the space does not match the rules, which rules that pass Rules.Validate never cause.
+func NewCombinationSpace(gameRules Rules) (*CombinationSpace, error) {
+	return combinationSpaces.get(gameRules)
+}
+
+// buildCombinationSpace maps all legal combinations that can be made given the game rules.
+func buildCombinationSpace(gameRules Rules) (*CombinationSpace, error) {
+	brickSet := gameRules.BaseBricks()
+
+	// compute all possible BrickCombinations using the game rules and the available base uniqueBricks.
+	runs := ComputeAllRuns(brickSet)
+	groups := ComputeAllGroups(brickSet)
+	// a combination can not hold more jokers than there are in play.
+	jokers := gameRules.JokersPerCombination
+	if jokers > gameRules.JokersInPlay {
+		jokers = gameRules.JokersInPlay
+	}
+	saltyGroups := saltWithJokers(groups, jokers)
+	saltyRuns := saltWithJokers(runs, jokers)
+
+	// build a search space object
+	space := &CombinationSpace{
+		combinationHashes: make(map[CombinationIdentity]bool),
+		groupSizes:        make(map[int]int),
+		runSizes:          make(map[int]int),
+		rules: Rules{
+			Values:               gameRules.Values,
+			Colors:               append([]string{}, gameRules.Colors...),
+			Replicates:           gameRules.Replicates,
+			JokersInPlay:         gameRules.JokersInPlay,
+			JokersPerCombination: gameRules.JokersPerCombination,
+		},
+	}
+
+	// save all unique uniqueBricks used to build the search space with.
+	//Include ONE joker (jokers are considered non-unique) if the play set includes jokers.
+	space.uniqueBricks = brickSet
+	if gameRules.JokersInPlay > 0 {
+		space.uniqueBricks = append(space.uniqueBricks, MakeJoker())
+	}
+
+	// add the combinations to the search space.
+	space.addCombinations(saltyGroups)
+	space.addCombinations(saltyRuns)
+	space.addCombinations(groups)
+	space.addCombinations(runs)
+	space.indexIncidence()
+
+	if err := space.validate(space.rules); err != nil {
+		return nil, err
+	}
+	return space, nil
+}
+
+// return the size of the CombinationSpace instance
+func (space *CombinationSpace) Size() (int, int, int) {
+	return len(space.uniqueBricks), space.totalRuns, space.totalGroups
+}
+
+// AllCombinations returns a copy of the combinations in the CombinationSpace struct
+func (space *CombinationSpace) AllCombinations() []BrickCombination {
+	//(slices are reference types, and the space is shared).
+	combinations := make([]BrickCombination, len(space.combinations))
+	for i, c := range space.combinations {
+		combinations[i] = c.Copy()
+	}
+	return combinations
+}
+
+// Bricks returns a copy of the uniqueBricks that the CombinationSpace is based on.
+func (space *CombinationSpace) Bricks() []Brick {
+	//(slices are reference types, and the space is shared).
+	return append([]Brick{}, space.uniqueBricks...)
+}
+
+// addCombinations adds combinations to the CombinationSpace object after checking their validity, simultaneously incrementing counters / lookup maps.
+// Only to be used while building the space.
+func (space *CombinationSpace) addCombinations(combinations []BrickCombination) {
+	for _, combo := range combinations {
+		h := combo.Hash()
+		if _, ok := space.combinationHashes[h]; ok {
+			continue //ignore this combination
+		} else {
+			space.combinations = append(space.combinations, combo)
+			space.combinationBounds = append(space.combinationBounds, space.combinationBound(combo))
+			space.combinationHashes[h] = true
+
+			// validate the combinations and update the tallies
+			isRun := combo.IsValidRun() == nil
+			isGroup := combo.IsValidGroup() == nil
+			if isRun {
+				space.totalRuns++
+				space.runSizes[len(combo.getBricks())]++
+			} else if isGroup {
+				space.totalGroups++
+				space.groupSizes[len(combo.getBricks())]++
+			} else {
+				panic(fmt.Sprintf("invalid combination (not a valid run nor a valid group) supplied to CombinationSpace: \n %v", combo))
+			}
+		}
+	}
+}
+
+// incidence is an entry of the incidence index: the index of a brick or a combination, and the number of times the brick occurs in the combination.
+type incidence struct {
+	index int
+	count int
+}
+
+// indexIncidence builds the incidence index of the combinations. Only to be used while building the space.
+func (space *CombinationSpace) indexIncidence() {
+	space.brickIndex = make(map[Brick]int, len(space.uniqueBricks))
+	for i, b := range space.uniqueBricks {
+		space.brickIndex[b] = i
+	}
+
+	space.bricksByCombination = make([][]incidence, len(space.combinations))
+	space.combinationsByBrick = make([][]incidence, len(space.uniqueBricks))
+	for j, combo := range space.combinations {
+		counts := make(map[int]int)
+		var order []int
+		for _, b := range combo.getBricks() {
+			i := space.brickIndex[b]
+			if counts[i] == 0 {
+				order = append(order, i)
+			}
+			counts[i]++
+		}
+		for _, i := range order {
+			space.bricksByCombination[j] = append(space.bricksByCombination[j], incidence{index: i, count: counts[i]})
+			space.combinationsByBrick[i] = append(space.combinationsByBrick[i], incidence{index: j, count: counts[i]})
+		}
+	}
+}
+
+// brickCounts returns the number of times each unique brick (by index in the space) occurs in the bricks.
+// Bricks that are not in the space are ignored.
+func (space *CombinationSpace) brickCounts(bricks []Brick) []int {
+	counts := make([]int, len(space.uniqueBricks))
+	for _, b := range bricks {
+		if i, ok := space.brickIndex[b]; ok {
+			counts[i]++
+		}
+	}
+	return counts
+}
+
+// presolve returns the combinations (by index in the space) that can be made from the available bricks (counted per unique brick, see brickCounts),
+// along with the maximum number of times each of them can be on the table at once given those bricks.
+// Combinations that can not be made are left out of the model altogether, as their variables could only ever be 0.
+func (space *CombinationSpace) presolve(available []int) (combinations []int, bounds []int) {
+	for j, bricks := range space.bricksByCombination {
+		bound := space.combinationBounds[j]
+		for _, inc := range bricks {
+			if k := available[inc.index] / inc.count; k < bound {
+				bound = k
+			}
+		}
+		if bound > 0 {
+			combinations = append(combinations, j)
+			bounds = append(bounds, bound)
+		}
+	}
+	return combinations, bounds
+}
+
+// copies returns the number of copies of the brick in play: Rules.Replicates, or Rules.JokersInPlay for jokers.
+func (space *CombinationSpace) copies(b Brick) int {
+	if b.Color == JokerColor {
+		return space.rules.JokersInPlay
+	}
+	return space.rules.Replicates
+}
+
+// combinationBound returns the maximum number of times the combination can be on the table at once, given the bricks in play.
+func (space *CombinationSpace) combinationBound(combo BrickCombination) int {
+	counts := make(map[Brick]int)
+	for _, b := range combo.getBricks() {
+		counts[b]++
+	}
+
+	bound := -1
+	for b, n := range counts {
+		if k := space.copies(b) / n; bound < 0 || k < bound {
+			bound = k
+		}
+	}
+	return bound
+}
+
+// ErrInconsistentSearchSpace is returned if a combination space does not match the rules it is used with.
+var ErrInconsistentSearchSpace = errors.New("the search space is inconsistent with the rules")
+
+// validate checks that the space is consistent with the rules:
+// the bricks are exactly the distinct bricks in play, and each combination is legal, made from those bricks,
+// and can be on the table at least once given the number of copies of each brick.
+func (space *CombinationSpace) validate(rules Rules) error {
+	if combinationSpaceKey(rules) != combinationSpaceKey(space.rules) {
+		return fmt.Errorf("%w: the space was built for other rules", ErrInconsistentSearchSpace)
+	}
+
+	expected := rules.BaseBricks()
+	if rules.JokersInPlay > 0 {
+		expected = append(expected, MakeJoker())
+	}
+	if len(BrickSliceDiff(expected, space.uniqueBricks)) > 0 || len(BrickSliceDiff(space.uniqueBricks, expected)) > 0 {
+		return fmt.Errorf("%w: the bricks do not match the bricks in play", ErrInconsistentSearchSpace)
+	}
+
+	for i, combo := range space.combinations {
+		if err := rules.IsLegalCombination(combo); err != nil {
+			return fmt.Errorf("%w: combination %v: %v", ErrInconsistentSearchSpace, combo.Bricks, err)
+		}
+		if space.combinationBounds[i] < 1 {
+			return fmt.Errorf("%w: combination %v uses more copies of a brick than there are in play", ErrInconsistentSearchSpace, combo.Bricks)
+		}
+	}
+	return nil
+}
+
+// Contains checks if a certain BrickCombination is present in the CombinationSpace.
+func (space *CombinationSpace) Contains(combo BrickCombination) bool {
+	// check if a combination is present in the search space
+
+	h := combo.Hash()
+	_, ok := space.combinationHashes[h]
+	return ok
+}
@@ -0,0 +1,182 @@
+package rummikub
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCombinationSpace_Shared(t *testing.T) {
+	rules := NewDefaultRules()
+	space, err := NewCombinationSpace(rules)
+	assert.NoError(t, err)
+
+	// rules that only differ in ways that do not matter to the space share it.
+	other := NewDefaultRules()
+	other.FirstMoveValue = 30
+	other.StartingHandSize = 20
+	otherSpace, err := NewCombinationSpace(other)
+	assert.NoError(t, err)
+	assert.True(t, space == otherSpace, "the combination space was built twice")
+	assert.True(t, NewILPSolver(rules).CombinationSpace == NewILPSolver(other).CombinationSpace, "solvers do not share the combination space")
+
+	// rules that do matter get a space of their own.
+	other.Replicates = 3
+	otherSpace, err = NewCombinationSpace(other)
+	assert.NoError(t, err)
+	assert.False(t, space == otherSpace, "the combination space was shared between different rules")
+
+	// invalid rules are rejected: without any copies of the bricks in play, no combination can be made.
+	other = NewDefaultRules()
+	other.Replicates = 0
+	_, err = NewCombinationSpace(other)
+	assert.True(t, errors.Is(err, ErrInconsistentSearchSpace), "invalid rules were not rejected: %v", err)
+	assert.Panics(t, func() { NewILPSolver(other) })
+}
+
+func TestCombinationSpace_Jokers(t *testing.T) {
+	// without jokers in play, the space has no combinations with jokers, and a solver can be built.
+	rules := NewDefaultRules()
+	rules.JokersInPlay = 0
+	rules.JokersPerCombination = 0
+	assert.NoError(t, rules.Validate(2))
+	space, err := NewCombinationSpace(rules)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, c := range space.AllCombinations() {
+		assert.Equal(t, 0, c.jokerCount(), "combination %v has a joker", c)
+	}
+	assert.NotContains(t, space.Bricks(), MakeJoker())
+	var solver *ILPSolver
+	assert.NotPanics(t, func() { solver = NewILPSolver(rules) })
+	if solver != nil {
+		_, bricks, err := solver.Solve([]Brick{{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}}, []BrickCombination{}, false)
+		assert.NoError(t, err)
+		assert.Len(t, bricks, 3)
+	}
+
+	// combinations hold no more jokers than there are in play.
+	rules = NewDefaultRules()
+	rules.JokersInPlay = 1
+	rules.JokersPerCombination = 2
+	space, err = NewCombinationSpace(rules)
+	if assert.NoError(t, err) {
+		for _, c := range space.AllCombinations() {
+			assert.True(t, c.jokerCount() <= 1, "combination %v has more jokers than there are in play", c)
+		}
+	}
+}
+
+func TestCombinationSpace_Concurrent(t *testing.T) {
+	rules := NewDefaultRules()
+	rules.Values = 11 // rules no other test uses, so that the space is built here.
+
+	spaces := make([]*CombinationSpace, 50)
+	var wg sync.WaitGroup
+	for i := range spaces {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			spaces[i], _ = NewCombinationSpace(rules)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, s := range spaces {
+		assert.True(t, s == spaces[0], "concurrent callers got different spaces")
+	}
+}
+
+func TestCombinationSpace_Memo(t *testing.T) {
+	memo := newCombinationSpaceMemo(2)
+	rulesFor := func(values int) Rules {
+		rules := NewDefaultRules()
+		rules.Values = values
+		return rules
+	}
+
+	five, err := memo.get(rulesFor(5))
+	assert.NoError(t, err)
+	six, err := memo.get(rulesFor(6))
+	assert.NoError(t, err)
+
+	// a memoized space is returned again, and becomes the most recently used one.
+	again, err := memo.get(rulesFor(5))
+	assert.NoError(t, err)
+	assert.True(t, five == again, "the memoized space was built again")
+
+	// once the memo is full, the least recently used space is evicted.
+	_, err = memo.get(rulesFor(7))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, memo.len())
+	again, err = memo.get(rulesFor(6))
+	assert.NoError(t, err)
+	assert.False(t, six == again, "the least recently used space was not evicted")
+	assert.Equal(t, 2, memo.len())
+	six = again
+
+	// spaces that could not be built are not memoized, nor do they evict others.
+	invalid := NewDefaultRules()
+	invalid.Replicates = 0
+	_, err = memo.get(invalid)
+	assert.True(t, errors.Is(err, ErrInconsistentSearchSpace), "invalid rules were not rejected: %v", err)
+	assert.Equal(t, 2, memo.len())
+	again, err = memo.get(rulesFor(6))
+	assert.NoError(t, err)
+	assert.True(t, six == again, "a memoized space was evicted")
+}
+
+func TestCombinationSpace_Immutable(t *testing.T) {
+	space, err := NewCombinationSpace(NewDefaultRules())
+	assert.NoError(t, err)
+
+	// changing the returned combinations and bricks does not change the space.
+	combinations := space.AllCombinations()
+	original := combinations[0].Copy()
+	combinations[0].Bricks[0] = Brick{Value: 99, Color: "purple"}
+	assert.Equal(t, original, space.AllCombinations()[0])
+
+	bricks := space.Bricks()
+	bricks[0] = Brick{Value: 99, Color: "purple"}
+	assert.NotEqual(t, bricks[0], space.Bricks()[0])
+}
+
+// benchmarkSolvers creates the AI solvers of a few hundred games at the same time.
+func benchmarkSolvers(b *testing.B, newSolver func(rules Rules) *ILPSolver) {
+	const games, aiPlayers = 200, 3
+	rules := NewDefaultRules()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		solvers := make([]*ILPSolver, games*aiPlayers)
+		var wg sync.WaitGroup
+		for g := 0; g < games; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for p := 0; p < aiPlayers; p++ {
+					solvers[g*aiPlayers+p] = newSolver(rules)
+				}
+			}(g)
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkCombinationSpace_Shared(b *testing.B) {
+	benchmarkSolvers(b, NewILPSolver)
+}
+
+// BenchmarkCombinationSpace_Unshared builds the combination space for every solver, as NewILPSolver used to.
+func BenchmarkCombinationSpace_Unshared(b *testing.B) {
+	benchmarkSolvers(b, func(rules Rules) *ILPSolver {
+		space, err := buildCombinationSpace(rules)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return &ILPSolver{CombinationSpace: space, rules: rules, options: DefaultSolverOptions()}
+	})
+}
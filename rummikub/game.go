@@ -1,10 +1,12 @@
 package rummikub
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
+	"time"
 )
 
 type GameState struct {
@@ -26,57 +28,59 @@ type GameState struct {
 
 	// the Source struct for the random number generator.
 	Seed int64 `json:"seed"`
+
+	// whether the human players are denied hints (see RecordHint).
+	HintsDisabled bool `json:"hints_disabled,omitempty"`
 }
 
-// Move processing outcomes.
-const (
-	LEGAL_MOVE         = "move is legal"
-	FORFEITED          = "player forfeits his turn"
-	NOT_YOUR_TURN      = "player named in the move object does not correspond to the name of the current player"
-	NOT_OWNED          = "there are new bricks in the proposed combination that are not in the player's hand"
-	BRICKS_REMOVED     = "bricks were removed from the field"
-	VALUE_INSUFFICIENT = "cumulative value of bricks insufficient"
-	GAME_WON           = "the game has been won"
-)
+// TODO separate display names from the names used in determining turns; slightly cleaner.
 
-// Possible outcomes of matching BrickCombinations to game rules.
-const (
-	LEGAL_COMBINATION              = "Combination is valid"
-	TOO_MANY_JOKERS_IN_COMBINATION = "Too many Jokers in combination"
-	ILLEGAL_COMBINATION            = "Combination is neither a valid group or a valid run"
-	VALUE_OUT_OF_BOUNDS            = "Brick value invalid: brick value outside of game bounds"
-	UNKNOWN_COLOR                  = "Brick color was not found in game rules"
-)
+// ErrHintsDisabled is returned by RecordHint if hints have been disabled for the game.
+var ErrHintsDisabled = errors.New("hints are disabled in this game")
 
-// TODO separate display names from the names used in determining turns; slightly cleaner.
+// PlayerError is returned when a player cannot take part in the game (anymore).
+// It can be matched against its reason with errors.Is, e.g. errors.Is(err, UNKNOWN_PLAYER).
+type PlayerError struct {
+	// the name of the offending player.
+	Name string
+
+	// why the player was rejected (see the player violations in violation.go).
+	Reason ViolationCode
+}
+
+func (e *PlayerError) Error() string {
+	return fmt.Sprintf("invalid player %q: %v", e.Name, e.Reason)
+}
+
+// Is reports whether the error carries the target ViolationCode, or has the same reason as the target PlayerError.
+func (e *PlayerError) Is(target error) bool {
+	switch t := target.(type) {
+	case ViolationCode:
+		return e.Reason == t
+	case *PlayerError:
+		return e.Reason == t.Reason
+	}
+	return false
+}
 
 // NewEmptyGame initiates a game where the getBricks have not yet been randomized and distributed to the players.
 // Useful for writing hard-coded test cases.
 // Note that it does not contain any seed for random number generation.
-func NewEmptyGame(rules Rules, ps ...Player) GameState {
-
-	// TODO check for uniqueness of player names before creating game. Else; return err.
-	//playerNames := []string{}
-	//for _, p := range ps{
-	//	playerNames = append(playerNames, p.getName())
-	//}
-	//if !noDuplicates(playerNames){
-	//
-	//}
-	//// check if a slice of strings has no duplicates.
-	//func noDuplicates(a []string) bool {
-	//	m := make(map[string]bool)
-	//
-	//	for _, x := range a {
-	//		m[x] = true
-	//	}
-	//
-	//	if len(m) == len(a) {
-	//		return true
-	//	}
-	//
-	//	return false
-	//}
+// Returns a *RulesError if the rules are not sane for this number of players, or a *PlayerError if the player names are not unique.
+func NewEmptyGame(rules Rules, ps ...Player) (GameState, error) {
+
+	if err := rules.Validate(len(ps)); err != nil {
+		return GameState{}, err
+	}
+
+	// player names are used to determine turns, so they must be unique.
+	playerNames := make(map[string]bool)
+	for _, p := range ps {
+		if playerNames[p.getName()] {
+			return GameState{}, &PlayerError{p.getName(), DUPLICATE_PLAYER_NAME}
+		}
+		playerNames[p.getName()] = true
+	}
 
 	// make a (deterministically ordered) pile consisting of all unique bricks in the game.
 	orderedPile := rules.AllBricks()
@@ -87,12 +91,16 @@ func NewEmptyGame(rules Rules, ps ...Player) GameState {
 		Pile:        orderedPile,
 		CurrentTurn: 0,
 		Rules:       rules,
-	}
+	}, nil
 }
 
 //NewGame initiates a new game struct given a rules struct, a seed number for the random number generator, and a set of player interfaces.
-func NewGame(rules Rules, seed int64, ps ...Player) *GameState {
-	game := NewEmptyGame(rules, ps...)
+// Returns the same errors as NewEmptyGame.
+func NewGame(rules Rules, seed int64, ps ...Player) (*GameState, error) {
+	game, err := NewEmptyGame(rules, ps...)
+	if err != nil {
+		return nil, err
+	}
 
 	// Save the seed
 	game.Seed = seed
@@ -115,23 +123,25 @@ func NewGame(rules Rules, seed int64, ps ...Player) *GameState {
 	game.Pile = shuffledPile
 
 	// distribute the randomized bricks from the pile to the player racks.
+	// NOTE: Rules.Validate guarantees that the pile is large enough.
 	for i := range game.Players {
 		tmp := []Brick{}
 		for i := 0; i < rules.StartingHandSize; i++ {
 			b, err := game.popFromPile()
 			if err != nil {
-				panic("pile empty before stones could be distributed")
+				return nil, err
 			}
 			tmp = append(tmp, *b)
 		}
 		game.Players[i].SetHand(tmp)
 	}
 
-	return &game
+	return &game, nil
 }
 
 // DeserializeGame builds a new game state from a serialized game.
 // This is necessary as the Solver structs are not serialized (they are too big and mostly constant), and thus need to be 're-armed' on deserialization.
+// The combination space the solvers are based on is shared (see NewCombinationSpace), so re-arming is cheap.
 // TODO: currently works with only one type of solver.
 func DeserializeGame(serializedGame []byte) *GameState {
 	var game GameState
@@ -139,7 +149,7 @@ func DeserializeGame(serializedGame []byte) *GameState {
 
 	for i := range game.Players {
 		if game.Players[i].isHuman() {
-			game.Players[i].solver = &DummySolver{}
+			game.Players[i].solver = AdaptSolver(&DummySolver{})
 		} else {
 			game.Players[i].solver = NewILPSolver(game.getRules())
 		}
@@ -205,10 +215,14 @@ func (game *GameState) Table() []BrickCombination {
 // Set the new table state (after validation)
 func (game *GameState) commitMove(m Move) {
 	//game.table = m.proposedTable
+	// record what the jokers stand in for, so that it is part of the game state.
+	m.Arrangement = game.getRules().resolveArrangement(game.Table(), m.Arrangement)
 	game.MoveHistory = append(game.MoveHistory, m)
 }
 
-func (game *GameState) IsLegalMove(move Move) (bool, string) {
+// IsLegalMove checks the proposed move against the game state.
+// Returns LEGAL_MOVE or FORFEITED if the move is legal, or a *RuleViolation describing why it is not.
+func (game *GameState) IsLegalMove(move Move) (Outcome, error) {
 
 	// Get the player
 	player := game.CurrentPlayer()
@@ -216,15 +230,15 @@ func (game *GameState) IsLegalMove(move Move) (bool, string) {
 	// check if the name in the move corresponds to the player name (check if it is the player's turn)
 	// formality; edge case to strengthen API.
 	if player.getName() != move.PlayerName {
-		return false, NOT_YOUR_TURN
+		return "", newViolation(NOT_YOUR_TURN)
 	}
 
 	// get the bricks of the table arrangement that the player proposes and of the current table arrangement.
 	proposedTableBricks := move.Bricks()
 	currentTableBricks := DissolveCombinations(game.Table())
 
-	if len(BrickSliceDiff(proposedTableBricks, currentTableBricks)) > 0 {
-		return false, BRICKS_REMOVED
+	if removedBricks := BrickSliceDiff(proposedTableBricks, currentTableBricks); len(removedBricks) > 0 {
+		return "", newViolation(BRICKS_REMOVED, removedBricks...)
 	}
 
 	// compute the set difference of the proposed field and the current field
@@ -232,131 +246,292 @@ func (game *GameState) IsLegalMove(move Move) (bool, string) {
 
 	// check if any additional bricks are put on the table. If not, the player opted to draw a stone and forfeit.
 	if len(newBricks) == 0 {
-		return true, FORFEITED
+		return FORFEITED, nil
 	}
 
 	// check if the difference between the old and the new field is at least a subset of the player's hand
 	madeUpBricks := BrickSliceDiff(player.Hand(), newBricks)
 	if len(madeUpBricks) > 0 {
-		return false, NOT_OWNED
+		return "", newViolation(NOT_OWNED, madeUpBricks...)
 	}
 
 	// check if all proposed combinations are legal according to the game rules
-	for _, x := range move.Arrangement {
-		if isLegal, why := game.Rules.IsLegalCombination(x); !isLegal {
-			return false, why
+	for i, x := range move.Arrangement {
+		if err := game.Rules.IsLegalCombination(x); err != nil {
+			violation := err.(*RuleViolation)
+			violation.Combination = i
+			return "", violation
 		}
 	}
 
-	// Check if it is the player's first move by searching for its name in the table history
-	// If so; it should maximize the value of its proposed new arrangements according to the game rules.
-	minValueConstraint := game.getRules().FirstMoveValue
-	for _, m := range game.MoveHistory {
-		if m.PlayerName == player.getName() {
-			minValueConstraint = 0
-			break
+	// the first move of a player is subject to the first move rules.
+	if game.IsFirstMove(player.getName()) {
+		if err := game.getRules().CheckFirstMove(game.Table(), move.Arrangement); err != nil {
+			return "", err
 		}
 	}
 
-	cumulativeBrickValue := 0
-	for _, b := range newBricks {
-		cumulativeBrickValue += b.Value
-	}
-
-	if cumulativeBrickValue < minValueConstraint {
-		return false, VALUE_INSUFFICIENT
-	}
-
-	return true, LEGAL_MOVE
+	return LEGAL_MOVE, nil
 }
 
 // Returns whether the game has been won.
+// A game is also won when all but one of the players have resigned.
 func (game *GameState) HasBeenWon() bool {
 	for _, p := range game.Players {
 		if len(p.Hand()) == 0 {
 			return true
 		}
 	}
-	return false
+	return len(game.Players) > 1 && game.playersInGame() == 1
+}
+
+// Winner returns the player that has won the game, or nil if the game has not been won (yet).
+// A won game always ends on the winner's turn.
+func (game *GameState) Winner() *Player {
+	if !game.HasBeenWon() {
+		return nil
+	}
+	return game.CurrentPlayer()
+}
+
+// the penalty for a joker left in a player's hand at the end of the game.
+const JOKER_PENALTY = 30
+
+// Scores returns the score of each player (by name), or nil if the game has not been won (yet).
+// Each loser scores minus the value of the bricks left in their hand (jokers count JOKER_PENALTY).
+// The winner scores the sum of the values left in the losers' hands.
+func (game *GameState) Scores() map[string]int {
+	winner := game.Winner()
+	if winner == nil {
+		return nil
+	}
+
+	scores := make(map[string]int)
+	total := 0
+	for _, p := range game.Players {
+		if p.Name == winner.Name {
+			continue
+		}
+		penalty := 0
+		for _, b := range p.Hand() {
+			if b.Color == JokerColor {
+				penalty += JOKER_PENALTY
+			} else {
+				penalty += b.Value
+			}
+		}
+		scores[p.Name] = -penalty
+		total += penalty
+	}
+	scores[winner.Name] = total
+	return scores
+}
+
+// playersInGame counts the players that have not resigned.
+func (game *GameState) playersInGame() int {
+	n := 0
+	for _, p := range game.Players {
+		if !p.Resigned {
+			n++
+		}
+	}
+	return n
 }
 
 // Move to the next turn: increment or reset the turn counter to point to the next player.
+// Players that have resigned are skipped.
 func (game *GameState) cycleTurn() {
-	game.CurrentTurn++
-	if game.CurrentTurn > len(game.Players)-1 {
-		game.CurrentTurn = 0
+	for i := 0; i < len(game.Players); i++ {
+		game.CurrentTurn++
+		if game.CurrentTurn > len(game.Players)-1 {
+			game.CurrentTurn = 0
+		}
+		if !game.CurrentPlayer().Resigned {
+			return
+		}
+	}
+}
+
+// Resign takes the named player out of the game. Its turns are skipped from then on.
+// If all but one of the players have resigned, the remaining player wins the game.
+// Returns a *PlayerError if the player is unknown or the last one in the game, or a *RuleViolation if the game is already over.
+func (game *GameState) Resign(playerName string) error {
+	player := game.GetPlayer(playerName)
+	if player == nil {
+		return &PlayerError{playerName, UNKNOWN_PLAYER}
+	}
+	if game.HasBeenWon() {
+		return newViolation(GAME_OVER)
+	}
+	if player.Resigned {
+		return &PlayerError{playerName, ALREADY_RESIGNED}
+	}
+	if game.playersInGame() == 1 {
+		return &PlayerError{playerName, LAST_PLAYER}
+	}
+
+	player.Resigned = true
+
+	// pass the turn on if the resigning player was up.
+	if game.CurrentPlayer().Resigned {
+		game.cycleTurn()
+	}
+
+	return nil
+}
+
+// RecordHint counts a hint given to the named (human) player.
+// Returns ErrHintsDisabled if the game does not allow hints, or a *PlayerError if the player is unknown or not human.
+func (game *GameState) RecordHint(playerName string) error {
+	if err := game.CheckHint(playerName); err != nil {
+		return err
 	}
+	game.GetPlayer(playerName).HintsUsed++
+	return nil
 }
 
+// CheckHint returns the errors of RecordHint, without counting the hint.
+func (game *GameState) CheckHint(playerName string) error {
+	if game.HintsDisabled {
+		return ErrHintsDisabled
+	}
+	player := game.GetPlayer(playerName)
+	if player == nil {
+		return &PlayerError{playerName, UNKNOWN_PLAYER}
+	}
+	if !player.Human {
+		return &PlayerError{playerName, NOT_HUMAN}
+	}
+	return nil
+}
+
+// HintRequest returns the request for a hint for the named (human) player: the move the solver would make in the player's place
+// within the budget, computed like the move of an AI player (see NextAIMove).
+// Returns the errors of RecordHint, but does not count the hint: that is left to RecordHint once the hint has been computed.
+func (game *GameState) HintRequest(playerName string, solver Solver, budget time.Duration) (*AIMoveRequest, error) {
+	if err := game.CheckHint(playerName); err != nil {
+		return nil, err
+	}
+	player := *game.GetPlayer(playerName)
+	player.solver = AdaptSolver(solver)
+	player.solveBudget = budget
+	return game.moveRequest(&player), nil
+}
+
+// IsFirstMove returns whether the named player has yet to make its first move: a move that puts bricks on the table.
+// Forfeits do not count.
 func (game *GameState) IsFirstMove(playerName string) bool {
+	table := []BrickCombination{}
 	for _, m := range game.MoveHistory {
-		if m.PlayerName == playerName {
+		if m.PlayerName == playerName && len(BrickSliceDiff(DissolveCombinations(table), m.Bricks())) > 0 {
 			return false
 		}
+		table = m.Arrangement
 	}
 	return true
 }
 
+// AITurn describes a turn played by an AI player, as reported to the observer passed to RunAITurnsObserved.
+type AITurn struct {
+	PlayerName string
+
+	// the time the AI player took to come up with its move.
+	SolveTime time.Duration
+
+	Outcome Outcome
+}
+
 // RunAITurns cycles (by recursion) through the players, running each AI player's turn.
 // It stops when it encounters a non-AI player or when a player has won the game..
-func (game *GameState) RunAITurns() {
-	// get the player object whose turn it is.
-	player := game.CurrentPlayer()
-	playerName := player.getName()
-
-	// check if it is an AI, if not; return.
-	if player.isHuman() {
-		return
-	}
+// An AI player whose move is rejected forfeits its turn instead. If that forfeit is not accepted either (which only happens
+// if the turn has passed while the move was computed), it stops and returns the reason.
+func (game *GameState) RunAITurns() error {
+	return game.RunAITurnsObserved(nil)
+}
 
-	// Check if it is the player's first move by searching for its name in the table history
-	// If so; it should maximize the value of its proposed new arrangements according to the game rules.
-	valueConstraint := game.getRules().FirstMoveValue
-	if game.IsFirstMove(playerName) {
-		valueConstraint = 0
+// RunAITurnsObserved is RunAITurns, reporting each turn played to the observer (if not nil).
+func (game *GameState) RunAITurnsObserved(observe func(turn AITurn)) error {
+	// get the request for the move of the player whose turn it is. If it is not an AI; return.
+	request, ok := game.NextAIMove()
+	if !ok {
+		return nil
 	}
+	playerName := request.PlayerName()
 
 	// run the AI player's decision making logic, producing a Move object.
-	move := player.MakeMove(game.Table(), valueConstraint)
-
-	// sanity check: check if the name in the Move corresponds to the player whose turn it is.
-	if !(move.PlayerName == playerName) {
-		panic(fmt.Sprintf("expected player name %v got player name %s", playerName, move.PlayerName))
-	}
+	// The context is never done, so the move is the player's (at worst a forfeit, see MakeMove).
+	start := time.Now()
+	move, _ := request.Solve(context.Background())
+	solveTime := time.Since(start)
 
 	// process the player's move. Stop if game has been won.
-	// If the move is not processed due to being illegal: panic hard.
-	// AI players should never produce illegal moves.
-	accepted, reason := game.ProcessMove(move)
-	if !accepted {
-		if reason == GAME_WON {
-			return
+	// AI players should never produce illegal moves: their arrangements are verified (see VerifySolution), but not against all rules
+	// of the game. If the move is not processed due to being illegal anyway, the case is logged and the player forfeits instead:
+	// the rejected move has left the turn with the player.
+	outcome, err := game.ProcessMove(move)
+	if err != nil && !errors.Is(err, GAME_OVER) {
+		LogInvalidSolution(request.RejectedMove(move, err))
+		outcome, err = game.ProcessMove(NewMove(playerName, game.Table()))
+		if err != nil {
+			return fmt.Errorf("AI player %v could not forfeit its turn: %w", playerName, err)
 		}
-		msg := fmt.Sprintf("AI player %v's move not accepted: \n why: %v. \n Offending move: %v \n current table: %v \n player hand : %v", playerName, reason, move, game.Table(), player.Hand())
-		panic(msg)
+	}
+	if err == nil && observe != nil {
+		observe(AITurn{playerName, solveTime, outcome})
+	}
+	if outcome == GAME_WON || err != nil {
+		return nil
 	}
 
 	// recurse until a non-AI player is encountered or the game is won.
-	game.RunAITurns()
+	return game.RunAITurnsObserved(observe)
+}
+
+// NextAIMove returns the request for the move of the player whose turn it is,
+// or false if that player is human or the game has been won.
+// The request is detached from the game state: the move can be computed while the game is worked on elsewhere,
+// after which it is played with ProcessMove (which rejects it if the turn has passed in the meantime).
+func (game *GameState) NextAIMove() (*AIMoveRequest, bool) {
+	player := game.CurrentPlayer()
+	if player.isHuman() || game.HasBeenWon() {
+		return nil, false
+	}
+	return game.moveRequest(player), true
+}
 
+// moveRequest returns the request for the move of the player, using the player's solver and budget.
+func (game *GameState) moveRequest(player *Player) *AIMoveRequest {
+	return &AIMoveRequest{
+		player: Player{
+			Name:        player.Name,
+			HandHistory: [][]Brick{append([]Brick{}, player.Hand()...)},
+			solver:      player.solver,
+			solveBudget: player.solveBudget,
+		},
+		table:     append([]BrickCombination{}, game.Table()...),
+		rules:     game.getRules(),
+		firstMove: game.IsFirstMove(player.Name),
+	}
 }
 
 // ProcessMove checks if the move is legal. If so: change the game state accordingly.
-// Returns whether the move has been successfully processed.
-func (game *GameState) ProcessMove(m Move) (bool, string) {
-	_, reason := game.IsLegalMove(m)
+// Returns the outcome of the move if it has been successfully processed, or a *RuleViolation if it has not.
+// A move that is not processed leaves the game state as it is: in particular, the turn does not pass.
+func (game *GameState) ProcessMove(m Move) (Outcome, error) {
+	outcome, violation := game.IsLegalMove(m)
 
 	player := game.CurrentPlayer()
 
 	// check if the game has already been won. If so; reject the new move.
 	if game.HasBeenWon() {
-		return false, GAME_WON
+		return "", newViolation(GAME_OVER)
+	}
+	if violation != nil {
+		return "", violation
 	}
 
 	// process the move
-	moveAccepted := false
-
-	switch reason {
+	switch outcome {
 
 	case LEGAL_MOVE:
 		// update player hand.
@@ -372,11 +547,9 @@ func (game *GameState) ProcessMove(m Move) (bool, string) {
 
 		// check if this move means the game has been won. If so, return early.
 		if game.HasBeenWon() {
-			return true, GAME_WON
+			return GAME_WON, nil
 		}
 
-		moveAccepted = true
-
 	case FORFEITED:
 		// save the move in the move history
 		game.commitMove(m)
@@ -387,14 +560,44 @@ func (game *GameState) ProcessMove(m Move) (bool, string) {
 		if err == nil {
 			player.SetHand(append(player.Hand(), *b))
 		}
-
-		moveAccepted = true
 	}
 
 	// If the game has not been won, increment the cyclic turn counter before returning.
 	game.cycleTurn()
 
-	return moveAccepted, reason
+	return outcome, nil
+}
+
+// Copy returns a deep copy of the game state, which is not affected by the moves made in the game afterwards (e.g. to save it).
+// The players keep their solvers.
+func (game *GameState) Copy() *GameState {
+	copied := *game
+	copied.Pile = append(game.Pile[:0:0], game.Pile...)
+	copied.Rules.Colors = append(game.Rules.Colors[:0:0], game.Rules.Colors...)
+
+	copied.Players = append(game.Players[:0:0], game.Players...)
+	for i := range copied.Players {
+		hands := copied.Players[i].HandHistory
+		copied.Players[i].HandHistory = append(hands[:0:0], hands...)
+		for j, hand := range hands {
+			copied.Players[i].HandHistory[j] = append(hand[:0:0], hand...)
+		}
+	}
+
+	copied.MoveHistory = append(game.MoveHistory[:0:0], game.MoveHistory...)
+	for i, move := range game.MoveHistory {
+		copied.MoveHistory[i].Arrangement = copyCombinations(move.Arrangement)
+	}
+	return &copied
+}
+
+// copyCombinations returns a deep copy of the combinations.
+func copyCombinations(combinations []BrickCombination) []BrickCombination {
+	copied := combinations[:0:0]
+	for i := range combinations {
+		copied = append(copied, combinations[i].Copy())
+	}
+	return copied
 }
 
 func (game *GameState) Serialize() []byte {
@@ -1,6 +1,7 @@
 package rummikub
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,7 +20,8 @@ func TestGame_IsLegalMove_IllegalCases(t *testing.T) {
 	})
 
 	// the table
-	game := NewEmptyGame(gamerules, player)
+	game, err := NewEmptyGame(gamerules, player)
+	assert.NoError(t, err, "error initiating game")
 	a := NewBrickCombination(
 		Brick{Color: "green", Value: 3},
 		Brick{Color: "green", Value: 2},
@@ -45,10 +47,14 @@ func TestGame_IsLegalMove_IllegalCases(t *testing.T) {
 		Brick{Color: "red", Value: 1},
 	)
 	move := NewMove("testplayer", []BrickCombination{a, b, c, suggestedAdditionalCombination})
-	valid, why := game.IsLegalMove(move)
-	t.Logf("\n %v", why)
-	assert.Equal(t, valid, false, "Move is illegal at the combination level, but is still tagged as legal!!")
-	assert.Equal(t, why, ILLEGAL_COMBINATION, "move was passed/rejected for the wrong reason")
+	outcome, err := game.IsLegalMove(move)
+	t.Logf("\n %v", err)
+	assert.Error(t, err, "Move is illegal at the combination level, but is still tagged as legal!!")
+	assert.True(t, errors.Is(err, ILLEGAL_COMBINATION), "move was passed/rejected for the wrong reason")
+	if violation, ok := err.(*RuleViolation); assert.True(t, ok, "IsLegalMove did not return a *RuleViolation") {
+		assert.Equal(t, 3, violation.Combination, "the wrong combination was marked as offending")
+		assert.Equal(t, suggestedAdditionalCombination.Bricks, violation.Bricks, "the offending bricks were not attached")
+	}
 
 	// simulate an illegal move (player doesnt own stones of new combination)
 	suggestedAdditionalCombination = NewBrickCombination(
@@ -57,24 +63,28 @@ func TestGame_IsLegalMove_IllegalCases(t *testing.T) {
 		Brick{Color: "red", Value: 1},
 	)
 	move = NewMove("testplayer", []BrickCombination{a, b, c, suggestedAdditionalCombination})
-	valid, why = game.IsLegalMove(move)
-	t.Logf("\n %v", why)
-	assert.Equal(t, valid, false, "Move consists of unowned, but is still tagged as legal!!")
-	assert.Equal(t, why, NOT_OWNED, "move was passed/rejected for the wrong reason")
+	outcome, err = game.IsLegalMove(move)
+	t.Logf("\n %v", err)
+	assert.Error(t, err, "Move consists of unowned, but is still tagged as legal!!")
+	assert.True(t, errors.Is(err, NOT_OWNED), "move was passed/rejected for the wrong reason")
+	if violation, ok := err.(*RuleViolation); assert.True(t, ok, "IsLegalMove did not return a *RuleViolation") {
+		assert.Equal(t, NO_COMBINATION, violation.Combination, "a combination was marked as offending")
+		assert.Equal(t, []Brick{{Color: "green", Value: 5}}, violation.Bricks, "the unowned bricks were not attached")
+	}
 
 	// simulate an illegal move (proposed field is magically smaller than the current field)
 	move = NewMove("testplayer", []BrickCombination{a, b})
-	valid, why = game.IsLegalMove(move)
-	t.Logf("\n %v", why)
-	assert.Equal(t, valid, false, "Proposed move field is smaller than current field, but is still tagged as legal!!")
-	assert.Equal(t, why, BRICKS_REMOVED, "move was passed/rejected for the wrong reason")
+	outcome, err = game.IsLegalMove(move)
+	t.Logf("\n %v", err)
+	assert.Error(t, err, "Proposed move field is smaller than current field, but is still tagged as legal!!")
+	assert.True(t, errors.Is(err, BRICKS_REMOVED), "move was passed/rejected for the wrong reason")
 
 	// simulate a forfeiture
 	move = NewMove("testplayer", []BrickCombination{a, b, c})
-	valid, why = game.IsLegalMove(move)
-	t.Logf("\n %v", why)
-	assert.Equal(t, valid, true, "Player forfeited, but move tagged as illegal!!")
-	assert.Equal(t, why, FORFEITED, "move was passed/rejected for the wrong reason")
+	outcome, err = game.IsLegalMove(move)
+	t.Logf("\n %v", err)
+	assert.NoError(t, err, "Player forfeited, but move tagged as illegal!!")
+	assert.Equal(t, FORFEITED, outcome, "move was passed/rejected for the wrong reason")
 }
 
 func TestGame_IsLegalMove_NotYourTurn(t *testing.T) {
@@ -100,18 +110,19 @@ func TestGame_IsLegalMove_NotYourTurn(t *testing.T) {
 	})
 
 	// build the game struct
-	game := NewEmptyGame(gamerules, playerA, playerB)
+	game, err := NewEmptyGame(gamerules, playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
 
 	// check that it is player A's turn
 	assert.Equal(t, game.CurrentPlayer().getName(), playerA.getName(), "it is not player A's turn.")
 
 	// make a move signed by Player B, while it is player A's turn.
-	move := playerB.MakeMove(game.Table(), 0)
+	move := playerB.MakeMove(game.Table(), gamerules, true)
 
 	// present the move to the game
-	accepted, why := game.ProcessMove(move)
-	assert.False(t, accepted, "move is accepted, but should not be; it is not this player's turn")
-	assert.Equal(t, NOT_YOUR_TURN, why, "The wrong error message was returned trying to add this player's move: it is not this player's turn.")
+	_, err = game.ProcessMove(move)
+	assert.Error(t, err, "move is accepted, but should not be; it is not this player's turn")
+	assert.True(t, errors.Is(err, NOT_YOUR_TURN), "The wrong error message was returned trying to add this player's move: it is not this player's turn.")
 
 }
 
@@ -137,7 +148,8 @@ func TestGame_IsLegalMove_FirstMoveValue(t *testing.T) {
 	})
 
 	// the table
-	game := NewEmptyGame(gamerules, player)
+	game, err := NewEmptyGame(gamerules, player)
+	assert.NoError(t, err, "error initiating game")
 	a := NewBrickCombination(
 		Brick{Color: "green", Value: 3},
 		Brick{Color: "green", Value: 2},
@@ -165,10 +177,10 @@ func TestGame_IsLegalMove_FirstMoveValue(t *testing.T) {
 	)
 
 	move := NewMove(player.getName(), []BrickCombination{a, b, c, suggestedAdditionalCombination})
-	valid, why := game.IsLegalMove(move)
-	t.Logf("\n %v", why)
-	assert.Equal(t, true, valid, "Move is legal, but marked as illegal!")
-	assert.Equal(t, LEGAL_MOVE, why, "move was passed/rejected for the wrong reason")
+	outcome, err := game.IsLegalMove(move)
+	t.Logf("\n %v", err)
+	assert.NoError(t, err, "Move is legal, but marked as illegal!")
+	assert.Equal(t, LEGAL_MOVE, outcome, "move was passed/rejected for the wrong reason")
 
 	// simulate a ILLEGAL move of an INappropriate value (for a first move)
 	illegalCombination := NewBrickCombination(
@@ -178,8 +190,67 @@ func TestGame_IsLegalMove_FirstMoveValue(t *testing.T) {
 	)
 
 	moveIll := NewMove(player.getName(), []BrickCombination{a, b, c, illegalCombination})
-	valid, why = game.IsLegalMove(moveIll)
-	t.Logf("\n %v", why)
-	assert.Equal(t, false, valid, "Move is illegal, but marked as legal!")
-	assert.Equal(t, VALUE_INSUFFICIENT, why, "move was passed/rejected for the wrong reason")
+	outcome, err = game.IsLegalMove(moveIll)
+	t.Logf("\n %v", err)
+	assert.Error(t, err, "Move is illegal, but marked as legal!")
+	assert.True(t, errors.Is(err, VALUE_INSUFFICIENT), "move was passed/rejected for the wrong reason")
+}
+
+func TestGame_IsLegalMove_FirstMoveRules(t *testing.T) {
+	gamerules := NewDefaultRules()
+	gamerules.FirstMoveHandOnly = true
+
+	player := NewHumanPlayer("testplayer")
+	player.SetHand([]Brick{
+		{Color: "red", Value: 4},
+		{Color: "red", Value: 5},
+		{Color: "blue", Value: 6},
+		MakeJoker(),
+	})
+
+	game, err := NewEmptyGame(gamerules, player)
+	assert.NoError(t, err, "error initiating game")
+	a := NewBrickCombination(
+		Brick{Color: "red", Value: 6},
+		Brick{Color: "red", Value: 7},
+		Brick{Color: "red", Value: 8},
+		Brick{Color: "red", Value: 9},
+	)
+	game.commitMove(Move{Arrangement: []BrickCombination{a}})
+
+	// extending a combination on the table is not allowed on a hand-only first move, even if the value suffices.
+	extended := NewBrickCombination(
+		Brick{Color: "red", Value: 4},
+		Brick{Color: "red", Value: 5},
+		Brick{Color: "red", Value: 6},
+		Brick{Color: "red", Value: 7},
+		Brick{Color: "red", Value: 8},
+		Brick{Color: "red", Value: 9},
+	)
+	_, err = game.IsLegalMove(NewMove(player.getName(), []BrickCombination{extended}))
+	assert.True(t, errors.Is(err, TABLE_REARRANGED), "move was passed/rejected for the wrong reason: %v", err)
+
+	// the joker stands in for the red 6: 4 + 5 + 6 = 15.
+	run := NewBrickCombination(Brick{Color: "red", Value: 4}, Brick{Color: "red", Value: 5}, MakeJoker())
+	outcome, err := game.IsLegalMove(NewMove(player.getName(), []BrickCombination{a, run}))
+	assert.NoError(t, err, "Move is legal, but marked as illegal!")
+	assert.Equal(t, LEGAL_MOVE, outcome)
+
+	// valued at 1, the joker does not make the threshold.
+	gamerules.FirstMoveJokerValuation = JOKER_FIXED
+	gamerules.FirstMoveJokerValue = 1
+	game.Rules = gamerules
+	_, err = game.IsLegalMove(NewMove(player.getName(), []BrickCombination{a, run}))
+	assert.True(t, errors.Is(err, VALUE_INSUFFICIENT), "move was passed/rejected for the wrong reason: %v", err)
+
+	// when the table may be used (as it may by default), only the new bricks count: extending the run with 4 + 5 = 9 is not enough,
+	// but 4 + 5 and a joker standing in for the red 10 is.
+	game.Rules = NewDefaultRules()
+	short := NewBrickCombination(Brick{Color: "red", Value: 4}, Brick{Color: "red", Value: 5}, Brick{Color: "red", Value: 6}, Brick{Color: "red", Value: 7})
+	rest := NewBrickCombination(Brick{Color: "red", Value: 8}, Brick{Color: "red", Value: 9}, MakeJoker())
+	_, err = game.IsLegalMove(NewMove(player.getName(), []BrickCombination{extended}))
+	assert.True(t, errors.Is(err, VALUE_INSUFFICIENT), "move was passed/rejected for the wrong reason: %v", err)
+	outcome, err = game.IsLegalMove(NewMove(player.getName(), []BrickCombination{short, rest}))
+	assert.NoError(t, err, "Move is legal, but marked as illegal!")
+	assert.Equal(t, LEGAL_MOVE, outcome)
 }
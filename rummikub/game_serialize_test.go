@@ -19,7 +19,8 @@ func TestGame_Serialize_Inspect(t *testing.T) {
 	playerB := NewAIPlayer("testplayerB", NewILPSolver(gamerules))
 
 	// initiate the game
-	game := NewGame(gamerules, 88, playerA, playerB)
+	game, err := NewGame(gamerules, 88, playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
 
 	// run the game to completion
 	game.RunAITurns()
@@ -49,7 +50,8 @@ func TestGame_DeSerialize(t *testing.T) {
 	playerB := NewAIPlayer("testplayerB", NewILPSolver(gamerules))
 
 	// initiate the game
-	gameA := NewGame(gamerules, 88, playerA, playerB)
+	gameA, err := NewGame(gamerules, 88, playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
 
 	serializedYoungGame := gameA.Serialize()
 
@@ -72,6 +74,31 @@ func TestGame_DeSerialize(t *testing.T) {
 
 }
 
+func TestGame_Copy(t *testing.T) {
+	gamerules := NewDefaultRules()
+	game, err := NewGame(gamerules, 8, NewAIPlayer("AI", NewILPSolver(gamerules)), NewHumanPlayer("Human"))
+	assert.NoError(t, err, "error initiating game")
+	assert.NoError(t, game.RunAITurns())
+
+	// the copy is not affected by the moves made in the game afterwards.
+	copied := game.Copy()
+	serialized := copied.Serialize()
+	assert.Equal(t, game.Serialize(), serialized)
+
+	_, err = game.ProcessMove(NewMove("Human", game.Table()))
+	assert.NoError(t, err)
+	assert.NoError(t, game.RunAITurns())
+	assert.Len(t, game.MoveHistory, 3)
+	assert.Equal(t, serialized, copied.Serialize(), "the copy changed along with the game")
+
+	// the copy can be played on.
+	assert.Equal(t, "Human", copied.CurrentPlayer().Name)
+	_, err = copied.ProcessMove(NewMove("Human", copied.Table()))
+	assert.NoError(t, err)
+	assert.NoError(t, copied.RunAITurns())
+	assert.Len(t, copied.MoveHistory, 3)
+}
+
 // test whether serializing a fresh game yields the same result (i.e. saving the seed works).
 // Note that the resulting game states are not exactly bytewise equal, but should have:
 // The same winner
@@ -137,3 +164,28 @@ func TestGame_DeSerialize(t *testing.T) {
 //	}
 //
 //}
+
+// Check whether the bricks the jokers stand in for survive serialization.
+func TestGame_DeSerialize_Jokers(t *testing.T) {
+	gamerules := NewDefaultRules()
+	player := NewHumanPlayer("testplayer")
+	player.SetHand([]Brick{{Value: 11, Color: "red"}, MakeJoker(), {Value: 13, Color: "red"}, {Value: 1, Color: "blue"}})
+	game, err := NewEmptyGame(gamerules, player)
+	assert.NoError(t, err, "error initiating game")
+
+	run := NewBrickCombination(Brick{Value: 11, Color: "red"}, MakeJoker(), Brick{Value: 13, Color: "red"})
+	outcome, err := game.ProcessMove(NewMove("testplayer", []BrickCombination{run}))
+	assert.NoError(t, err)
+	assert.Equal(t, LEGAL_MOVE, outcome)
+	assert.Equal(t, []Brick{{Value: 12, Color: "red"}}, game.Table()[0].Jokers)
+
+	var deserialized GameState
+	assert.NoError(t, json.Unmarshal(game.Serialize(), &deserialized))
+	assert.Equal(t, game.Table(), deserialized.Table())
+
+	// forfeiting with the table as it was does not change what the joker stands for.
+	run.Jokers = nil
+	_, err = deserialized.ProcessMove(NewMove("testplayer", []BrickCombination{run}))
+	assert.NoError(t, err)
+	assert.Equal(t, []Brick{{Value: 12, Color: "red"}}, deserialized.Table()[0].Jokers)
+}
@@ -1,8 +1,11 @@
 package rummikub
 
 import (
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestGame_cycleTurns(t *testing.T) {
@@ -28,7 +31,8 @@ func TestGame_cycleTurns(t *testing.T) {
 	playerB.SetHand(playerBHand)
 
 	// initiate the game
-	game := NewEmptyGame(gamerules, playerA, playerB)
+	game, err := NewEmptyGame(gamerules, playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
 
 	// check that the turn counter starts at 0
 	assert.Equal(t, 0, game.CurrentTurn, "turn counter does not start at zero")
@@ -74,7 +78,8 @@ func TestGame_ProcessMove_AcceptedMove(t *testing.T) {
 	playerB.SetHand(playerBHand)
 
 	// initiate the game
-	game := NewEmptyGame(gamerules, playerA, playerB)
+	game, err := NewEmptyGame(gamerules, playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
 
 	// save the next brick to be popped from the pile and the size of the pile
 	pileSize := len(game.Pile)
@@ -93,9 +98,9 @@ func TestGame_ProcessMove_AcceptedMove(t *testing.T) {
 		},
 	})
 
-	accepted, why := game.ProcessMove(move)
-	assert.True(t, accepted, "Move was incorrectly rejected")
-	assert.Equal(t, LEGAL_MOVE, why, "Move was accepted/rejected for the wrong reason.")
+	outcome, err := game.ProcessMove(move)
+	assert.NoError(t, err, "Move was incorrectly rejected")
+	assert.Equal(t, LEGAL_MOVE, outcome, "Move was accepted/rejected for the wrong reason.")
 
 	// - verify that the turn counter has been incremented
 	assert.Equal(t, turn+1, game.CurrentTurn, "turn counter has not been incremented.")
@@ -137,7 +142,8 @@ func TestGame_ProcessMove_GameWon(t *testing.T) {
 	playerB.SetHand(playerBHand)
 
 	// initiate the game
-	game := NewEmptyGame(gamerules, playerA, playerB)
+	game, err := NewEmptyGame(gamerules, playerA, playerB)
+	assert.NoError(t, err, "error initiating game")
 
 	// save the next brick to be popped from the pile and the size of the pile
 	pileSize := len(game.Pile)
@@ -155,9 +161,9 @@ func TestGame_ProcessMove_GameWon(t *testing.T) {
 			},
 		},
 	})
-	accepted, why := game.ProcessMove(move)
-	assert.True(t, accepted, "Move was incorrectly rejected")
-	assert.Equal(t, GAME_WON, why, "Move was accepted/rejected for the wrong reason.")
+	outcome, err := game.ProcessMove(move)
+	assert.NoError(t, err, "Move was incorrectly rejected")
+	assert.Equal(t, GAME_WON, outcome, "Move was accepted/rejected for the wrong reason.")
 
 	// - verify that the turn counter has not been incremented after the winning move.
 	assert.Equal(t, turn, game.CurrentTurn, "turn counter has changed unexpectedly.")
@@ -193,7 +199,8 @@ func TestGame_ProcessMove_Forfeited(t *testing.T) {
 	player.SetHand(playerHand)
 
 	// initiate the game
-	game := NewEmptyGame(gamerules, player)
+	game, err := NewEmptyGame(gamerules, player)
+	assert.NoError(t, err, "error initiating game")
 
 	// manually populate the table
 	tmpTable := []BrickCombination{
@@ -217,9 +224,9 @@ func TestGame_ProcessMove_Forfeited(t *testing.T) {
 
 	// CASE: a turn forfeiture (player proposes the same arrangement as is currently on the table)
 	move := NewMove(player.getName(), tmpTable)
-	accepted, why := game.ProcessMove(move)
-	assert.True(t, accepted, "Move was incorrectly rejected")
-	assert.Equal(t, FORFEITED, why, "Move was accepted/rejected for the wrong reason.")
+	outcome, err := game.ProcessMove(move)
+	assert.NoError(t, err, "Move was incorrectly rejected")
+	assert.Equal(t, FORFEITED, outcome, "Move was accepted/rejected for the wrong reason.")
 
 	// - verify that the rearrangement history has not changed
 	assert.Equal(t, 2, len(game.MoveHistory), "move history length is not as expected")
@@ -249,7 +256,8 @@ func TestGame_ProcessMove_RejectedMove(t *testing.T) {
 	player.SetHand(playerHand)
 
 	// initiate the game
-	game := NewEmptyGame(gamerules, player)
+	game, err := NewEmptyGame(gamerules, player)
+	assert.NoError(t, err, "error initiating game")
 
 	// CASE: an illegal move (player does not have the bricks required to make the move)
 	move := NewMove(player.getName(), []BrickCombination{
@@ -259,9 +267,9 @@ func TestGame_ProcessMove_RejectedMove(t *testing.T) {
 			},
 		},
 	})
-	accepted, why := game.ProcessMove(move)
-	assert.False(t, accepted, "Move was incorrectly accepted")
-	assert.Equal(t, NOT_OWNED, why, "Move was accepted/rejected for the wrong reason.")
+	_, err = game.ProcessMove(move)
+	assert.Error(t, err, "Move was incorrectly accepted")
+	assert.True(t, errors.Is(err, NOT_OWNED), "Move was accepted/rejected for the wrong reason.")
 
 	// - verify that the rearrangement history has not changed
 	assert.Equal(t, 0, len(game.MoveHistory), "move history length is not 0")
@@ -280,13 +288,16 @@ func TestGame_Pile_Deterministic(t *testing.T) {
 	playerA := NewAIPlayer("A", NewILPSolver(gamerules))
 
 	seedA := int64(20)
-	gameAa := NewGame(gamerules, seedA, playerA)
-	gameAb := NewGame(gamerules, seedA, playerA)
+	gameAa, err := NewGame(gamerules, seedA, playerA)
+	assert.NoError(t, err, "error initiating game")
+	gameAb, err := NewGame(gamerules, seedA, playerA)
+	assert.NoError(t, err, "error initiating game")
 
 	assert.Equal(t, gameAa.Pile, gameAb.Pile, "Initiating a game with the same seed does not lead to the same pile")
 
 	seedB := int64(10)
-	gameBa := NewGame(gamerules, seedB, playerA)
+	gameBa, err := NewGame(gamerules, seedB, playerA)
+	assert.NoError(t, err, "error initiating game")
 
 	assert.NotEqual(t, gameBa.Pile, gameAa.Pile, "Initiating a game with a different seed does not lead to a different pile")
 	assert.NotEqual(t, gameBa.Pile, gameAb.Pile, "Initiating a game with a different seed does not lead to a different pile")
@@ -301,7 +312,8 @@ func TestGame_RunAITurns(t *testing.T) {
 	playerC := NewAIPlayer("C", NewILPSolver(gamerules))
 
 	seed := int64(20)
-	game := NewGame(gamerules, seed, playerA, playerB, playerC)
+	game, err := NewGame(gamerules, seed, playerA, playerB, playerC)
+	assert.NoError(t, err, "error initiating game")
 
 	// run the game
 	game.RunAITurns()
@@ -342,7 +354,8 @@ func TestGame_PileExhaustion(t *testing.T) {
 	playerA := NewAIPlayer("A", NewILPSolver(gamerules))
 
 	seed := int64(8)
-	game := NewGame(gamerules, seed, playerA)
+	game, err := NewGame(gamerules, seed, playerA)
+	assert.NoError(t, err, "error initiating game")
 
 	assert.True(t, len(game.Pile) > 1, "game pile size at game start too small.")
 	expectedSize := len(gamerules.AllBricks()) - (gamerules.StartingHandSize * len(game.Players))
@@ -356,7 +369,7 @@ func TestGame_PileExhaustion(t *testing.T) {
 	assert.True(t, len(game.Pile) == goalPileSize, "game pile not truncated by popping.")
 
 	// pop the pile past the refresh checkpoint
-	_, err := game.popFromPile()
+	_, err = game.popFromPile()
 	assert.NoError(t, err, "Could not draw last brick from the pile")
 
 	_, err = game.popFromPile()
@@ -376,7 +389,8 @@ func TestGame_RunAITurns_StopAtNonAI(t *testing.T) {
 	playerHuman := NewHumanPlayer("Human_1")
 
 	seed := int64(8)
-	game := NewGame(gamerules, seed, playerAIa, playerAIb, playerHuman)
+	game, err := NewGame(gamerules, seed, playerAIa, playerAIb, playerHuman)
+	assert.NoError(t, err, "error initiating game")
 
 	// AI was added first, so the first turn is for the AI.
 	game.RunAITurns()
@@ -386,9 +400,9 @@ func TestGame_RunAITurns_StopAtNonAI(t *testing.T) {
 	assert.Equal(t, game.MoveHistory[1].PlayerName, playerAIb.getName(), "First moves were not made by the AI player")
 
 	// construct a move for the human player
-	m := playerHuman.MakeMove(game.Table(), 0)
-	accepted, _ := game.ProcessMove(m)
-	assert.True(t, accepted, "Human player's move was not accepted.")
+	m := playerHuman.MakeMove(game.Table(), gamerules, game.IsFirstMove(playerHuman.getName()))
+	_, err = game.ProcessMove(m)
+	assert.NoError(t, err, "Human player's move was not accepted.")
 	assert.Equal(t, game.MoveHistory[2].PlayerName, playerHuman.getName(), "Third move was not made by the human player")
 
 	// run the AIs again.
@@ -402,3 +416,130 @@ func TestGame_RunAITurns_StopAtNonAI(t *testing.T) {
 //func TestGame_VaryingGamerules(t *testing.T){
 //	assert.Fail(t, "TODO permute the game rules and test a series of randomized games per permutation.")
 //}
+
+func TestGame_NewGame_InvalidSetup(t *testing.T) {
+	gamerules := NewDefaultRules()
+
+	// two players with the same name can not take turns.
+	playerA := NewHumanPlayer("A")
+	playerB := NewHumanPlayer("A")
+	_, err := NewEmptyGame(gamerules, playerA, playerB)
+	assert.Equal(t, &PlayerError{"A", DUPLICATE_PLAYER_NAME}, err, "duplicate player names were not rejected")
+
+	// the pile should not run dry while distributing the starting hands.
+	players := []Player{}
+	for _, name := range []string{"A", "B", "C", "D", "E", "F", "G", "H"} {
+		players = append(players, NewHumanPlayer(name))
+	}
+	game, err := NewGame(gamerules, 8, players...)
+	assert.Nil(t, game, "a game was returned for invalid rules")
+	assert.Equal(t, &RulesError{"starting_hand_size", PILE_TOO_SMALL}, err, "oversized starting hands were not rejected")
+	assert.True(t, errors.Is(err, PILE_TOO_SMALL), "the error does not match its reason")
+}
+
+func TestGame_Resign(t *testing.T) {
+	gamerules := NewDefaultRules()
+
+	playerA := NewHumanPlayer("A")
+	playerB := NewHumanPlayer("B")
+	playerC := NewHumanPlayer("C")
+	game, err := NewGame(gamerules, 8, playerA, playerB, playerC)
+	assert.NoError(t, err, "error initiating game")
+
+	// unknown players can not resign.
+	assert.Equal(t, &PlayerError{"D", UNKNOWN_PLAYER}, game.Resign("D"), "unknown player was allowed to resign")
+	assert.True(t, errors.Is(game.Resign("D"), UNKNOWN_PLAYER), "the error does not match its reason")
+	assert.False(t, errors.Is(game.Resign("D"), ALREADY_RESIGNED), "the error matches another reason")
+
+	// resigning on your own turn passes the turn on.
+	assert.NoError(t, game.Resign("A"), "player A could not resign")
+	assert.Equal(t, "B", game.CurrentPlayer().getName(), "turn was not passed on after resignation")
+	assert.Equal(t, &PlayerError{"A", ALREADY_RESIGNED}, game.Resign("A"), "player A resigned twice")
+	assert.False(t, game.HasBeenWon(), "game was won while two players remain")
+
+	// resigned players are skipped.
+	game.cycleTurn()
+	assert.Equal(t, "C", game.CurrentPlayer().getName(), "turn counter does not point at the right player")
+	game.cycleTurn()
+	assert.Equal(t, "B", game.CurrentPlayer().getName(), "resigned player was not skipped")
+
+	// the last player standing wins, and is the current player.
+	assert.NoError(t, game.Resign("B"), "player B could not resign")
+	assert.True(t, game.HasBeenWon(), "game was not won by the last remaining player")
+	assert.Equal(t, "C", game.CurrentPlayer().getName(), "winner is not the current player")
+	assert.True(t, errors.Is(game.Resign("C"), GAME_OVER), "player resigned from a game that is over")
+}
+
+func TestGame_RecordHint(t *testing.T) {
+	game, err := NewEmptyGame(NewDefaultRules(), NewHumanPlayer("A"), NewAIPlayer("B", &DummySolver{}))
+	assert.NoError(t, err, "error initiating game")
+
+	assert.NoError(t, game.RecordHint("A"))
+	assert.NoError(t, game.RecordHint("A"))
+	assert.Equal(t, 2, game.GetPlayer("A").HintsUsed, "hints were not counted")
+
+	assert.Equal(t, &PlayerError{"B", NOT_HUMAN}, game.RecordHint("B"), "AI player was given a hint")
+	assert.Equal(t, &PlayerError{"C", UNKNOWN_PLAYER}, game.RecordHint("C"), "unknown player was given a hint")
+
+	// the setting and the counters survive serialization.
+	game.HintsDisabled = true
+	restored := DeserializeGame(game.Serialize())
+	assert.True(t, restored.HintsDisabled)
+	assert.Equal(t, 2, restored.GetPlayer("A").HintsUsed)
+	assert.Equal(t, ErrHintsDisabled, restored.RecordHint("A"), "hint given while hints are disabled")
+	assert.Equal(t, 2, restored.GetPlayer("A").HintsUsed)
+}
+
+func TestGame_HintRequest(t *testing.T) {
+	playerA := NewHumanPlayer("A")
+	playerA.SetHand([]Brick{{Color: "red", Value: 10}, {Color: "red", Value: 11}, {Color: "red", Value: 12}})
+	game, err := NewEmptyGame(NewDefaultRules(), playerA, NewAIPlayer("B", &DummySolver{}))
+	assert.NoError(t, err, "error initiating game")
+
+	// the hint is the move the solver would make in the player's place, and is not counted until it is recorded.
+	request, err := game.HintRequest("A", NewILPSolver(game.Rules), time.Minute)
+	assert.NoError(t, err)
+	move, err := request.Solve(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, move.Bricks(), 3)
+	assert.Equal(t, 0, game.GetPlayer("A").HintsUsed, "hint counted before it was recorded")
+
+	_, err = game.HintRequest("B", &DummySolver{}, time.Minute)
+	assert.Equal(t, &PlayerError{"B", NOT_HUMAN}, err, "AI player was given a hint")
+	game.HintsDisabled = true
+	_, err = game.HintRequest("A", &DummySolver{}, time.Minute)
+	assert.Equal(t, ErrHintsDisabled, err, "hint given while hints are disabled")
+}
+
+func TestGame_Scores(t *testing.T) {
+	gamerules := NewDefaultRules()
+
+	playerA := NewHumanPlayer("A")
+	playerA.SetHand([]Brick{{Color: "yellow", Value: 5}, {Color: "blue", Value: 5}, {Color: "red", Value: 5}})
+	playerB := NewHumanPlayer("B")
+	playerB.SetHand([]Brick{{Color: "yellow", Value: 1}, {Color: "blue", Value: 12}})
+	playerC := NewHumanPlayer("C")
+	playerC.SetHand([]Brick{{Color: JokerColor, Value: 1}, {Color: "red", Value: 3}})
+
+	game, err := NewEmptyGame(gamerules, playerA, playerB, playerC)
+	assert.NoError(t, err, "error initiating game")
+
+	// no scores before the game has been won.
+	assert.Nil(t, game.Winner())
+	assert.Nil(t, game.Scores())
+
+	outcome, err := game.ProcessMove(NewMove("A", []BrickCombination{
+		{Bricks: []Brick{{Color: "yellow", Value: 5}, {Color: "blue", Value: 5}, {Color: "red", Value: 5}}},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, GAME_WON, outcome)
+
+	if assert.NotNil(t, game.Winner()) {
+		assert.Equal(t, "A", game.Winner().Name)
+	}
+	assert.Equal(t, map[string]int{
+		"A": 13 + JOKER_PENALTY + 3,
+		"B": -13,
+		"C": -(JOKER_PENALTY + 3),
+	}, game.Scores())
+}
@@ -1,5 +1,10 @@
 package rummikub
 
+import (
+	"fmt"
+	"sort"
+)
+
 type Rules struct {
 	JokersPerCombination int      `json:"jokers_per_combination"`
 	Values               int      `json:"values"`
@@ -10,8 +15,26 @@ type Rules struct {
 
 	// minimum summed value of a first move
 	FirstMoveValue int `json:"first_move_value"`
+
+	// whether a first move may only add combinations made from the player's own bricks, leaving the combinations on the table as they are.
+	FirstMoveHandOnly bool `json:"first_move_hand_only"`
+
+	// how jokers count towards the value of a first move (JOKER_REPRESENTED if empty), and their value if they count as JOKER_FIXED.
+	FirstMoveJokerValuation JokerValuation `json:"first_move_joker_valuation,omitempty"`
+	FirstMoveJokerValue     int            `json:"first_move_joker_value,omitempty"`
 }
 
+// JokerValuation defines how jokers count towards the value of a first move.
+type JokerValuation string
+
+const (
+	// a joker counts as the brick it stands in for.
+	JOKER_REPRESENTED JokerValuation = "represented"
+
+	// a joker counts as Rules.FirstMoveJokerValue.
+	JOKER_FIXED JokerValuation = "fixed"
+)
+
 // NewDefaultRules returns the default game rules.
 // Primarily useful to reduce the verbosity of unit tests.
 func NewDefaultRules() Rules {
@@ -26,6 +49,95 @@ func NewDefaultRules() Rules {
 	}
 }
 
+// RulesError is returned when a Rules struct does not describe a playable game.
+// It can be matched against its reason with errors.Is, e.g. errors.Is(err, PILE_TOO_SMALL).
+type RulesError struct {
+	// the json name of the offending Rules field.
+	Field string
+
+	// why the field is invalid (see the rules violations in violation.go).
+	Reason ViolationCode
+}
+
+func (e *RulesError) Error() string {
+	return fmt.Sprintf("invalid game rules: %v: %v", e.Field, e.Reason)
+}
+
+// Is reports whether the error carries the target ViolationCode, or has the same reason as the target RulesError.
+func (e *RulesError) Is(target error) bool {
+	switch t := target.(type) {
+	case ViolationCode:
+		return e.Reason == t
+	case *RulesError:
+		return e.Reason == t.Reason
+	}
+	return false
+}
+
+// Validate checks whether a game with the given number of players can be played according to the receiving Rules struct.
+// Returns a *RulesError describing the first problem that was found, or nil if the rules are sane.
+func (g Rules) Validate(nPlayers int) error {
+	if nPlayers < 1 {
+		return &RulesError{"players", NOT_ENOUGH_PLAYERS}
+	}
+
+	if g.Values < 1 {
+		return &RulesError{"values", NO_VALUES}
+	}
+
+	if len(g.Colors) == 0 {
+		return &RulesError{"colors", NO_COLORS}
+	}
+	seen := map[string]bool{}
+	for _, col := range g.Colors {
+		if col == "" {
+			return &RulesError{"colors", EMPTY_COLOR}
+		}
+		if col == JokerColor {
+			return &RulesError{"colors", RESERVED_COLOR}
+		}
+		if seen[col] {
+			return &RulesError{"colors", DUPLICATE_COLOR}
+		}
+		seen[col] = true
+	}
+
+	if g.Replicates < 1 {
+		return &RulesError{"replicates", NO_REPLICATES}
+	}
+
+	if g.JokersInPlay < 0 {
+		return &RulesError{"jokers_in_play", NEGATIVE_JOKERS}
+	}
+	if g.JokersPerCombination < 0 {
+		return &RulesError{"jokers_per_combination", NEGATIVE_JOKERS}
+	}
+	if g.JokersPerCombination > g.JokersInPlay {
+		return &RulesError{"jokers_per_combination", JOKERS_PER_COMBINATION_EXCEED}
+	}
+
+	if g.StartingHandSize < 1 {
+		return &RulesError{"starting_hand_size", NO_STARTING_HAND}
+	}
+	if g.StartingHandSize*nPlayers > len(g.AllBricks()) {
+		return &RulesError{"starting_hand_size", PILE_TOO_SMALL}
+	}
+
+	if g.FirstMoveValue < 0 {
+		return &RulesError{"first_move_value", NEGATIVE_FIRST_MOVE_VALUE}
+	}
+	switch g.FirstMoveJokerValuation {
+	case "", JOKER_REPRESENTED, JOKER_FIXED:
+	default:
+		return &RulesError{"first_move_joker_valuation", UNKNOWN_JOKER_VALUATION}
+	}
+	if g.FirstMoveJokerValue < 0 {
+		return &RulesError{"first_move_joker_value", NEGATIVE_FIRST_MOVE_VALUE}
+	}
+
+	return nil
+}
+
 // BaseBricks gets all the UNIQUE getBricks that are in the Rummikub play set described by the receiving Rules struct.
 // NOTE: EXCEPT the jokers! (for flexibility)
 func (g Rules) BaseBricks() []Brick {
@@ -59,7 +171,8 @@ func (g Rules) AllBricks() []Brick {
 	return bricks
 }
 
-func (g *Rules) IsLegalCombination(c BrickCombination) (bool, string) {
+// IsLegalCombination returns a *RuleViolation if the combination is not legal given the game rules, or nil if it is.
+func (g *Rules) IsLegalCombination(c BrickCombination) error {
 	// test if a combination is legal given the game rules
 	// resistant to user input
 
@@ -73,14 +186,14 @@ func (g *Rules) IsLegalCombination(c BrickCombination) (bool, string) {
 			}
 		}
 		if !ok {
-			return false, UNKNOWN_COLOR
+			return c.violation(UNKNOWN_COLOR)
 		}
 	}
 
 	// test if brick values are legal according to the game rules
 	for _, brick := range c.getBricks() {
 		if brick.Value > g.Values || brick.Value < 1 {
-			return false, VALUE_OUT_OF_BOUNDS
+			return c.violation(VALUE_OUT_OF_BOUNDS)
 		}
 	}
 
@@ -91,18 +204,290 @@ func (g *Rules) IsLegalCombination(c BrickCombination) (bool, string) {
 			jokercount++
 		}
 		if jokercount > g.JokersPerCombination {
-			return false, TOO_MANY_JOKERS_IN_COMBINATION
+			return c.violation(TOO_MANY_JOKERS_IN_COMBINATION)
 		}
 	}
 
 	// Test combination-level validity. We ignore the reasons why the combination may not be valid.
-	isRun, _ := c.IsValidRun()
-	isGroup, _ := c.IsValidGroup()
+	isRun := c.IsValidRun() == nil
+	isGroup := c.IsValidGroup() == nil
 
 	//NOTE: some combinations qualify as groups AND as sets, such as [(joker)(joker)(1, red)], depending on the JokersPerCombination setting.
 	if !(isRun || isGroup) {
-		return false, ILLEGAL_COMBINATION
+		return c.violation(ILLEGAL_COMBINATION)
+	}
+
+	// test if the jokers stand in for bricks that fit the combination.
+	return g.ResolveJokers(&c)
+}
+
+// CombinationValue returns the value of a legal combination when it is put on the table in a first move:
+// the summed value of its bricks, with the jokers valued according to FirstMoveJokerValuation.
+func (g Rules) CombinationValue(c BrickCombination) int {
+	if g.FirstMoveJokerValuation != JOKER_FIXED {
+		return g.ResolvedValue(c)
+	}
+
+	value := 0
+	for _, b := range c.getBricks() {
+		if b.Color == JokerColor {
+			value += g.FirstMoveJokerValue
+		} else {
+			value += b.Value
+		}
 	}
+	return value
+}
+
+// ResolvedValue returns the summed value of the bricks in the combination, with the jokers valued as the bricks they stand in for
+// (see ResolveJokers). Jokers that can not be resolved keep their own value.
+func (g Rules) ResolvedValue(c BrickCombination) int {
+	if err := g.ResolveJokers(&c); err != nil {
+		c.Jokers = nil
+	}
+
+	value := 0
+	for _, b := range c.Resolved() {
+		value += b.Value
+	}
+	return value
+}
+
+// ResolveJokers records the bricks the jokers in the combination stand in for (see BrickCombination.Jokers).
+// A player's annotation is kept if it is valid: each joker stands in for a brick allowed by the rules,
+// and together with the other bricks they make a valid group or run.
+// Without an annotation, the jokers are resolved deterministically. In a group they take the missing colors (in the order of Colors),
+// in a run they fill the gaps first, and then extend the run upwards (as far as Values allows) and downwards.
+// If the combination is both a group and a run, the interpretation giving the jokers the highest value is used (the group if equal).
+// Returns a *RuleViolation (INVALID_JOKER) if the annotation is invalid or the jokers can not be resolved, leaving the combination unchanged.
+func (g Rules) ResolveJokers(c *BrickCombination) error {
+	jokers := c.jokerCount()
+	if jokers == 0 && len(c.Jokers) == 0 {
+		return nil
+	}
+	if len(c.Jokers) > 0 {
+		if len(c.Jokers) != jokers || !g.isValidAnnotation(*c) {
+			return c.violation(INVALID_JOKER)
+		}
+		return nil
+	}
+
+	resolved := g.resolveJokers(*c)
+	if resolved == nil {
+		return c.violation(INVALID_JOKER)
+	}
+	c.Jokers = resolved
+	return nil
+}
+
+// isValidAnnotation checks whether the annotated jokers make the combination a valid group or run.
+func (g Rules) isValidAnnotation(c BrickCombination) bool {
+	for _, b := range c.Jokers {
+		if b.Color == JokerColor || !g.isColor(b.Color) || b.Value < 1 || b.Value > g.Values {
+			return false
+		}
+	}
+	resolved := NewBrickCombination(c.Resolved()...)
+	return resolved.IsValidGroup() == nil || resolved.IsValidRun() == nil
+}
 
-	return true, LEGAL_COMBINATION
+// isColor returns whether the color is one of the colors in play.
+func (g Rules) isColor(color string) bool {
+	for _, c := range g.Colors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveJokers returns the bricks the jokers in the combination stand in for (see ResolveJokers), or nil if they can not be resolved.
+func (g Rules) resolveJokers(c BrickCombination) []Brick {
+	var values []int
+	color := ""
+	colors := map[string]bool{}
+	jokers := 0
+	for _, b := range c.getBricks() {
+		if b.Color == JokerColor {
+			jokers++
+		} else {
+			values = append(values, b.Value)
+			color = b.Color
+			colors[b.Color] = true
+		}
+	}
+	if jokers == 0 || len(values) == 0 {
+		return nil
+	}
+	sort.Ints(values)
+
+	var best []Brick
+	bestTotal := -1
+	consider := func(candidate []Brick) {
+		if len(candidate) < jokers {
+			return
+		}
+		total := 0
+		for _, b := range candidate {
+			total += b.Value
+		}
+		if total > bestTotal {
+			best, bestTotal = candidate, total
+		}
+	}
+
+	if c.IsValidGroup() == nil {
+		group := []Brick{}
+		for _, missing := range g.Colors {
+			if !colors[missing] && len(group) < jokers {
+				group = append(group, Brick{Value: values[0], Color: missing})
+			}
+		}
+		consider(group)
+	}
+
+	if c.IsValidRun() == nil {
+		run := []Brick{}
+		present := map[int]bool{}
+		for _, v := range values {
+			present[v] = true
+		}
+		for v := values[0]; v <= values[len(values)-1] && len(run) < jokers; v++ {
+			if !present[v] {
+				run = append(run, Brick{Value: v, Color: color})
+			}
+		}
+		for v := values[len(values)-1] + 1; v <= g.Values && len(run) < jokers; v++ {
+			run = append(run, Brick{Value: v, Color: color})
+		}
+		for v := values[0] - 1; v >= 1 && len(run) < jokers; v-- {
+			run = append(run, Brick{Value: v, Color: color})
+		}
+		consider(run)
+	}
+
+	return best
+}
+
+// resolveArrangement returns a copy of the arrangement in which the jokers of each combination are resolved (see ResolveJokers).
+// Combinations that are on the table already, and that are not annotated anew, keep the representation they have on the table.
+// Jokers that can not be resolved are left as they are.
+func (g Rules) resolveArrangement(table []BrickCombination, arrangement []BrickCombination) []BrickCombination {
+	onTable := make(map[CombinationIdentity][]Brick)
+	for _, c := range table {
+		if len(c.Jokers) > 0 {
+			onTable[c.Hash()] = c.Jokers
+		}
+	}
+
+	if arrangement == nil {
+		return nil
+	}
+	resolved := make([]BrickCombination, len(arrangement))
+	for i, c := range arrangement {
+		c = c.Copy()
+		if jokers, ok := onTable[c.Hash()]; ok && len(c.Jokers) == 0 {
+			c.Jokers = append([]Brick{}, jokers...)
+		}
+		g.ResolveJokers(&c)
+		resolved[i] = c
+	}
+	return resolved
+}
+
+// PlacedValue returns the value of the bricks put on the table in a move from the table to the arrangement.
+// As the jokers may have been moved around, the new jokers are valued as the lowest valued bricks any joker in the arrangement stands in for.
+func (g Rules) PlacedValue(table []BrickCombination, arrangement []BrickCombination) int {
+	newBricks := BrickSliceDiff(DissolveCombinations(table), DissolveCombinations(arrangement))
+
+	value := 0
+	newJokers := 0
+	for _, b := range newBricks {
+		if b.Color == JokerColor {
+			newJokers++
+		} else {
+			value += b.Value
+		}
+	}
+	if newJokers == 0 {
+		return value
+	}
+
+	var jokerValues []int
+	for _, c := range arrangement {
+		if err := g.ResolveJokers(&c); err == nil {
+			for _, b := range c.Jokers {
+				jokerValues = append(jokerValues, b.Value)
+			}
+		}
+	}
+	sort.Ints(jokerValues)
+	for i := 0; i < newJokers && i < len(jokerValues); i++ {
+		value += jokerValues[i]
+	}
+	return value
+}
+
+// CheckFirstMove checks a first move, from the table to the proposed arrangement, against the first move rules.
+// Returns a *RuleViolation (TABLE_REARRANGED or VALUE_INSUFFICIENT), or nil if the move is a legal first move.
+// The combinations in the arrangement are assumed to be legal, and no bricks are assumed to be removed from the table.
+func (g Rules) CheckFirstMove(table []BrickCombination, arrangement []BrickCombination) error {
+	newBricks := BrickSliceDiff(DissolveCombinations(table), DissolveCombinations(arrangement))
+
+	value := 0
+	if g.FirstMoveHandOnly {
+		added, removed := combinationDiff(table, arrangement)
+		if len(removed) > 0 {
+			return newViolation(TABLE_REARRANGED, DissolveCombinations(removed)...)
+		}
+		for _, c := range added {
+			value += g.CombinationValue(c)
+		}
+	} else if g.FirstMoveJokerValuation == JOKER_FIXED {
+		value = g.fixedJokerValue(table, arrangement)
+	} else {
+		value = g.PlacedValue(table, arrangement)
+	}
+
+	if value < g.FirstMoveValue {
+		return newViolation(VALUE_INSUFFICIENT, newBricks...)
+	}
+	return nil
+}
+
+// fixedJokerValue returns the value of the bricks put on the table in a move from the table to the arrangement,
+// with the new jokers valued at FirstMoveJokerValue.
+func (g Rules) fixedJokerValue(table []BrickCombination, arrangement []BrickCombination) int {
+	value := 0
+	for _, b := range BrickSliceDiff(DissolveCombinations(table), DissolveCombinations(arrangement)) {
+		if b.Color == JokerColor {
+			value += g.FirstMoveJokerValue
+		} else {
+			value += b.Value
+		}
+	}
+	return value
+}
+
+// combinationDiff returns the combinations in b that are not in a, and the combinations in a that are not in b.
+// Note that it respects any duplicates.
+func combinationDiff(a []BrickCombination, b []BrickCombination) (added []BrickCombination, removed []BrickCombination) {
+	counts := make(map[CombinationIdentity]int)
+	for _, c := range a {
+		counts[c.Hash()]++
+	}
+	for _, c := range b {
+		if counts[c.Hash()] > 0 {
+			counts[c.Hash()]--
+		} else {
+			added = append(added, c)
+		}
+	}
+	for _, c := range a {
+		if counts[c.Hash()] > 0 {
+			counts[c.Hash()]--
+			removed = append(removed, c)
+		}
+	}
+	return added, removed
 }
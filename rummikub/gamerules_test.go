@@ -1,6 +1,7 @@
 package rummikub
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -15,38 +16,38 @@ func TestIsLegalCombination(t *testing.T) {
 	combinationToTest.AddBrick(Brick{Color: "yellow", Value: 1},
 		Brick{Color: "blueish", Value: 1},
 		Brick{Color: "blueish", Value: 1})
-	valid, why := gamerules.IsLegalCombination(combinationToTest)
+	err := gamerules.IsLegalCombination(combinationToTest)
 	t.Log(fmt.Sprintf("%+v\n", combinationToTest))
-	assert.Equal(t, UNKNOWN_COLOR, why, "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, UNKNOWN_COLOR)
-	assert.Equal(t, valid, false, "Brickcombination contains unknown colors, but still tagged as valid move!")
+	assert.True(t, errors.Is(err, UNKNOWN_COLOR), "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, UNKNOWN_COLOR)
+	assert.Error(t, err, "Brickcombination contains unknown colors, but still tagged as valid move!")
 
 	// contains out-of-bounds brick values
 	combinationToTest = NewBrickCombination()
 	combinationToTest.AddBrick(Brick{Color: "green", Value: 100},
 		Brick{Color: "green", Value: 100},
 		Brick{Color: "green", Value: 100})
-	valid, why = gamerules.IsLegalCombination(combinationToTest)
+	err = gamerules.IsLegalCombination(combinationToTest)
 	t.Log(fmt.Sprintf("%+v\n", combinationToTest))
-	assert.Equal(t, VALUE_OUT_OF_BOUNDS, why, "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, VALUE_OUT_OF_BOUNDS)
-	assert.Equal(t, valid, false, "Brickcombination contains out of bounds brick values, but still tagged as valid move!")
+	assert.True(t, errors.Is(err, VALUE_OUT_OF_BOUNDS), "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, VALUE_OUT_OF_BOUNDS)
+	assert.Error(t, err, "Brickcombination contains out of bounds brick values, but still tagged as valid move!")
 
 	// contains too many jokers
 	combinationToTest = NewBrickCombination()
 	combinationToTest.AddBrick(MakeJoker(), MakeJoker(), MakeJoker(), MakeJoker())
-	valid, why = gamerules.IsLegalCombination(combinationToTest)
+	err = gamerules.IsLegalCombination(combinationToTest)
 	t.Log(fmt.Sprintf("%+v\n", combinationToTest))
-	assert.Equal(t, TOO_MANY_JOKERS_IN_COMBINATION, why, "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, TOO_MANY_JOKERS_IN_COMBINATION)
-	assert.Equal(t, valid, false, "Brickcombination contains too many jokers, but still tagged as valid move!")
+	assert.True(t, errors.Is(err, TOO_MANY_JOKERS_IN_COMBINATION), "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, TOO_MANY_JOKERS_IN_COMBINATION)
+	assert.Error(t, err, "Brickcombination contains too many jokers, but still tagged as valid move!")
 
 	// is neither a valid set nor a valid row, but satisfies rest of legality constraints.
 	combinationToTest = NewBrickCombination()
 	combinationToTest.AddBrick(Brick{Color: "green", Value: 1},
 		Brick{Color: "green", Value: 1},
 		Brick{Color: "green", Value: 2})
-	valid, why = gamerules.IsLegalCombination(combinationToTest)
+	err = gamerules.IsLegalCombination(combinationToTest)
 	t.Log(fmt.Sprintf("%+v\n", combinationToTest))
-	assert.Equal(t, ILLEGAL_COMBINATION, why, "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, ILLEGAL_COMBINATION)
-	assert.Equal(t, valid, false, "Brickcombination is not a valid run or set, but still tagged as valid move!")
+	assert.True(t, errors.Is(err, ILLEGAL_COMBINATION), "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", err, ILLEGAL_COMBINATION)
+	assert.Error(t, err, "Brickcombination is not a valid run or set, but still tagged as valid move!")
 
 	// is legal run
 	combinationToTest = NewBrickCombination()
@@ -55,10 +56,9 @@ func TestIsLegalCombination(t *testing.T) {
 		Brick{Color: "blue", Value: 4},
 		Brick{Color: "red", Value: 4},
 	)
-	valid, why = gamerules.IsLegalCombination(combinationToTest)
+	err = gamerules.IsLegalCombination(combinationToTest)
 	t.Log(fmt.Sprintf("%+v\n", combinationToTest))
-	assert.Equal(t, LEGAL_COMBINATION, why, "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, LEGAL_COMBINATION)
-	assert.Equal(t, valid, true, "Brickcombination is valid but still tagged as invalid!")
+	assert.NoError(t, err, "Brickcombination is valid but still tagged as invalid!")
 
 	// is legal group
 	combinationToTest = NewBrickCombination()
@@ -67,8 +67,126 @@ func TestIsLegalCombination(t *testing.T) {
 		Brick{Color: "yellow", Value: 3},
 		Brick{Color: "yellow", Value: 4},
 	)
-	valid, why = gamerules.IsLegalCombination(combinationToTest)
+	err = gamerules.IsLegalCombination(combinationToTest)
 	t.Log(fmt.Sprintf("%+v\n", combinationToTest))
-	assert.Equal(t, LEGAL_COMBINATION, why, "%+v\n", "combination was validated/rejected for the wrong reason: \n %v... \n should be: \n %s", why, LEGAL_COMBINATION)
-	assert.Equal(t, valid, true, "Brickcombination is valid but still tagged as invalid!")
+	assert.NoError(t, err, "Brickcombination is valid but still tagged as invalid!")
+}
+
+func TestRules_Validate(t *testing.T) {
+	// the default rules should be playable by a typical number of players.
+	assert.NoError(t, NewDefaultRules().Validate(4), "default rules are rejected")
+
+	// mutate the default rules in ways that should be caught by the validation.
+	cases := []struct {
+		name     string
+		mutate   func(r *Rules)
+		nPlayers int
+		field    string
+		reason   ViolationCode
+	}{
+		{"no players", func(r *Rules) {}, 0, "players", NOT_ENOUGH_PLAYERS},
+		{"zero values", func(r *Rules) { r.Values = 0 }, 2, "values", NO_VALUES},
+		{"no colors", func(r *Rules) { r.Colors = []string{} }, 2, "colors", NO_COLORS},
+		{"empty color", func(r *Rules) { r.Colors = []string{"red", ""} }, 2, "colors", EMPTY_COLOR},
+		{"duplicate colors", func(r *Rules) { r.Colors = []string{"red", "green", "red"} }, 2, "colors", DUPLICATE_COLOR},
+		{"joker color", func(r *Rules) { r.Colors = []string{"red", JokerColor} }, 2, "colors", RESERVED_COLOR},
+		{"no replicates", func(r *Rules) { r.Replicates = 0 }, 2, "replicates", NO_REPLICATES},
+		{"negative jokers", func(r *Rules) { r.JokersInPlay = -1 }, 2, "jokers_in_play", NEGATIVE_JOKERS},
+		{"too many jokers per combination", func(r *Rules) { r.JokersPerCombination = 3 }, 2, "jokers_per_combination", JOKERS_PER_COMBINATION_EXCEED},
+		{"empty starting hand", func(r *Rules) { r.StartingHandSize = 0 }, 2, "starting_hand_size", NO_STARTING_HAND},
+		{"pile too small", func(r *Rules) {}, 8, "starting_hand_size", PILE_TOO_SMALL},
+		{"negative first move value", func(r *Rules) { r.FirstMoveValue = -1 }, 2, "first_move_value", NEGATIVE_FIRST_MOVE_VALUE},
+		{"unknown joker valuation", func(r *Rules) { r.FirstMoveJokerValuation = "whatever" }, 2, "first_move_joker_valuation", UNKNOWN_JOKER_VALUATION},
+		{"negative joker value", func(r *Rules) { r.FirstMoveJokerValue = -1 }, 2, "first_move_joker_value", NEGATIVE_FIRST_MOVE_VALUE},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rules := NewDefaultRules()
+			c.mutate(&rules)
+
+			err := rules.Validate(c.nPlayers)
+			if assert.Error(t, err, "invalid rules were not rejected") {
+				rulesErr, ok := err.(*RulesError)
+				assert.True(t, ok, "Validate did not return a *RulesError")
+				assert.Equal(t, &RulesError{c.field, c.reason}, rulesErr, "rules were rejected for the wrong reason")
+				assert.True(t, errors.Is(err, c.reason), "the error does not match its reason")
+			}
+		})
+	}
+}
+
+func TestRules_CombinationValue(t *testing.T) {
+	represented := NewDefaultRules()
+	fixed := NewDefaultRules()
+	fixed.FirstMoveJokerValuation = JOKER_FIXED
+	fixed.FirstMoveJokerValue = 30
+
+	cases := []struct {
+		name        string
+		combination BrickCombination
+		represented int
+		fixed       int
+	}{
+		{"no jokers", NewBrickCombination(Brick{Value: 4, Color: "red"}, Brick{Value: 5, Color: "red"}, Brick{Value: 6, Color: "red"}), 15, 15},
+		{"joker fills a gap", NewBrickCombination(Brick{Value: 4, Color: "red"}, MakeJoker(), Brick{Value: 6, Color: "red"}), 15, 40},
+		{"joker extends a run upwards", NewBrickCombination(Brick{Value: 4, Color: "red"}, Brick{Value: 5, Color: "red"}, MakeJoker()), 15, 39},
+		{"joker extends a run downwards", NewBrickCombination(Brick{Value: 12, Color: "red"}, Brick{Value: 13, Color: "red"}, MakeJoker()), 36, 55},
+		{"joker in a group", NewBrickCombination(Brick{Value: 9, Color: "red"}, Brick{Value: 9, Color: "blue"}, MakeJoker()), 27, 48},
+		{"joker makes a group or a run", NewBrickCombination(Brick{Value: 9, Color: "red"}, MakeJoker(), MakeJoker()), 30, 69},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.represented, represented.CombinationValue(c.combination))
+			assert.Equal(t, c.fixed, fixed.CombinationValue(c.combination))
+		})
+	}
+}
+
+func TestRules_ResolveJokers(t *testing.T) {
+	gamerules := NewDefaultRules()
+	gamerules.JokersPerCombination = 2
+
+	cases := []struct {
+		name        string
+		combination BrickCombination
+		jokers      []Brick
+	}{
+		{"gap in a run", NewBrickCombination(Brick{Value: 11, Color: "red"}, MakeJoker(), Brick{Value: 13, Color: "red"}), []Brick{{Value: 12, Color: "red"}}},
+		{"end of a run", NewBrickCombination(Brick{Value: 12, Color: "red"}, Brick{Value: 13, Color: "red"}, MakeJoker()), []Brick{{Value: 11, Color: "red"}}},
+		{"missing colors of a group", NewBrickCombination(MakeJoker(), Brick{Value: 7, Color: "red"}, Brick{Value: 7, Color: "yellow"}, MakeJoker()), []Brick{{Value: 7, Color: "green"}, {Value: 7, Color: "blue"}}},
+		{"run worth more than a group", NewBrickCombination(Brick{Value: 9, Color: "red"}, MakeJoker(), MakeJoker()), []Brick{{Value: 10, Color: "red"}, {Value: 11, Color: "red"}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.NoError(t, gamerules.ResolveJokers(&c.combination))
+			assert.ElementsMatch(t, c.jokers, c.combination.Jokers)
+		})
+	}
+
+	// an annotated joker is kept if it fits the combination.
+	group := NewBrickCombination(Brick{Value: 9, Color: "red"}, MakeJoker(), MakeJoker())
+	group.Jokers = []Brick{{Value: 9, Color: "blue"}, {Value: 9, Color: "green"}}
+	assert.NoError(t, gamerules.ResolveJokers(&group))
+	assert.Equal(t, []Brick{{Value: 9, Color: "blue"}, {Value: 9, Color: "green"}}, group.Jokers)
+	assert.Equal(t, 27, gamerules.ResolvedValue(group))
+	assert.NoError(t, gamerules.IsLegalCombination(group))
+
+	// an annotated joker that does not fit is rejected.
+	group.Jokers = []Brick{{Value: 9, Color: "red"}, {Value: 9, Color: "green"}}
+	err := gamerules.ResolveJokers(&group)
+	assert.True(t, errors.Is(err, INVALID_JOKER), "annotation was accepted/rejected for the wrong reason: %v", err)
+	assert.True(t, errors.Is(gamerules.IsLegalCombination(group), INVALID_JOKER))
+	group.Jokers = []Brick{{Value: 9, Color: "blue"}}
+	assert.True(t, errors.Is(gamerules.ResolveJokers(&group), INVALID_JOKER), "an annotation should cover all jokers")
+
+	// a joker that can not extend a complete run.
+	full := NewBrickCombination()
+	for v := 1; v <= gamerules.Values; v++ {
+		full.AddBrick(Brick{Value: v, Color: "blue"})
+	}
+	full.AddBrick(MakeJoker())
+	assert.True(t, errors.Is(gamerules.IsLegalCombination(full), INVALID_JOKER))
 }
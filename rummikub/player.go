@@ -1,5 +1,10 @@
 package rummikub
 
+import (
+	"context"
+	"time"
+)
+
 // The Player struct contains the state of the player during the game.
 type Player struct {
 	// may be an empty slice.
@@ -7,9 +12,18 @@ type Player struct {
 	Name        string    `json:"name"`
 	Human       bool      `json:"human"`
 
+	// whether the player has left the game before it was won.
+	Resigned bool `json:"resigned"`
+
+	// the number of hints the player has requested.
+	HintsUsed int `json:"hints_used"`
+
 	//Can be equipped with different solvers.
 	// TODO neater handling of serialization (solver state currently not serialized)
-	solver Solver `json:"-"`
+	solver ContextSolver `json:"-"`
+
+	// the time the solver is given to come up with a move. DefaultSolveBudget if zero.
+	solveBudget time.Duration `json:"-"`
 }
 
 type Move struct {
@@ -22,12 +36,13 @@ type Solver interface {
 }
 
 // NewAIPlayer returns a new AI player given a name and a search space struct which it will use as a solver.
+// Solvers that are not ContextSolvers are wrapped using AdaptSolver.
 func NewAIPlayer(name string, solver Solver) Player {
 
 	return Player{
 		Name:        name,
 		HandHistory: [][]Brick{},
-		solver:      solver,
+		solver:      AdaptSolver(solver),
 		Human:       false,
 	}
 }
@@ -39,7 +54,7 @@ func NewHumanPlayer(name string) Player {
 	return Player{
 		Name:        name,
 		HandHistory: [][]Brick{},
-		solver:      &DummySolver{},
+		solver:      AdaptSolver(&DummySolver{}),
 		Human:       true,
 	}
 }
@@ -78,40 +93,120 @@ func (p *Player) getName() string {
 	return p.Name
 }
 
-// MakeMove is the AI player's decision making logic.
-// Given the combinations on the table and an optionally nonzero minimum aggregate stone value threshold,
-// it will construct a move.
-func (p *Player) MakeMove(table []BrickCombination, minValue int) Move {
-
-	// check if there is any constraint in place on the aggregate minimum value of the stones put on the table.
-	maxValue := false
-	if minValue > 0 {
-		maxValue = true
+// SetSolveBudget sets the time the AI player's solver is given to come up with a move.
+func (p *Player) SetSolveBudget(budget time.Duration) {
+	p.solveBudget = budget
+}
+
+func (p *Player) getSolveBudget() time.Duration {
+	if p.solveBudget <= 0 {
+		return DefaultSolveBudget
 	}
+	return p.solveBudget
+}
+
+// MakeMove is the AI player's decision making logic.
+// Given the combinations on the table, the game rules and whether it is the player's first move, it will construct a move.
+// On a first move, the most valuable move that satisfies the first move rules is made (see SolveFirstMove).
+// If the solver fails or runs out of time, its fallback move is made (at worst a forfeit, see ContextSolver).
+// If the solver's arrangement fails verification (see VerifySolution), the case is logged (see LogInvalidSolution) and the player forfeits.
+func (p *Player) MakeMove(table []BrickCombination, rules Rules, firstMove bool) Move {
+	move, _ := p.makeMove(context.Background(), table, rules, firstMove)
+	return move
+}
+
+// makeMove is MakeMove, giving up when the context is done. The move is always safe to make, even if an error is returned.
+// The error is the solver's error, the context's error, or an *InvalidSolution.
+func (p *Player) makeMove(ctx context.Context, table []BrickCombination, rules Rules, firstMove bool) (Move, error) {
 
 	// Solve the rummikub problem given the hand and the table.
-	// Note that the selection between MaxBrick and MaxVal is made based on the firstMove argument.
-	proposedTable, _, solveError := p.solver.Solve(p.Hand(), table, maxValue)
+	// On the first move, the value of the bricks put on the table is maximized, subject to the first move rules.
+	// Otherwise, the number of bricks put on the table is maximized.
+	var result SolveResult
+	var solveError error
+	if firstMove {
+		result, solveError = p.solveFirstMove(ctx, table, rules)
+	} else {
+		result, solveError = p.solver.SolveContext(ctx, p.getSolveBudget(), p.Hand(), table, false)
+	}
 
-	// TODO: better error handling
-	if solveError != nil {
-		panic(solveError)
+	// fall back to a forfeit if the solver did not come up with any arrangement.
+	if result.Arrangement == nil {
+		return NewMove(p.Name, table), solveError
+	}
+
+	// or if its arrangement can not be played, logging the position so that it can be reproduced.
+	if invalid := verifyResult(rules, p.Hand(), table, firstMove, firstMove, result); invalid != nil {
+		LogInvalidSolution(invalid)
+		return NewMove(p.Name, table), invalid
 	}
 
 	// build a new move object from the proposed table configuration.
-	candidateMove := NewMove(p.Name, proposedTable)
+	candidateMove := NewMove(p.Name, result.Arrangement)
+
+	// check if any stones are going to be put on the table.
+	if len(BrickSliceDiff(DissolveCombinations(table), candidateMove.Bricks())) > 0 {
+		return candidateMove, solveError
+	}
 
-	// get the stones that are currently on the table
-	tableStones := DissolveCombinations(table)
+	// if not: return a forfeiting move (propose an unchanged table).
+	return NewMove(p.Name, table), solveError
 
-	// check if the stones that are going to be put on the table satisfy the minimum value constraint.
-	if len(BrickSliceDiff(tableStones, candidateMove.Bricks())) > minValue {
-		return candidateMove
+}
+
+// solveFirstMove runs SolveFirstMove within the solve budget, using the Solver behind the player's ContextSolver.
+// ContextSolvers that do not wrap a Solver are run as usual, and their arrangement is only kept if it satisfies the first move rules.
+func (p *Player) solveFirstMove(ctx context.Context, table []BrickCombination, rules Rules) (SolveResult, error) {
+	hand := p.Hand()
+
+	var solver Solver
+	switch s := p.solver.(type) {
+	case *solverAdapter:
+		solver = s.solver
+	case Solver:
+		solver = s
+	default:
+		result, err := p.solver.SolveContext(ctx, p.getSolveBudget(), hand, table, true)
+		if len(result.BricksToPut) == 0 || rules.CheckFirstMove(table, result.Arrangement) != nil {
+			return forfeitResult(hand, table, true), err
+		}
+		return result, err
 	}
 
-	// if the minValue constraint is not satisfied: return a forfeiting move (propose an unchanged table).
-	return NewMove(p.Name, table)
+	return solveWithin(ctx, p.getSolveBudget(), solveSlotOf(p.solver), forfeitResult(hand, table, true), func(func(SolveResult)) (SolveResult, error) {
+		arrangement, bricks, err := SolveFirstMove(solver, rules, hand, table, true)
+		return optimalResult(arrangement, bricks, true), err
+	})
+}
 
+// AIMoveRequest contains everything an AI player needs to come up with its move: a copy of its hand, the table,
+// the game rules and whether it is the player's first move. See GameState.NextAIMove.
+type AIMoveRequest struct {
+	player    Player
+	table     []BrickCombination
+	rules     Rules
+	firstMove bool
+}
+
+// PlayerName returns the name of the AI player whose move is requested.
+func (r *AIMoveRequest) PlayerName() string {
+	return r.player.Name
+}
+
+// Table returns the table the move is requested for.
+func (r *AIMoveRequest) Table() []BrickCombination {
+	return r.table
+}
+
+// Solve runs the AI player's decision making logic, producing its move.
+// Returns the context's error if it is done before the solver has finished.
+// Solver errors are not returned: the player then falls back to the solver's fallback move (see MakeMove).
+func (r *AIMoveRequest) Solve(ctx context.Context) (Move, error) {
+	move, _ := r.player.makeMove(ctx, r.table, r.rules, r.firstMove)
+	if ctx.Err() != nil {
+		return Move{}, ctx.Err()
+	}
+	return move, nil
 }
 
 func (move *Move) Bricks() []Brick {
@@ -1,131 +1,134 @@
 package rummikub
 
 import (
-	"bytes"
 	"fmt"
-	"io/ioutil"
+	"math"
+	"runtime"
+	"time"
 
 	"gitlab.com/jjhbarkeywolf/ilp"
 )
 
 // TODO: vendor dependencies, especially my own
-// TODO: make these functions adaptive to changing game rules (primarily the amount of jokers that are allowed per combination).
 
-// The ILPSolver struct contains a validated (de-duplicated, legal, etc.) set of BrickCombinations.
+// The ILPSolver struct solves turns using a shared CombinationSpace: a validated (de-duplicated, legal, etc.) set of BrickCombinations.
 type ILPSolver struct {
-	combinations []BrickCombination
-	uniqueBricks []Brick
+	// the combinations the solver chooses from. Shared with all solvers for the same rules (see NewCombinationSpace).
+	*CombinationSpace
 
 	// store the Rules struct this solver is based upon.
 	rules Rules
 
-	// To facilitate quick lookup using the Contains method.
-	combinationHashes map[CombinationIdentity]bool
+	// the options used for each call to Solve.
+	options SolverOptions
 
-	// some stats about the search solver.
-	totalRuns   int
-	totalGroups int
-	groupSizes  map[int]int // how many sets of a certain length
-	runSizes    map[int]int // how many rows of a certain length
-}
+	// the arrangement of the previous call to Solve, used as the incumbent of the next if the solver has a cache.
+	previous previousTurn
 
-// return the size of the ILPSolver instance
-func (searchSpace *ILPSolver) Size() (int, int, int) {
-	return len(searchSpace.uniqueBricks), searchSpace.totalRuns, searchSpace.totalGroups
+	// held by the solve started by SolveContext, if it is still running.
+	slot solveSlot
 }
 
-// AllCombinations returns a copy of the combinations in the ILPSolver struct
-func (searchSpace *ILPSolver) AllCombinations() []BrickCombination {
-	//(slices are reference types).
-	return searchSpace.combinations
+// SolverOptions configure how an ILPSolver runs the branch-and-bound procedure.
+type SolverOptions struct {
+	// the number of workers exploring the branch-and-bound tree concurrently. Defaults to runtime.NumCPU() if not positive.
+	Workers int
+
+	// Instrumentation, if set, receives the branch-and-bound tree explored by each call to Solve (e.g. to render it using ToDOT).
+	// It is called before Solve returns, also when the solver fails.
+	Instrumentation func(tree *ilp.TreeLogger)
+
+	// DisablePresolve, if set, adds every combination in the space to the model, rather than only the combinations
+	// that can be made from the bricks in the hand and on the table. The solutions are the same, only slower to find.
+	DisablePresolve bool
+
+	// Cache, if set, holds the turns solved by Solve, to be shared with other solvers (see SolutionCache).
+	// Turns that are not in the cache are solved with the best arrangement known beforehand as the incumbent:
+	// the solver's previous arrangement if it can still be made, or else the greedy arrangement (see ILPSolver.SolveWithStats).
+	Cache *SolutionCache
 }
 
-// getBricks returns a copy of the uniqueBricks that the ILPSolver is based on.
-func (searchSpace *ILPSolver) Bricks() []Brick {
-	//(slices are reference types).
-	return searchSpace.uniqueBricks
+// DefaultSolverOptions returns the options used by NewILPSolver: one worker per CPU and no instrumentation.
+func DefaultSolverOptions() SolverOptions {
+	return SolverOptions{Workers: runtime.NumCPU()}
 }
 
-// addCombinations adds combinations to the ILPSolver object after checking their validity, simultaneously incrementing counters / lookup maps.
-func (searchSpace *ILPSolver) addCombinations(combinations []BrickCombination) {
-	for _, combo := range combinations {
-		h := combo.Hash()
-		if _, ok := searchSpace.combinationHashes[h]; ok {
-			continue //ignore this combination
-		} else {
-			searchSpace.combinations = append(searchSpace.combinations, combo)
-			searchSpace.combinationHashes[h] = true
-
-			// validate the combinations and update the tallies
-			isRun, _ := combo.IsValidRun()
-			isGroup, _ := combo.IsValidGroup()
-			if isRun {
-				searchSpace.totalRuns++
-				searchSpace.runSizes[len(combo.getBricks())]++
-			} else if isGroup {
-				searchSpace.totalGroups++
-				searchSpace.groupSizes[len(combo.getBricks())]++
-			} else {
-				panic(fmt.Sprintf("invalid combination (not a valid run nor a valid group) supplied to ILPSolver: \n %v", combo))
-			}
-		}
+// workers returns the number of workers to use, applying the default.
+func (o SolverOptions) workers() int {
+	if o.Workers <= 0 {
+		return runtime.NumCPU()
 	}
+	return o.Workers
 }
 
-// Contains checks if a certain BrickCombination is present in the ILPSolver.
-func (searchSpace *ILPSolver) Contains(combo BrickCombination) bool {
-	// check if a combination is present in the search solver
+// SolveStats describe a single call to the solver.
+type SolveStats struct {
+	// the number of nodes of the branch-and-bound tree that were explored.
+	Nodes int
+
+	// the objective value of the returned arrangement, and the best known upper bound on the optimal objective value.
+	// As the solver runs to completion, the bound it ends with is the objective value; RootBound is the bound it started from,
+	// that of the LP relaxation of the turn (see ILPSolver.relaxationBound).
+	Objective float64
+	Bound     float64
+	RootBound float64
+
+	// the wall-clock time the solver ran, and the number of workers it used.
+	Duration time.Duration
+	Workers  int
+
+	// whether the solution was found in the cache (see SolverOptions.Cache), in which case the other statistics are those of the original run,
+	// apart from the duration of the lookup. Otherwise, the objective value of the incumbent the solver started with (0 if none).
+	Cached    bool
+	Incumbent float64
+}
 
-	h := combo.Hash()
-	_, ok := searchSpace.combinationHashes[h]
-	return ok
+// Validate checks that the solver's combination space is consistent with the rules the solver was built for:
+// the bricks are exactly the distinct bricks in play, and each combination is legal, made from those bricks,
+// and can be on the table at least once given the number of copies of each brick.
+// Returns ErrInconsistentSearchSpace if it is not.
+func (searchSpace *ILPSolver) Validate() error {
+	return searchSpace.CombinationSpace.validate(searchSpace.rules)
 }
 
-// NewILPSolver maps all legal combinations that can be made given a set of getBricks and a Rules object that contains the game rules and returns a ILPSolver.
+// NewILPSolver returns an ILPSolver for the game rules, using the combination space of all legal combinations that can be made given the rules.
+// The combination space is only built once for each set of rules (see NewCombinationSpace), making solvers cheap to create.
 func NewILPSolver(gameRules Rules) *ILPSolver {
+	return NewILPSolverWithOptions(gameRules, DefaultSolverOptions())
+}
 
-	brickSet := gameRules.BaseBricks()
-
-	// compute all possible BrickCombinations using the game rules and the available base uniqueBricks.
-	runs := ComputeAllRuns(brickSet)
-	groups := ComputeAllGroups(brickSet)
-	saltyGroups := saltWithJokers(groups, gameRules.JokersPerCombination)
-	saltyRuns := saltWithJokers(runs, gameRules.JokersPerCombination)
-
-	// build a search solver object
-	space := &ILPSolver{
-		combinationHashes: make(map[CombinationIdentity]bool),
-		groupSizes:        make(map[int]int),
-		runSizes:          make(map[int]int),
-		rules:             gameRules,
+// NewILPSolverWithOptions is NewILPSolver, with the options used to run the solver.
+// It panics if the search space turns out to be inconsistent with the rules (see Validate), which rules that pass Rules.Validate never cause.
+func NewILPSolverWithOptions(gameRules Rules, options SolverOptions) *ILPSolver {
+	space, err := NewCombinationSpace(gameRules)
+	if err != nil {
+		panic(err)
 	}
 
-	// save all unique uniqueBricks used to build the search solver with.
-	//Include ONE joker (jokers are considered non-unique) if the play set includes jokers.
-	space.uniqueBricks = brickSet
-	if gameRules.JokersInPlay > 0 {
-		space.uniqueBricks = append(space.uniqueBricks, MakeJoker())
+	return &ILPSolver{
+		CombinationSpace: space,
+		rules:            gameRules,
+		options:          options,
+		slot:             newSolveSlot(),
 	}
-
-	// add the combinations to the search solver.
-	space.addCombinations(saltyGroups)
-	space.addCombinations(saltyRuns)
-	space.addCombinations(groups)
-	space.addCombinations(runs)
-
-	return space
 }
 
 // ComputeAllRuns retrieves all possible runs that can be made given a set of getBricks
 func ComputeAllRuns(brickSet []Brick) []BrickCombination {
 	perColor := map[string][]Brick{}
 
+	// keep the colors in the order of the brick set, so that the runs (and the indices of the combinations in the search space) are the same each time.
+	colors := []string{}
 	for _, x := range brickSet {
+		if _, ok := perColor[x.Color]; !ok {
+			colors = append(colors, x.Color)
+		}
 		perColor[x.Color] = append(perColor[x.Color], x)
 	}
 
 	runs := []BrickCombination{}
-	for _, v := range perColor {
+	for _, color := range colors {
+		v := perColor[color]
 		for _, runsize := range []int{3, 4, 5} {
 			for i := 0; i <= (len(v) - runsize); i++ {
 				run := NewBrickCombination()
@@ -145,27 +148,39 @@ func ComputeAllRuns(brickSet []Brick) []BrickCombination {
 func ComputeAllGroups(brickSet []Brick) []BrickCombination {
 	perValue := map[int][]Brick{}
 
+	// keep the values in the order of the brick set, so that the groups (and the indices of the combinations in the search space) are the same each time.
+	values := []int{}
 	for _, x := range brickSet {
+		if _, ok := perValue[x.Value]; !ok {
+			values = append(values, x.Value)
+		}
 		perValue[x.Value] = append(perValue[x.Value], x)
 	}
 
 	groups := []BrickCombination{}
 
-	// add groups of size 4
-	for _, v := range perValue {
+	// add the groups of all colors
+	for _, value := range values {
+		v := perValue[value]
+		if len(v) < 3 {
+			continue
+		}
 		grp := NewBrickCombination(v...)
 		// for _, b := range v {grp.AddBrick(b)}
 		// grp.AddBrick(v...)
 		groups = append(groups, grp)
 	}
 
-	// add groups of size 3
-	for _, v := range perValue {
-		rawCombinations := combinationsWithoutReplacement(v, 3)
-		for _, c := range rawCombinations {
-			grp := NewBrickCombination(c...)
-			// grp.AddBrick(c...)
-			groups = append(groups, grp)
+	// add the smaller groups, from size 3 up (a group of four can not be split up, so with more colors more sizes are needed).
+	for _, value := range values {
+		v := perValue[value]
+		for size := 3; size < len(v); size++ {
+			rawCombinations := combinationsWithoutReplacement(v, size)
+			for _, c := range rawCombinations {
+				grp := NewBrickCombination(c...)
+				// grp.AddBrick(c...)
+				groups = append(groups, grp)
+			}
 		}
 	}
 
@@ -199,7 +214,12 @@ func combinationsWithoutReplacement(brickSet []Brick, k int) [][]Brick {
 }
 
 // saltWithJokers generates new combinations with each stone replaced by a joker, given a set of combinations.
+// Without any jokers per combination, the combinations are returned as they are.
 func saltWithJokers(combinations []BrickCombination, nJokersPerCombination int) []BrickCombination {
+	if nJokersPerCombination <= 0 {
+		return combinations
+	}
+
 	// generate the combinations that would occur if each stone was replaced with a joker.
 	for _, c := range combinations {
 		combinations = append(combinations, saltCombination(c)...)
@@ -245,10 +265,251 @@ func countBrickOccurrence(set []Brick, b Brick) int {
 // The combinations that constitute the proposed new arrangement of the table are also returned.
 // NOTE that the problem is always feasible (not returning any bricks i.e. y = 0 is always possible)
 func (searchSpace *ILPSolver) Solve(hand []Brick, table []BrickCombination, maxValue bool) ([]BrickCombination, []Brick, error) {
+	combinationsToPut, bricksToPut, _, err := searchSpace.SolveWithStats(hand, table, maxValue)
+	return combinationsToPut, bricksToPut, err
+}
+
+// SolveWithStats is Solve, additionally returning statistics about the solver run.
+// If the solver has a cache (see SolverOptions.Cache), the turn is looked up in it first, and added to it once solved.
+func (searchSpace *ILPSolver) SolveWithStats(hand []Brick, table []BrickCombination, maxValue bool) ([]BrickCombination, []Brick, SolveStats, error) {
+	return searchSpace.solveWithStats(hand, table, maxValue, nil)
+}
+
+// solveWithStats is SolveWithStats. If improve is set (see solveWithin), it is passed what is found on the way to the optimal arrangement:
+// the bound of the LP relaxation, and the best arrangement that leaves the table as it is.
+func (searchSpace *ILPSolver) solveWithStats(hand []Brick, table []BrickCombination, maxValue bool, improve func(SolveResult)) ([]BrickCombination, []Brick, SolveStats, error) {
+	cache := searchSpace.options.Cache
+	var key string
+	if cache != nil {
+		start := time.Now()
+		key = solutionKey(searchSpace.rules, hand, table, maxValue)
+		if hit, ok := cache.get(key); ok {
+			searchSpace.previous.set(hit.arrangement)
+			hit.stats.Cached = true
+			hit.stats.Duration = time.Since(start)
+			return hit.arrangement, hit.bricks, hit.stats, nil
+		}
+	}
+
+	// the LP relaxation bounds the objective value of any arrangement. If it can not be solved, the bound is putting the entire hand on the table.
+	rootBound, err := searchSpace.relaxationBound(hand, table, maxValue)
+	if err != nil {
+		rootBound = objective(hand, maxValue)
+	}
+	if improve != nil {
+		improve(SolveResult{Bound: rootBound})
+		if kept, err := searchSpace.tableKeptResult(hand, table, maxValue); err == nil {
+			improve(kept)
+		}
+	}
+
+	m := searchSpace.buildModel(hand, table, maxValue)
+
+	// start from the best arrangement known beforehand, cutting off the arrangements that do not improve on it.
+	var incumbent float64
+	if cache != nil {
+		var warm bool
+		incumbent, warm = searchSpace.incumbentValue(hand, table, maxValue)
+		if incumbent > 0 {
+			m.addIncumbentCutoff(searchSpace, incumbent, maxValue)
+		}
+		if warm {
+			cache.recordWarmStart()
+		}
+	}
+
+	solution, stats, err := searchSpace.runModel(m)
+	stats.Incumbent = incumbent
+	if err != nil {
+		return nil, nil, stats, err
+	}
+
+	combinationsToPut, bricksToPut := searchSpace.decode(solution)
+
+	// the branch-and-bound procedure ran to completion, which proves the solution optimal: the bound it ends with is its objective value.
+	stats.Objective = objective(bricksToPut, maxValue)
+	stats.Bound = stats.Objective
+	stats.RootBound = rootBound
+
+	if cache != nil {
+		searchSpace.previous.set(combinationsToPut)
+		cache.put(cachedSolution{key: key, arrangement: combinationsToPut, bricks: bricksToPut, stats: stats})
+	}
+	return combinationsToPut, bricksToPut, stats, nil
+
+}
+
+// relaxationBound solves the LP relaxation of the turn, and returns its objective value rounded down:
+// as the objective coefficients are integer, no arrangement has a higher objective value.
+func (searchSpace *ILPSolver) relaxationBound(hand []Brick, table []BrickCombination, maxValue bool) (float64, error) {
+	m := searchSpace.buildModelOf(hand, table, maxValue, true)
+	m.prob.SetWorkers(searchSpace.options.workers())
+	soln, err := m.prob.Solve()
+	if err != nil {
+		return 0, err
+	}
+
+	var value float64
+	for _, v := range m.variables {
+		if v.coeff == 0 {
+			continue
+		}
+		x, err := soln.GetValueFor(v.name)
+		if err != nil {
+			return 0, err
+		}
+		value += v.coeff * x
+	}
+	// allow for the rounding errors of the simplex method.
+	return math.Floor(value + 1e-6), nil
+}
+
+// tableKeptResult returns the best arrangement that leaves the table as it is, only adding the combinations made from the hand to it.
+// Its model is far smaller than that of the turn, so it is found well before the optimal arrangement.
+func (searchSpace *ILPSolver) tableKeptResult(hand []Brick, table []BrickCombination, maxValue bool) (SolveResult, error) {
+	m := searchSpace.buildModel(hand, nil, maxValue)
+	m.prob.SetWorkers(searchSpace.options.workers())
+	soln, err := m.prob.Solve()
+	if err != nil {
+		return SolveResult{}, err
+	}
+
+	combinations, bricks := searchSpace.decode(m.solutionOf(soln))
+	return SolveResult{
+		Arrangement: append(append([]BrickCombination{}, table...), combinations...),
+		BricksToPut: bricks,
+		Objective:   objective(bricks, maxValue),
+	}, nil
+}
+
+// model is the ILP model of a single turn, as built by buildModel.
+type model struct {
+	prob *ilp.Problem
+
+	// the x variables (one per combination in the model) and the y variables (one per brick in the model),
+	// along with the index in the search space of the combination or brick each of them stands for.
+	comboVars  []*ilp.Variable
+	comboNames []string
+	comboIndex []int
+	brickVars  []*ilp.Variable
+	brickNames []string
+	brickIndex []int
+
+	// the upper bounds of the x variables.
+	comboBounds []int
+
+	// the number of combinations and bricks in the search space.
+	combinations int
+	bricks       int
+
+	// the number of no-good cuts added to the model, used to name their auxiliary variables.
+	cuts int
+
+	// whether the model is the LP relaxation of the turn, in which no variable needs to be integer.
+	relaxed bool
+
+	// the variables and constraints added to the problem so far, in order, to write the model out (see WriteLP and WriteMPS).
+	variables     []modelVariable
+	variableIndex map[*ilp.Variable]int
+	constraints   []*modelConstraint
+}
+
+// modelVariable is a variable of the model, as added by addVariable.
+type modelVariable struct {
+	name         string
+	coeff        float64 // in the objective function.
+	integer      bool
+	lower, upper float64
+}
+
+// modelConstraint is an equality constraint of the model, as added by addConstraint.
+// It is built like an ilp.Constraint, to which it passes on its expressions.
+type modelConstraint struct {
+	m          *model
+	constraint *ilp.Constraint
 
+	name  string
+	terms []modelTerm
+	rhs   float64
+}
+
+// modelTerm is a variable (by index in the model) times its coefficient.
+type modelTerm struct {
+	coeff    float64
+	variable int
+}
+
+// addVariable adds a variable to the problem, bounded by lower and upper, with the coefficient in the objective function.
+// In the LP relaxation of the turn, integer is ignored.
+func (m *model) addVariable(name string, coeff float64, integer bool, lower float64, upper float64) *ilp.Variable {
+	v := m.prob.AddVariable(name).SetCoeff(coeff)
+	integer = integer && !m.relaxed
+	if integer {
+		v = v.IsInteger()
+	}
+	v = v.LowerBound(lower).UpperBound(upper)
+
+	m.variableIndex[v] = len(m.variables)
+	m.variables = append(m.variables, modelVariable{name, coeff, integer, lower, upper})
+	return v
+}
+
+// addConstraint adds an (empty) constraint to the problem, named after what it constrains.
+func (m *model) addConstraint(name string) *modelConstraint {
+	c := &modelConstraint{m: m, constraint: m.prob.AddConstraint(), name: name}
+	m.constraints = append(m.constraints, c)
+	return c
+}
+
+// AddExpression adds the variable times the coefficient to the left hand side of the constraint.
+// The variable must have been added using addVariable.
+func (c *modelConstraint) AddExpression(coeff float64, v *ilp.Variable) *modelConstraint {
+	c.constraint.AddExpression(coeff, v)
+	c.terms = append(c.terms, modelTerm{coeff, c.m.variableIndex[v]})
+	return c
+}
+
+// EqualTo sets the right hand side of the constraint.
+func (c *modelConstraint) EqualTo(rhs float64) *modelConstraint {
+	c.constraint.EqualTo(rhs)
+	c.rhs = rhs
+	return c
+}
+
+// modelSolution holds the number of times each combination and each brick (by index in the search space) is put on the table.
+type modelSolution struct {
+	combinations []int
+	bricks       []int
+}
+
+// buildModel builds the ILP model of the turn.
+// Only the combinations that can be made from the bricks in the hand and on the table are part of it (see CombinationSpace.presolve),
+// and only the bricks that are in either, unless SolverOptions.DisablePresolve is set.
+func (searchSpace *ILPSolver) buildModel(hand []Brick, table []BrickCombination, maxValue bool) *model {
+	return searchSpace.buildModelOf(hand, table, maxValue, false)
+}
+
+// buildModelOf builds the ILP model of the turn, or its LP relaxation (in which the variables need not be integer) if relaxed is set.
+func (searchSpace *ILPSolver) buildModelOf(hand []Brick, table []BrickCombination, maxValue bool, relaxed bool) *model {
 	allBricks := searchSpace.uniqueBricks
-	allCombinations := searchSpace.combinations
-	tableStones := DissolveCombinations(table)
+	handCounts := searchSpace.brickCounts(hand)
+	tableCounts := searchSpace.brickCounts(DissolveCombinations(table))
+
+	// the bricks available to make combinations from: those in the hand and those on the table.
+	available := make([]int, len(allBricks))
+	for i := range available {
+		available[i] = handCounts[i] + tableCounts[i]
+	}
+
+	var combinations, bounds []int
+	if searchSpace.options.DisablePresolve {
+		for j := range searchSpace.combinations {
+			combinations = append(combinations, j)
+		}
+		bounds = searchSpace.combinationBounds
+	} else {
+		combinations, bounds = searchSpace.presolve(available)
+	}
 
 	// initiate a new problem
 	prob := ilp.NewProblem()
@@ -256,27 +517,33 @@ func (searchSpace *ILPSolver) Solve(hand []Brick, table []BrickCombination, maxV
 	// set it to maximize the objective function
 	prob.Maximize()
 
-	// add the x variables (the brick combinations) and their bounds, storing their references.
-	comboVars := make(map[CombinationIdentity]*ilp.Variable)
-	varNameToCombo := make(map[string]*BrickCombination)
-
-	for i, combi := range allCombinations {
-		name := fmt.Sprintf("combi_%v", i)
-		comboVar := prob.AddVariable(name).
-			SetCoeff(0).
-			IsInteger().
-			LowerBound(0).
-			UpperBound(2)
+	m := &model{prob: prob, comboIndex: combinations, comboBounds: bounds, combinations: len(searchSpace.combinations), bricks: len(allBricks),
+		relaxed: relaxed, variableIndex: make(map[*ilp.Variable]int)}
 
-		comboVars[combi.Hash()] = comboVar
-		varNameToCombo[name] = &allCombinations[i]
+	// add the x variables (the brick combinations) and their bounds, storing their references.
+	// a combination can be on the table as many times as the bricks in play (and in the hand and on the table) allow.
+	// varIndex maps the index of a combination in the search space to its variable in the model.
+	varIndex := make([]int, len(searchSpace.combinations))
+	for j := range varIndex {
+		varIndex[j] = -1
+	}
+	for k, j := range combinations {
+		name := fmt.Sprintf("combi_%v", j)
+		comboVar := m.addVariable(name, 0, true, 0, float64(bounds[k]))
 
+		m.comboVars = append(m.comboVars, comboVar)
+		m.comboNames = append(m.comboNames, name)
+		varIndex[j] = k
 	}
 
 	// add the Y variables; one for each brick
-	varNameToBrick := make(map[string]*Brick)
 	for i, bri := range allBricks {
 
+		// bricks that are neither in the hand nor on the table can not be put on the table, and no combination in the model contains them.
+		if available[i] == 0 && !searchSpace.options.DisablePresolve {
+			continue
+		}
+
 		// decide on the coefficient of yi in the objective function
 		// if not overridden, the coefficient of each variable y in the objective function is 1; all bricks have the same value.
 		var yiCoef float64 = 1
@@ -286,103 +553,98 @@ func (searchSpace *ILPSolver) Solve(hand []Brick, table []BrickCombination, maxV
 		}
 
 		// create the variable struct
+		// //CONSTRAINT 1 the hand (aka rack) constraint
+		// Specifies that the brick to put on the table must first be in the player's hand
+		// NOTE that we do this by setting the variable's upper bound. This is more efficient in light of the presolve procedure.
 		name := fmt.Sprintf("%s_%v", bri.Color, bri.Value)
-		yi := prob.AddVariable(name).
-			SetCoeff(yiCoef).
-			IsInteger().
-			LowerBound(0).
-			UpperBound(2)
+		yi := m.addVariable(name, yiCoef, true, 0, float64(handCounts[i]))
 
 		// save it to the name-brick mapping
-		varNameToBrick[name] = &allBricks[i]
-
-		// //CONSTRAINT 1 the hand (aka rack) constraint
-		// Specifies that the brick to put on the table must first be in the player's hand
-		// NOTE that we do this by overriding the variable's upper bound. This is more efficient in light of the presolve procedure.
-		ri := countBrickOccurrence(hand, bri)
-		yi.UpperBound(float64(ri))
+		m.brickVars = append(m.brickVars, yi)
+		m.brickNames = append(m.brickNames, name)
+		m.brickIndex = append(m.brickIndex, i)
 
 		// //CONSTRAINT 2 the "tiles must be on rack or on table" constraint
 		// (sum(sij * xj) = ti + yi) rewritten as (sum(sij * xj) - yi = ti).
-		// count the number of times this brick occurs on the table
-		t := countBrickOccurrence(tableStones, bri)
-
-		// build the constraint
-		constraintTwo := prob.AddConstraint().
+		constraintTwo := m.addConstraint("bricks_"+name).
 			AddExpression(-1, yi).
-			EqualTo(float64(t))
-
-		// add an expression for each combination xj that includes brick yi
-		for _, combi := range allCombinations {
-
-			// how many times does this combination contain this type of brick?
-			Sij := float64(countBrickOccurrence(combi.getBricks(), bri))
-
-			xj := comboVars[combi.Hash()]
-			constraintTwo.AddExpression(Sij, xj)
+			EqualTo(float64(tableCounts[i]))
 
+		// add an expression for each combination xj in the model that includes brick yi, as many times as it does (sij).
+		for _, inc := range searchSpace.combinationsByBrick[i] {
+			if k := varIndex[inc.index]; k >= 0 {
+				constraintTwo.AddExpression(float64(inc.count), m.comboVars[k])
+			}
 		}
 
 	}
 
-	// TODO: set the number of workers to the number of CPUs
-	prob.SetWorkers(4)
+	return m
+}
 
-	//TODO: REMOVEME add tree logger instrumentation
-	tl := ilp.NewTreeLogger()
-	prob.SetInstrumentation(tl)
+// runModel solves the model using the solver options.
+func (searchSpace *ILPSolver) runModel(m *model) (modelSolution, SolveStats, error) {
+	start := time.Now()
+	stats := SolveStats{Workers: searchSpace.options.workers()}
+
+	m.prob.SetWorkers(stats.Workers)
 
-	// TODO: REMOVEME dump the debug log to a file regardless of whether the solver returns
-	defer dumpToDot(tl)
+	// log the branch-and-bound tree, to count its nodes and pass it on to the instrumentation (if any).
+	tl := ilp.NewTreeLogger()
+	m.prob.SetInstrumentation(tl)
 
 	// run the solver
-	soln, err := prob.Solve()
+	soln, err := m.prob.Solve()
+	stats.Duration = time.Since(start)
+	stats.Nodes = tl.Nodes()
+	if searchSpace.options.Instrumentation != nil {
+		searchSpace.options.Instrumentation(tl)
+	}
 	if err != nil {
-		return nil, nil, err
+		return modelSolution{}, stats, err
 	}
 
-	// get the coefficients for each combination
-	var combinationsToPut []BrickCombination
-	for name, combi := range varNameToCombo {
-
-		cTimesToPut, err := soln.GetValueFor(name)
-		if err != nil {
-			// This should never happen as it would indicate a problem with the ILP lib and thus never fail silently.
-			panic(err)
-		}
-
-		for cput := 0; cput < int(cTimesToPut); cput++ {
-			combinationsToPut = append(combinationsToPut, *combi)
-		}
+	return m.solutionOf(soln), stats, nil
+}
 
+// solutionOf gets the coefficients for each combination and each brick from the solution of the model, in a fixed order.
+// Combinations and bricks that are not part of the model are never put on the table.
+func (m *model) solutionOf(soln *ilp.Solution) modelSolution {
+	solution := modelSolution{combinations: make([]int, m.combinations), bricks: make([]int, m.bricks)}
+	for k, name := range m.comboNames {
+		solution.combinations[m.comboIndex[k]] = valueFor(soln, name)
 	}
-
-	// get the coefficients for each brick
-	var bricksToPut []Brick
-	for name, brick := range varNameToBrick {
-
-		cTimesToPut, err := soln.GetValueFor(name)
-		if err != nil {
-			// This should never happen as it would indicate a problem with the ILP lib and thus never fail silently.
-			panic(err)
-		}
-
-		for cput := 0; cput < int(cTimesToPut); cput++ {
-			bricksToPut = append(bricksToPut, *brick)
-		}
-
+	for k, name := range m.brickNames {
+		solution.bricks[m.brickIndex[k]] = valueFor(soln, name)
 	}
-
-	return combinationsToPut, bricksToPut, nil
-
+	return solution
 }
 
-// TODO: REMOVEME
-func dumpToDot(tree *ilp.TreeLogger) {
-	var buffer bytes.Buffer
-	tree.ToDOT(&buffer)
-	err := ioutil.WriteFile("__debugviz__.dot", buffer.Bytes(), 0644)
+// valueFor returns the value of an integer variable in the solution.
+func valueFor(soln *ilp.Solution, name string) int {
+	v, err := soln.GetValueFor(name)
 	if err != nil {
+		// This should never happen as it would indicate a problem with the ILP lib and thus never fail silently.
 		panic(err)
 	}
+	return int(v)
+}
+
+// decode returns the combinations and the bricks put on the table in the solution, in search space order.
+func (searchSpace *ILPSolver) decode(solution modelSolution) ([]BrickCombination, []Brick) {
+	// the combinations are copied, as the combination space is shared.
+	var combinationsToPut []BrickCombination
+	for i, n := range solution.combinations {
+		for cput := 0; cput < n; cput++ {
+			combinationsToPut = append(combinationsToPut, searchSpace.combinations[i].Copy())
+		}
+	}
+
+	var bricksToPut []Brick
+	for i, n := range solution.bricks {
+		for cput := 0; cput < n; cput++ {
+			bricksToPut = append(bricksToPut, searchSpace.uniqueBricks[i])
+		}
+	}
+	return combinationsToPut, bricksToPut
 }
@@ -0,0 +1,211 @@
+package rummikub
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSolutionCacheSize is the number of turns a SolutionCache holds if no size is given.
+const DefaultSolutionCacheSize = 1024
+
+// SolutionCache is a bounded cache of solved turns, to be shared by solvers (see SolverOptions.Cache).
+// Turns are keyed by the multisets of bricks in the hand and on the table, the objective, and the rules that determine the combination space:
+// how the bricks on the table are arranged does not matter to the solution. Once full, the least recently used turn is evicted.
+// It is safe for concurrent use.
+type SolutionCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // of *cachedSolution, the most recently used first.
+	stats   CacheStats
+}
+
+// cachedSolution is a solved turn, as returned by ILPSolver.SolveWithStats.
+type cachedSolution struct {
+	key         string
+	arrangement []BrickCombination
+	bricks      []Brick
+	stats       SolveStats
+}
+
+// CacheStats describe the use of a SolutionCache.
+type CacheStats struct {
+	// the number of turns that were found in the cache, and the number that had to be solved.
+	Hits   int
+	Misses int
+
+	// the number of turns evicted from the cache to make room for others.
+	Evictions int
+
+	// the number of misses that were solved with the previous turn's arrangement as the incumbent (see SolverOptions.Cache).
+	WarmStarts int
+
+	// the time it took to solve the turns that were found in the cache, when they were first solved.
+	Saved time.Duration
+}
+
+// HitRate returns the fraction of the turns that were found in the cache, or 0 if none were looked up.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// NewSolutionCache returns an empty cache holding at most size turns, or DefaultSolutionCacheSize if size is not positive.
+func NewSolutionCache(size int) *SolutionCache {
+	if size <= 0 {
+		size = DefaultSolutionCacheSize
+	}
+	return &SolutionCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Len returns the number of turns in the cache.
+func (cache *SolutionCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.order.Len()
+}
+
+// Stats returns the statistics of the cache so far.
+func (cache *SolutionCache) Stats() CacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.stats
+}
+
+// get looks up the turn, counting a hit or a miss. The solution is copied, as it is shared with other solvers.
+func (cache *SolutionCache) get(key string) (cachedSolution, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		cache.stats.Misses++
+		return cachedSolution{}, false
+	}
+	cache.stats.Hits++
+	cache.order.MoveToFront(element)
+
+	entry := element.Value.(*cachedSolution)
+	cache.stats.Saved += entry.stats.Duration
+	return entry.copy(), true
+}
+
+// put adds the solved turn to the cache, evicting the least recently used turn if it is full.
+func (cache *SolutionCache) put(entry cachedSolution) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[entry.key]; ok {
+		// solved by another solver in the meantime.
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	stored := entry.copy()
+	cache.entries[entry.key] = cache.order.PushFront(&stored)
+	for cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cachedSolution).key)
+		cache.stats.Evictions++
+	}
+}
+
+// recordWarmStart counts a miss that was solved with the previous turn's arrangement as the incumbent.
+func (cache *SolutionCache) recordWarmStart() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.stats.WarmStarts++
+}
+
+func (entry cachedSolution) copy() cachedSolution {
+	arrangement := make([]BrickCombination, len(entry.arrangement))
+	for i, c := range entry.arrangement {
+		arrangement[i] = c.Copy()
+	}
+	entry.arrangement = arrangement
+	entry.bricks = append([]Brick{}, entry.bricks...)
+	return entry
+}
+
+// solutionKey returns the canonical key of the turn: the rules that determine the combination space (see combinationSpaceKey),
+// the objective and the multisets of bricks in the hand and on the table.
+func solutionKey(rules Rules, hand []Brick, table []BrickCombination, maxValue bool) string {
+	handBricks := NewBrickCombination(hand...)
+	tableBricks := NewBrickCombination(DissolveCombinations(table)...)
+	return fmt.Sprintf("%v|%v|%q|%q", combinationSpaceKey(rules), maxValue, handBricks.Hash(), tableBricks.Hash())
+}
+
+// previousTurn is the arrangement the solver came up with last, used as the incumbent of its next turn.
+type previousTurn struct {
+	mu          sync.Mutex
+	arrangement []BrickCombination
+}
+
+func (p *previousTurn) get() []BrickCombination {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.arrangement
+}
+
+// set stores a copy of the arrangement, as the caller of Solve is free to change it.
+func (p *previousTurn) set(arrangement []BrickCombination) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.arrangement = make([]BrickCombination, len(arrangement))
+	for i, c := range arrangement {
+		p.arrangement[i] = c.Copy()
+	}
+}
+
+// incumbentValue returns the objective value of the best arrangement of this turn that is known without solving it:
+// the previous turn's arrangement, if it can still be made (all bricks on the table are in it, and the others are in the hand),
+// or else the greedy arrangement that adds combinations made from the hand alone to the table (see greedyResult).
+// Also returns whether the previous turn's arrangement was the better one.
+func (searchSpace *ILPSolver) incumbentValue(hand []Brick, table []BrickCombination, maxValue bool) (float64, bool) {
+	value := searchSpace.greedyResult(hand, table, maxValue).Objective
+
+	previous := searchSpace.previous.get()
+	if previous == nil {
+		return value, false
+	}
+	arrangementBricks := DissolveCombinations(previous)
+	tableBricks := DissolveCombinations(table)
+	if len(BrickSliceDiff(arrangementBricks, tableBricks)) > 0 {
+		return value, false
+	}
+	placed := BrickSliceDiff(tableBricks, arrangementBricks)
+	if len(BrickSliceDiff(hand, placed)) > 0 {
+		return value, false
+	}
+	if v := objective(placed, maxValue); v > value {
+		return v, true
+	}
+	return value, false
+}
+
+// addIncumbentCutoff demands that the objective value is at least that of an arrangement that is known to be feasible,
+// cutting off the parts of the branch-and-bound tree that can not improve on it:
+//
+//	sum(ci * yi) - s = v
+//
+// for the objective coefficients ci, the incumbent value v and a nonnegative surplus variable s.
+func (m *model) addIncumbentCutoff(searchSpace *ILPSolver, value float64, maxValue bool) {
+	cutoff := m.addConstraint("incumbent")
+	maxTotal := 0.0
+	for k, i := range m.brickIndex {
+		ci := 1.0
+		if maxValue {
+			ci = float64(searchSpace.uniqueBricks[i].Value)
+		}
+		cutoff.AddExpression(ci, m.brickVars[k])
+		maxTotal += ci * float64(searchSpace.copies(searchSpace.uniqueBricks[i]))
+	}
+	surplus := m.addVariable("incumbent_surplus", 0, false, 0, maxTotal)
+	cutoff.AddExpression(-1, surplus).EqualTo(value)
+}
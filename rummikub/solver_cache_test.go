@@ -0,0 +1,94 @@
+package rummikub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSolutionCache(t *testing.T) {
+	cache := NewSolutionCache(2)
+	rules := NewDefaultRules()
+	hand := []Brick{{Value: 4, Color: "red"}, {Value: 5, Color: "blue"}}
+	table := []BrickCombination{NewBrickCombination(Brick{Value: 1, Color: "red"}, Brick{Value: 2, Color: "red"}, Brick{Value: 3, Color: "red"})}
+
+	// the key does not depend on the order of the bricks, nor on how they are arranged on the table.
+	key := solutionKey(rules, hand, table, false)
+	assert.Equal(t, key, solutionKey(rules, []Brick{hand[1], hand[0]}, []BrickCombination{NewBrickCombination(table[0].Bricks[2], table[0].Bricks[0], table[0].Bricks[1])}, false))
+	assert.NotEqual(t, key, solutionKey(rules, hand, table, true))
+	assert.NotEqual(t, key, solutionKey(rules, hand, nil, false))
+	other := NewDefaultRules()
+	other.Replicates = 3
+	assert.NotEqual(t, key, solutionKey(other, hand, table, false))
+
+	_, ok := cache.get(key)
+	assert.False(t, ok)
+	cache.put(cachedSolution{key: key, arrangement: table, bricks: hand, stats: SolveStats{Duration: time.Second}})
+	hit, ok := cache.get(key)
+	assert.True(t, ok)
+	assert.Equal(t, table, hit.arrangement)
+
+	// the cached solution is a copy.
+	hit.arrangement[0].Bricks[0] = Brick{Value: 13, Color: "green"}
+	hit, _ = cache.get(key)
+	assert.Equal(t, table, hit.arrangement)
+
+	// once full, the least recently used turn is evicted.
+	cache.put(cachedSolution{key: "b"})
+	cache.get(key)
+	cache.put(cachedSolution{key: "c"})
+	assert.Equal(t, 2, cache.Len())
+	_, ok = cache.get("b")
+	assert.False(t, ok, "the least recently used turn was not evicted")
+	_, ok = cache.get(key)
+	assert.True(t, ok, "the most recently used turn was evicted")
+
+	stats := cache.Stats()
+	assert.Equal(t, 4, stats.Hits)
+	assert.Equal(t, 2, stats.Misses)
+	assert.Equal(t, 1, stats.Evictions)
+	assert.Equal(t, 4*time.Second, stats.Saved)
+	assert.Equal(t, 4.0/6, stats.HitRate())
+	assert.Equal(t, 0.0, CacheStats{}.HitRate())
+}
+
+func TestSolver_Cache(t *testing.T) {
+	rules := NewDefaultRules()
+	options := DefaultSolverOptions()
+	options.Cache = NewSolutionCache(0)
+	solver := NewILPSolverWithOptions(rules, options)
+
+	table := []BrickCombination{NewBrickCombination(Brick{Value: 1, Color: "red"}, Brick{Value: 2, Color: "red"}, Brick{Value: 3, Color: "red"})}
+	hand := []Brick{{Value: 4, Color: "red"}, {Value: 5, Color: "blue"}}
+
+	arrangement, bricksToPut, stats, err := solver.SolveWithStats(hand, table, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []Brick{{Value: 4, Color: "red"}}, bricksToPut)
+	assert.False(t, stats.Cached)
+
+	// the same turn is found in the cache, also by other solvers and with the table arranged differently.
+	other := NewILPSolverWithOptions(rules, options)
+	reordered := []BrickCombination{NewBrickCombination(table[0].Bricks[2], table[0].Bricks[1], table[0].Bricks[0])}
+	cachedArrangement, cachedBricks, cachedStats, err := other.SolveWithStats([]Brick{hand[1], hand[0]}, reordered, false)
+	assert.NoError(t, err)
+	assert.True(t, cachedStats.Cached)
+	assert.Equal(t, arrangement, cachedArrangement)
+	assert.Equal(t, bricksToPut, cachedBricks)
+	assert.Equal(t, stats.Objective, cachedStats.Objective)
+	assert.Equal(t, 1, options.Cache.Stats().Hits)
+
+	// the next turn, the previous arrangement can still be made, and is a better incumbent than the greedy arrangement (a forfeit).
+	hand = append(hand, Brick{Value: 9, Color: "yellow"})
+	_, bricksToPut, stats, err = solver.SolveWithStats(hand, table, false)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 1)
+	assert.False(t, stats.Cached)
+	assert.Equal(t, 1.0, stats.Incumbent)
+	assert.Equal(t, 1, options.Cache.Stats().WarmStarts)
+
+	// solvers without a cache do not start from an incumbent.
+	_, _, stats, err = NewILPSolver(rules).SolveWithStats(hand, table, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, stats.Incumbent)
+}
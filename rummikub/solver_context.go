@@ -0,0 +1,244 @@
+package rummikub
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSolveBudget is the time an AI player is given to come up with its move, unless set otherwise (see Player.SetSolveBudget).
+const DefaultSolveBudget = 10 * time.Second
+
+// SolveResult is the best arrangement of the table found by a ContextSolver.
+type SolveResult struct {
+	// the proposed arrangement of the table, and the bricks from the hand that are put on the table in it.
+	Arrangement []BrickCombination
+	BricksToPut []Brick
+
+	// the value of the objective function (the number of bricks put on the table, or their summed value) for this arrangement.
+	Objective float64
+
+	// an upper bound on the objective value of the optimal arrangement. Equal to Objective if the arrangement is optimal.
+	Bound float64
+
+	// statistics about the solver run. Only set by solvers that report them (see ILPSolver.SolveWithStats), and only if they finished.
+	Stats SolveStats
+
+	// the name of the engine that came up with the arrangement. Only set by the PortfolioSolver, and empty if none did (a forfeit).
+	Engine string
+}
+
+// Gap returns the relative optimality gap of the result: 0 if it is optimal, at most 1 otherwise.
+func (r SolveResult) Gap() float64 {
+	if r.Bound <= 0 || r.Objective >= r.Bound {
+		return 0
+	}
+	return (r.Bound - r.Objective) / r.Bound
+}
+
+// ContextSolver is version 2 of the Solver interface. It gives up when the context is done or the time budget (if positive) has run out,
+// and then returns the best arrangement it has found so far (the incumbent) along with the tightest bound it has proven.
+// The returned arrangement can always be played: at worst it is the unchanged table (a forfeit).
+// The error is the solver's error, or the context's error if the context was done before the solver finished.
+// Running out of budget is not an error.
+type ContextSolver interface {
+	SolveContext(ctx context.Context, budget time.Duration, hand []Brick, table []BrickCombination, maximizeValue bool) (SolveResult, error)
+}
+
+// AdaptSolver wraps a Solver into a ContextSolver.
+// As the wrapped Solver can not be interrupted, it is left to finish in the background when the context is done or the budget runs out,
+// and its result is discarded in favour of the incumbent: the greedy arrangement if the Solver has a combination space
+// (see CombinationSpace.greedyResult), or else a forfeit. Until it has finished, later calls wait for it (see solveSlot).
+// Solvers that already are ContextSolvers are returned as-is.
+func AdaptSolver(s Solver) ContextSolver {
+	if cs, ok := s.(ContextSolver); ok {
+		return cs
+	}
+	return &solverAdapter{s, newSolveSlot()}
+}
+
+type solverAdapter struct {
+	solver Solver
+	slot   solveSlot
+}
+
+// greedySolver is a Solver that can build a greedy incumbent, as solvers that embed a CombinationSpace can.
+type greedySolver interface {
+	greedyResult(hand []Brick, table []BrickCombination, maximizeValue bool) SolveResult
+}
+
+func (a *solverAdapter) SolveContext(ctx context.Context, budget time.Duration, hand []Brick, table []BrickCombination, maximizeValue bool) (SolveResult, error) {
+	incumbent := forfeitResult(hand, table, maximizeValue)
+	if g, ok := a.solver.(greedySolver); ok {
+		incumbent = g.greedyResult(hand, table, maximizeValue)
+	}
+	return solveWithin(ctx, budget, a.slot, incumbent, func(func(SolveResult)) (SolveResult, error) {
+		arrangement, bricks, err := a.solver.Solve(hand, table, maximizeValue)
+		return optimalResult(arrangement, bricks, maximizeValue), err
+	})
+}
+
+// SolveContext runs Solve within the time budget. If it does not finish in time, the best arrangement found so far is returned instead,
+// along with the bound of the LP relaxation of the turn (once it has been solved): the solver starts from the greedy arrangement,
+// and improves on it with the best arrangement that only adds combinations made from the hand to the table before solving the turn.
+// The branch-and-bound procedure can not be interrupted: it finishes in the background, and later calls wait for it (see solveSlot).
+func (searchSpace *ILPSolver) SolveContext(ctx context.Context, budget time.Duration, hand []Brick, table []BrickCombination, maximizeValue bool) (SolveResult, error) {
+	incumbent := searchSpace.greedyResult(hand, table, maximizeValue)
+	return solveWithin(ctx, budget, searchSpace.slot, incumbent, func(improve func(SolveResult)) (SolveResult, error) {
+		arrangement, bricks, stats, err := searchSpace.solveWithStats(hand, table, maximizeValue, improve)
+		result := optimalResult(arrangement, bricks, maximizeValue)
+		result.Stats = stats
+		return result, err
+	})
+}
+
+// solveSlot is held by the solve that is running on a solver, if any. Solves that are given up on keep running in the background,
+// as the solvers can not be interrupted; the slot makes sure that at most one of them runs per solver, rather than piling up.
+type solveSlot chan struct{}
+
+func newSolveSlot() solveSlot {
+	return make(solveSlot, 1)
+}
+
+// solveSlotOf returns the slot of the solver, or nil if it has none.
+func solveSlotOf(solver interface{}) solveSlot {
+	switch s := solver.(type) {
+	case *ILPSolver:
+		return s.slot
+	case *solverAdapter:
+		return s.slot
+	}
+	return nil
+}
+
+// solveWithin runs solve in the background, and returns its result if it finishes before the context is done and the budget has run out.
+// Otherwise (or if solve fails) the best result found so far is returned: the incumbent, unless solve has improved on it on the way.
+// solve reports its progress by calling improve with a better arrangement, or with a result without one (a nil Arrangement)
+// to only tighten the bound; the best arrangement is always kept along with the tightest bound.
+// If a slot is given, solve only starts once the slot is free; the incumbent is returned if it does not free up in time.
+func solveWithin(ctx context.Context, budget time.Duration, slot solveSlot, incumbent SolveResult,
+	solve func(improve func(SolveResult)) (SolveResult, error)) (SolveResult, error) {
+
+	budgetCtx := ctx
+	if budget > 0 {
+		var cancel context.CancelFunc
+		budgetCtx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	// the solve can not be interrupted once started, so it is not started in vain.
+	if budgetCtx.Err() != nil {
+		return incumbent, ctx.Err()
+	}
+	if slot != nil {
+		select {
+		case slot <- struct{}{}:
+		case <-budgetCtx.Done():
+			return incumbent, ctx.Err()
+		}
+	}
+
+	var mu sync.Mutex
+	best := incumbent
+	improve := func(r SolveResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Arrangement == nil:
+			if r.Bound < best.Bound {
+				best.Bound = r.Bound
+			}
+		case r.Objective > best.Objective:
+			best.Arrangement, best.BricksToPut, best.Objective = r.Arrangement, r.BricksToPut, r.Objective
+		}
+		// the bound can not be below the objective value of an arrangement that has been found.
+		if best.Bound < best.Objective {
+			best.Bound = best.Objective
+		}
+	}
+	bestSoFar := func() SolveResult {
+		mu.Lock()
+		defer mu.Unlock()
+		return best
+	}
+
+	type outcome struct {
+		result SolveResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		if slot != nil {
+			defer func() { <-slot }()
+		}
+		result, err := solve(improve)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		if o.err != nil {
+			return bestSoFar(), o.err
+		}
+		return o.result, nil
+	case <-budgetCtx.Done():
+		// only the parent context being done is an error; running out of budget is not.
+		return bestSoFar(), ctx.Err()
+	}
+}
+
+// objective returns the value of the objective function for the bricks put on the table.
+func objective(bricks []Brick, maximizeValue bool) float64 {
+	if !maximizeValue {
+		return float64(len(bricks))
+	}
+	total := 0
+	for _, b := range bricks {
+		total += b.Value
+	}
+	return float64(total)
+}
+
+// optimalResult wraps the arrangement found by an exact solver.
+func optimalResult(arrangement []BrickCombination, bricks []Brick, maximizeValue bool) SolveResult {
+	value := objective(bricks, maximizeValue)
+	return SolveResult{Arrangement: arrangement, BricksToPut: bricks, Objective: value, Bound: value}
+}
+
+// forfeitResult is the trivial incumbent: the unchanged table. Its bound is putting the entire hand on the table.
+func forfeitResult(hand []Brick, table []BrickCombination, maximizeValue bool) SolveResult {
+	return SolveResult{
+		Arrangement: append([]BrickCombination{}, table...),
+		BricksToPut: []Brick{},
+		Bound:       objective(hand, maximizeValue),
+	}
+}
+
+// greedyResult builds an incumbent by adding the combinations that can be made from the hand alone to the table,
+// the most valuable ones first. Cheap to compute, and always legal (apart from the first move threshold).
+func (space *CombinationSpace) greedyResult(hand []Brick, table []BrickCombination, maximizeValue bool) SolveResult {
+	result := forfeitResult(hand, table, maximizeValue)
+
+	candidates := []BrickCombination{}
+	for _, c := range space.combinations {
+		if len(BrickSliceDiff(hand, c.getBricks())) == 0 {
+			candidates = append(candidates, c)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return objective(candidates[i].getBricks(), maximizeValue) > objective(candidates[j].getBricks(), maximizeValue)
+	})
+
+	remaining := append([]Brick{}, hand...)
+	for _, c := range candidates {
+		if len(BrickSliceDiff(remaining, c.getBricks())) > 0 {
+			continue
+		}
+		remaining = BrickSliceDiff(c.getBricks(), remaining)
+		result.Arrangement = append(result.Arrangement, c.Copy())
+		result.BricksToPut = append(result.BricksToPut, c.getBricks()...)
+	}
+	result.Objective = objective(result.BricksToPut, maximizeValue)
+	return result
+}
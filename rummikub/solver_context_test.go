@@ -0,0 +1,238 @@
+package rummikub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowSolver plays all of its hand as a single run, after a delay.
+type slowSolver struct {
+	delay time.Duration
+}
+
+func (s *slowSolver) Solve(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	time.Sleep(s.delay)
+	return append(table, NewBrickCombination(hand...)), hand, nil
+}
+
+// failingSolver always fails.
+type failingSolver struct{}
+
+func (s *failingSolver) Solve(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	return nil, nil, errors.New("solver failure")
+}
+
+var (
+	contextTestTable = []BrickCombination{
+		NewBrickCombination(Brick{Value: 5, Color: "red"}, Brick{Value: 5, Color: "blue"}, Brick{Value: 5, Color: "green"}),
+	}
+	contextTestHand = []Brick{{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}}
+)
+
+func TestAdaptSolver_Finished(t *testing.T) {
+	solver := AdaptSolver(&slowSolver{})
+	result, err := solver.SolveContext(context.Background(), time.Second, contextTestHand, contextTestTable, true)
+	assert.NoError(t, err)
+	assert.Len(t, result.Arrangement, 2)
+	assert.Equal(t, 6.0, result.Objective)
+	assert.Equal(t, 0.0, result.Gap(), "the result of a finished solver is optimal")
+}
+
+func TestAdaptSolver_BudgetExceeded(t *testing.T) {
+	solver := AdaptSolver(&slowSolver{delay: time.Second})
+
+	start := time.Now()
+	result, err := solver.SolveContext(context.Background(), 10*time.Millisecond, contextTestHand, contextTestTable, false)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "solver did not respect its budget")
+
+	// running out of budget is not an error: the incumbent (a forfeit) is returned instead.
+	assert.NoError(t, err)
+	assert.Equal(t, contextTestTable, result.Arrangement)
+	assert.Empty(t, result.BricksToPut)
+	assert.Equal(t, 3.0, result.Bound)
+	assert.Equal(t, 1.0, result.Gap())
+}
+
+// slowSpaceSolver is a slowSolver with a combination space, from which it can build a greedy incumbent.
+type slowSpaceSolver struct {
+	*CombinationSpace
+	slowSolver
+}
+
+func TestAdaptSolver_BudgetExceeded_GreedyIncumbent(t *testing.T) {
+	space, err := NewCombinationSpace(NewDefaultRules())
+	assert.NoError(t, err)
+	solver := AdaptSolver(&slowSpaceSolver{space, slowSolver{delay: time.Second}})
+	hand := append([]Brick{{Value: 9, Color: "blue"}}, contextTestHand...)
+
+	// rather than forfeiting, the greedy arrangement is returned.
+	result, err := solver.SolveContext(context.Background(), 10*time.Millisecond, hand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Len(t, result.Arrangement, 2)
+	assert.ElementsMatch(t, contextTestHand, result.BricksToPut)
+	assert.Equal(t, 3.0, result.Objective)
+	assert.Equal(t, 4.0, result.Bound)
+}
+
+func TestSolveWithin_BestSoFar(t *testing.T) {
+	incumbent := forfeitResult(contextTestHand, contextTestTable, false)
+	run := NewBrickCombination(contextTestHand[:3]...)
+	improved := SolveResult{Arrangement: append([]BrickCombination{run}, contextTestTable...), BricksToPut: contextTestHand, Objective: 3}
+
+	release := make(chan struct{})
+	defer close(release)
+	result, err := solveWithin(context.Background(), 50*time.Millisecond, nil, incumbent, func(improve func(SolveResult)) (SolveResult, error) {
+		improve(SolveResult{Bound: 5})
+		improve(improved)
+		// a worse arrangement or a looser bound do not replace what was found before.
+		improve(incumbent)
+		improve(SolveResult{Bound: 8})
+		<-release
+		return SolveResult{}, nil
+	})
+
+	// the solve runs out of budget: the best arrangement found so far is returned, along with the tightest bound.
+	assert.NoError(t, err)
+	assert.Equal(t, improved.Arrangement, result.Arrangement)
+	assert.Equal(t, 3.0, result.Objective)
+	assert.Equal(t, 3.0, result.Bound, "the bound was not tightened to the objective value")
+}
+
+func TestILPSolver_SolveContext_Progress(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	hand := append([]Brick{{Value: 5, Color: "yellow"}, {Value: 9, Color: "blue"}}, contextTestHand...)
+
+	var found []SolveResult
+	_, bricks, stats, err := solver.solveWithStats(hand, contextTestTable, false, func(r SolveResult) { found = append(found, r) })
+	assert.NoError(t, err)
+	assert.Len(t, bricks, 4)
+
+	// the LP relaxation bounds the optimum, but can not put the blue 9 on the table.
+	if assert.Len(t, found, 2) {
+		assert.Nil(t, found[0].Arrangement)
+		assert.Equal(t, 4.0, found[0].Bound)
+
+		// the best arrangement that keeps the table only adds the run in the hand to it.
+		assert.Len(t, found[1].Arrangement, 2)
+		assert.Equal(t, 3.0, found[1].Objective)
+		assert.NoError(t, VerifySolution(solver.rules, hand, contextTestTable, found[1].Arrangement, found[1].BricksToPut))
+	}
+	assert.Equal(t, 4.0, stats.Objective)
+	assert.Equal(t, 4.0, stats.RootBound)
+}
+
+// countingSolver is a slowSolver that records the largest number of its solves that ran at the same time.
+type countingSolver struct {
+	slowSolver
+	mu            sync.Mutex
+	running, peak int
+}
+
+func (s *countingSolver) Solve(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	s.mu.Lock()
+	s.running++
+	if s.running > s.peak {
+		s.peak = s.running
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+	}()
+	return s.slowSolver.Solve(hand, table, maximizeValue)
+}
+
+func TestAdaptSolver_OneSolveAtATime(t *testing.T) {
+	counter := &countingSolver{slowSolver: slowSolver{delay: 200 * time.Millisecond}}
+	solver := AdaptSolver(counter)
+
+	// the solves that run out of budget keep running in the background, but later calls do not start another one meanwhile.
+	for i := 0; i < 5; i++ {
+		result, err := solver.SolveContext(context.Background(), 10*time.Millisecond, contextTestHand, contextTestTable, false)
+		assert.NoError(t, err)
+		assert.Equal(t, contextTestTable, result.Arrangement)
+	}
+
+	// once the background solve has finished, the next call runs the solver again.
+	result, err := solver.SolveContext(context.Background(), time.Second, contextTestHand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, result.Objective)
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	assert.Equal(t, 1, counter.peak, "solves piled up on the solver")
+}
+
+func TestAdaptSolver_Cancelled(t *testing.T) {
+	solver := AdaptSolver(&slowSolver{delay: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := solver.SolveContext(ctx, 0, contextTestHand, contextTestTable, false)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, contextTestTable, result.Arrangement, "no safe incumbent returned")
+}
+
+func TestAdaptSolver_Failure(t *testing.T) {
+	solver := AdaptSolver(&failingSolver{})
+	result, err := solver.SolveContext(context.Background(), time.Second, contextTestHand, contextTestTable, false)
+	assert.Error(t, err)
+	assert.Equal(t, contextTestTable, result.Arrangement, "no safe incumbent returned")
+}
+
+func TestAdaptSolver_ContextSolver(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	assert.Equal(t, solver, AdaptSolver(solver), "ContextSolvers should not be wrapped")
+}
+
+func TestPlayer_MakeMove_SolverFailure(t *testing.T) {
+	player := NewAIPlayer("AI", &failingSolver{})
+	player.SetHand(contextTestHand)
+
+	// a failing solver must not crash the game: the player forfeits instead.
+	var move Move
+	assert.NotPanics(t, func() { move = player.MakeMove(contextTestTable, NewDefaultRules(), false) })
+	assert.Equal(t, NewMove("AI", contextTestTable), move)
+}
+
+func TestPlayer_MakeMove_PlaysIncumbent(t *testing.T) {
+	player := NewAIPlayer("AI", &slowSolver{delay: time.Second})
+	player.SetHand(contextTestHand)
+	player.SetSolveBudget(10 * time.Millisecond)
+
+	move := player.MakeMove(contextTestTable, NewDefaultRules(), false)
+	assert.Equal(t, NewMove("AI", contextTestTable), move)
+}
+
+func TestILPSolver_SolveContext_GreedyIncumbent(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	hand := append([]Brick{{Value: 9, Color: "blue"}}, contextTestHand...)
+
+	incumbent := solver.greedyResult(hand, contextTestTable, true)
+	assert.Len(t, incumbent.Arrangement, 2, "the run in the hand was not added to the table")
+	assert.ElementsMatch(t, contextTestHand, incumbent.BricksToPut)
+	assert.Equal(t, 6.0, incumbent.Objective)
+	assert.Equal(t, 15.0, incumbent.Bound)
+	assert.InDelta(t, 0.6, incumbent.Gap(), 1e-9)
+
+	// the incumbent is a legal arrangement.
+	for _, c := range incumbent.Arrangement {
+		assert.NoError(t, solver.rules.IsLegalCombination(c))
+	}
+}
+
+func TestILPSolver_SolveContext_Optimal(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	hand := append([]Brick{{Value: 5, Color: "yellow"}}, contextTestHand...)
+
+	result, err := solver.SolveContext(context.Background(), time.Minute, hand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 4.0, result.Objective, "the solver did not play all bricks")
+	assert.Equal(t, 0.0, result.Gap())
+}
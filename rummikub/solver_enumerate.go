@@ -0,0 +1,138 @@
+package rummikub
+
+import (
+	"fmt"
+	"sort"
+)
+
+// EnumerateOptions configure ILPSolver.Enumerate.
+type EnumerateOptions struct {
+	// the maximum number of arrangements to return. If not positive, all arrangements within the tolerance are returned.
+	Limit int
+
+	// arrangements whose objective value is at most Tolerance below the optimum are returned as well. Zero for optimal arrangements only.
+	Tolerance float64
+}
+
+// Enumerate returns distinct arrangements of the table, best first: the optimal ones, and those within the tolerance of the optimum.
+// Two arrangements are distinct if they differ in the number of times any combination is used.
+// Arrangements with the same objective value are ordered by the combinations they use (in search space order),
+// so the result does not depend on the order in which the solver finds them.
+// Note that if the limit cuts off arrangements with the same objective value, which of them are returned depends on the solver.
+//
+// Each arrangement after the first is found by solving the model again, with a no-good cut excluding each of the arrangements found before.
+// The enumeration stops once the limit has been reached, or once the next best arrangement falls outside of the tolerance
+// (or no arrangement is left, which the solver reports as an error). The result holds at least the optimal arrangement,
+// unless an error is returned.
+func (searchSpace *ILPSolver) Enumerate(hand []Brick, table []BrickCombination, maxValue bool, options EnumerateOptions) ([]SolveResult, error) {
+	type found struct {
+		solution modelSolution
+		result   SolveResult
+	}
+
+	var all []found
+	var optimum float64
+	for options.Limit <= 0 || len(all) < options.Limit {
+		m := searchSpace.buildModel(hand, table, maxValue)
+		for _, f := range all {
+			m.addNoGoodCut(f.solution)
+		}
+
+		solution, stats, err := searchSpace.runModel(m)
+		if err != nil {
+			if len(all) == 0 {
+				return nil, err
+			}
+			// all arrangements have been cut off.
+			break
+		}
+
+		combinationsToPut, bricksToPut := searchSpace.decode(solution)
+		result := optimalResult(combinationsToPut, bricksToPut, maxValue)
+		if len(all) == 0 {
+			optimum = result.Objective
+		}
+		if result.Objective < optimum-options.Tolerance-1e-9 {
+			break
+		}
+
+		result.Bound = optimum
+		stats.Objective, stats.Bound = result.Objective, optimum
+		result.Stats = stats
+		all = append(all, found{solution, result})
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].result.Objective != all[j].result.Objective {
+			return all[i].result.Objective > all[j].result.Objective
+		}
+		return lessCounts(all[i].solution.combinations, all[j].solution.combinations)
+	})
+
+	results := make([]SolveResult, len(all))
+	for i, f := range all {
+		results[i] = f.result
+	}
+	return results, nil
+}
+
+// addNoGoodCut excludes the solution from the model, by demanding that the combination counts differ from it in at least one place:
+//
+//	sum(xj : cj = 0) + sum(uj - xj : cj = uj) + sum(pj + qj : 0 < cj < uj) >= 1
+//
+// where uj is the upper bound of xj, and xj = cj + pj - qj for integer pj in [0, uj - cj] and qj in [0, cj],
+// of which at most one is nonzero (pj <= (uj - cj) * dj and qj <= cj * (1 - dj) for binary dj), so that pj + qj = |xj - cj|.
+// The inequalities are written as equalities using nonnegative slack variables.
+func (m *model) addNoGoodCut(solution modelSolution) {
+	prefix := fmt.Sprintf("cut_%v", m.cuts)
+	m.cuts++
+
+	cut := m.addConstraint(prefix)
+	rhs := 1.0
+	maxLHS := 0
+	for k, j := range m.comboIndex {
+		c := solution.combinations[j]
+		xj := m.comboVars[k]
+		uj := m.comboBounds[k]
+		switch c {
+		case 0:
+			cut.AddExpression(1, xj)
+		case uj:
+			cut.AddExpression(-1, xj)
+			rhs -= float64(uj)
+		default:
+			up, down := float64(uj-c), float64(c)
+			pj := m.addVariable(fmt.Sprintf("%v_p_%v", prefix, j), 0, true, 0, up)
+			qj := m.addVariable(fmt.Sprintf("%v_q_%v", prefix, j), 0, true, 0, down)
+			dj := m.addVariable(fmt.Sprintf("%v_d_%v", prefix, j), 0, true, 0, 1)
+			sp := m.addVariable(fmt.Sprintf("%v_sp_%v", prefix, j), 0, false, 0, up)
+			sq := m.addVariable(fmt.Sprintf("%v_sq_%v", prefix, j), 0, false, 0, down)
+
+			// xj - pj + qj = cj
+			m.addConstraint(fmt.Sprintf("%v_x_%v", prefix, j)).AddExpression(1, xj).AddExpression(-1, pj).AddExpression(1, qj).EqualTo(down)
+
+			// pj - (uj - cj) * dj + spj = 0
+			m.addConstraint(fmt.Sprintf("%v_up_%v", prefix, j)).AddExpression(1, pj).AddExpression(-up, dj).AddExpression(1, sp).EqualTo(0)
+
+			// qj + cj * dj + sqj = cj
+			m.addConstraint(fmt.Sprintf("%v_down_%v", prefix, j)).AddExpression(1, qj).AddExpression(down, dj).AddExpression(1, sq).EqualTo(down)
+
+			cut.AddExpression(1, pj).AddExpression(1, qj)
+		}
+		maxLHS += uj
+	}
+
+	// the surplus variable turns the cut into an equality. The left hand side never exceeds the sum of the upper bounds.
+	surplus := m.addVariable(prefix+"_surplus", 0, false, 0, float64(maxLHS))
+	cut.AddExpression(-1, surplus).EqualTo(rhs)
+}
+
+// lessCounts orders combination counts lexicographically, the arrangement using the earliest combination the most times first.
+func lessCounts(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return false
+}
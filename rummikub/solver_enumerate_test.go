@@ -0,0 +1,71 @@
+package rummikub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSolver_Enumerate(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	hand := []Brick{{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}, {Value: 4, Color: "red"}}
+
+	// a single run takes all bricks.
+	results, err := solver.Enumerate(hand, []BrickCombination{}, false, EnumerateOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, 4.0, results[0].Objective)
+		assert.Equal(t, 0.0, results[0].Gap())
+		assert.Equal(t, []BrickCombination{NewBrickCombination(hand...)}, results[0].Arrangement)
+	}
+
+	// two runs of three bricks are one brick short of the optimum.
+	results, err = solver.Enumerate(hand, []BrickCombination{}, false, EnumerateOptions{Tolerance: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, []float64{4, 3, 3}, []float64{results[0].Objective, results[1].Objective, results[2].Objective})
+		assert.Equal(t, 4.0, results[1].Bound)
+		assert.NotEqual(t, results[1].Arrangement, results[2].Arrangement, "arrangements are not distinct")
+		for _, r := range results[1:] {
+			if assert.Len(t, r.Arrangement, 1) {
+				assert.NoError(t, solver.rules.IsLegalCombination(r.Arrangement[0]))
+			}
+		}
+	}
+
+	// the ordering does not depend on the order in which the arrangements are found.
+	again, err := solver.Enumerate(hand, []BrickCombination{}, false, EnumerateOptions{Tolerance: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, results, stripStats(again, results))
+
+	limited, err := solver.Enumerate(hand, []BrickCombination{}, false, EnumerateOptions{Limit: 2, Tolerance: 1})
+	assert.NoError(t, err)
+	assert.Len(t, limited, 2)
+}
+
+// stripStats copies the (timing dependent) stats of the expected results into the actual ones.
+func stripStats(actual []SolveResult, expected []SolveResult) []SolveResult {
+	for i := range actual {
+		if i < len(expected) {
+			actual[i].Stats = expected[i].Stats
+		}
+	}
+	return actual
+}
+
+func TestSolver_Solve_Deterministic(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	table := []BrickCombination{
+		NewBrickCombination(Brick{Value: 5, Color: "red"}, Brick{Value: 5, Color: "blue"}, Brick{Value: 5, Color: "green"}),
+	}
+	hand := []Brick{{Value: 5, Color: "yellow"}, {Value: 7, Color: "blue"}, {Value: 8, Color: "blue"}, {Value: 9, Color: "blue"}}
+
+	combinations, bricks, err := solver.Solve(hand, table, false)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		c, b, err := solver.Solve(hand, table, false)
+		assert.NoError(t, err)
+		assert.Equal(t, combinations, c, "the order of the combinations is not stable")
+		assert.Equal(t, bricks, b, "the order of the bricks is not stable")
+	}
+}
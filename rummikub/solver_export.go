@@ -0,0 +1,257 @@
+package rummikub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// lpTermsPerLine is the number of terms written on each line of an LP file, as LP readers limit the length of lines.
+const lpTermsPerLine = 8
+
+// WriteLP writes the ILP model of the turn to w in CPLEX LP format, to inspect it or to solve it with another MIP solver.
+// The model is the one Solve starts from: presolved unless SolverOptions.DisablePresolve is set, and without the cutoff
+// of the incumbent (see SolverOptions.Cache). Its variables are named like those of the solver:
+// combi_<j> for the number of times the j-th combination of the combination space is put on the table,
+// and <color>_<value> for the number of those bricks put on the table from the hand.
+func (searchSpace *ILPSolver) WriteLP(w io.Writer, hand []Brick, table []BrickCombination, maxValue bool) error {
+	return searchSpace.buildModel(hand, table, maxValue).writeLP(w, modelTitle(maxValue))
+}
+
+// WriteMPS writes the ILP model of the turn to w in (free) MPS format. The model is the same as that of WriteLP.
+func (searchSpace *ILPSolver) WriteMPS(w io.Writer, hand []Brick, table []BrickCombination, maxValue bool) error {
+	return searchSpace.buildModel(hand, table, maxValue).writeMPS(w, modelTitle(maxValue))
+}
+
+// modelTitle describes the objective of the model, to be written as a comment.
+func modelTitle(maxValue bool) string {
+	if maxValue {
+		return "Rummikub turn: maximize the value of the bricks put on the table"
+	}
+	return "Rummikub turn: maximize the number of bricks put on the table"
+}
+
+// emptyObjectiveVariable is the variable written in the objective function of a model without any variables, as LP readers
+// do not accept an empty objective function. It is fixed at 0.
+const emptyObjectiveVariable = "x0"
+
+// writeLP writes the model in CPLEX LP format:
+//
+//	Maximize
+//	 obj: sum(ci * vi)
+//	Subject To
+//	 <constraint>: sum(aij * vj) = bi
+//	Bounds
+//	 li <= vi <= ui
+//	General
+//	 <integer variables>
+//	End
+func (m *model) writeLP(w io.Writer, title string) error {
+	names, rows, err := m.exportNames()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "\\ %v\n", title)
+	fmt.Fprintln(bw, "Maximize")
+	var objective []modelTerm
+	for k, v := range m.variables {
+		if v.coeff != 0 {
+			objective = append(objective, modelTerm{v.coeff, k})
+		}
+	}
+	fmt.Fprint(bw, " obj:")
+	switch {
+	case len(objective) > 0:
+		writeLPTerms(bw, objective, names)
+	case len(names) > 0:
+		// the objective function may not be empty: write a zero term instead.
+		fmt.Fprintf(bw, " 0 %v", names[0])
+	default:
+		fmt.Fprintf(bw, " 0 %v", emptyObjectiveVariable)
+	}
+	fmt.Fprintln(bw)
+
+	fmt.Fprintln(bw, "Subject To")
+	for i, c := range m.constraints {
+		fmt.Fprintf(bw, " %v:", rows[i])
+		writeLPTerms(bw, c.terms, names)
+		fmt.Fprintf(bw, " = %v\n", formatNumber(c.rhs))
+	}
+
+	fmt.Fprintln(bw, "Bounds")
+	for k, v := range m.variables {
+		fmt.Fprintf(bw, " %v <= %v <= %v\n", formatNumber(v.lower), names[k], formatNumber(v.upper))
+	}
+	if len(names) == 0 {
+		fmt.Fprintf(bw, " %v = 0\n", emptyObjectiveVariable)
+	}
+
+	fmt.Fprintln(bw, "General")
+	n := 0
+	for k, v := range m.variables {
+		if !v.integer {
+			continue
+		}
+		if n > 0 && n%lpTermsPerLine == 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, " %v", names[k])
+		n++
+	}
+	if n > 0 {
+		fmt.Fprintln(bw)
+	}
+
+	fmt.Fprintln(bw, "End")
+	return bw.Flush()
+}
+
+// writeLPTerms writes the terms as a sum, breaking the line every lpTermsPerLine terms.
+func writeLPTerms(w io.Writer, terms []modelTerm, names []string) {
+	for i, t := range terms {
+		if i > 0 && i%lpTermsPerLine == 0 {
+			fmt.Fprint(w, "\n   ")
+		}
+		sign, coeff := "+", t.coeff
+		if coeff < 0 {
+			sign, coeff = "-", -coeff
+		}
+		if i == 0 && sign == "+" {
+			fmt.Fprintf(w, " %v %v", formatNumber(coeff), names[t.variable])
+		} else {
+			fmt.Fprintf(w, " %v %v %v", sign, formatNumber(coeff), names[t.variable])
+		}
+	}
+}
+
+// writeMPS writes the model in free MPS format: the sections are those of fixed MPS, but the fields are separated by spaces
+// rather than placed in columns, so that names may be longer than 8 characters. The objective is maximized (see OBJSENSE),
+// and the integer variables are enclosed in INTORG and INTEND markers.
+func (m *model) writeMPS(w io.Writer, title string) error {
+	names, rows, err := m.exportNames()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "* %v\n", title)
+	fmt.Fprintln(bw, "NAME rummikub")
+	fmt.Fprintln(bw, "OBJSENSE")
+	fmt.Fprintln(bw, "    MAX")
+
+	fmt.Fprintln(bw, "ROWS")
+	fmt.Fprintln(bw, " N  obj")
+	for _, row := range rows {
+		fmt.Fprintf(bw, " E  %v\n", row)
+	}
+
+	// the entries of each column (variable): its coefficient in the objective function and in each constraint, in order.
+	type entry struct {
+		row   string
+		coeff float64
+	}
+	columns := make([][]entry, len(m.variables))
+	for k, v := range m.variables {
+		if v.coeff != 0 {
+			columns[k] = append(columns[k], entry{"obj", v.coeff})
+		}
+	}
+	for i, c := range m.constraints {
+		for _, t := range c.terms {
+			columns[t.variable] = append(columns[t.variable], entry{rows[i], t.coeff})
+		}
+	}
+
+	fmt.Fprintln(bw, "COLUMNS")
+	integer := false
+	markers := 0
+	for k, v := range m.variables {
+		if v.integer != integer {
+			marker := "INTORG"
+			if integer {
+				marker = "INTEND"
+			}
+			fmt.Fprintf(bw, "    MARKER%v  'MARKER'  '%v'\n", markers, marker)
+			markers++
+			integer = v.integer
+		}
+		if len(columns[k]) == 0 {
+			// declare the variable, even though it is in neither the objective function nor any constraint.
+			columns[k] = append(columns[k], entry{"obj", 0})
+		}
+		for _, e := range columns[k] {
+			fmt.Fprintf(bw, "    %v  %v  %v\n", names[k], e.row, formatNumber(e.coeff))
+		}
+	}
+	if integer {
+		fmt.Fprintf(bw, "    MARKER%v  'MARKER'  'INTEND'\n", markers)
+	}
+
+	fmt.Fprintln(bw, "RHS")
+	for i, c := range m.constraints {
+		if c.rhs != 0 {
+			fmt.Fprintf(bw, "    RHS  %v  %v\n", rows[i], formatNumber(c.rhs))
+		}
+	}
+
+	fmt.Fprintln(bw, "BOUNDS")
+	for k, v := range m.variables {
+		if v.lower != 0 {
+			fmt.Fprintf(bw, " LO BND  %v  %v\n", names[k], formatNumber(v.lower))
+		}
+		fmt.Fprintf(bw, " UP BND  %v  %v\n", names[k], formatNumber(v.upper))
+	}
+
+	fmt.Fprintln(bw, "ENDATA")
+	return bw.Flush()
+}
+
+// exportNames returns the names of the variables and of the constraints, as written out (see exportName).
+// Returns an error if two variables or two constraints would be written out under the same name (e.g. for the colors "dark blue" and "dark_blue"),
+// or a constraint under the name of the objective function.
+func (m *model) exportNames() (variables []string, constraints []string, err error) {
+	variables = make([]string, len(m.variables))
+	seen := make(map[string]string, len(m.variables))
+	for k, v := range m.variables {
+		variables[k] = exportName(v.name)
+		if other, ok := seen[variables[k]]; ok {
+			return nil, nil, fmt.Errorf("variables %q and %q are both written out as %q", other, v.name, variables[k])
+		}
+		seen[variables[k]] = v.name
+	}
+
+	constraints = make([]string, len(m.constraints))
+	seen = map[string]string{"obj": "the objective function"}
+	for i, c := range m.constraints {
+		constraints[i] = exportName(c.name)
+		if other, ok := seen[constraints[i]]; ok {
+			return nil, nil, fmt.Errorf("constraints %q and %q are both written out as %q", other, c.name, constraints[i])
+		}
+		seen[constraints[i]] = c.name
+	}
+	return variables, constraints, nil
+}
+
+// exportName returns the name of a variable or constraint, made safe to write in either format: brick colors may be any string,
+// but names may only hold letters, digits, underscores and periods, and may not start with a digit or a period.
+func exportName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if safe == "" || safe[0] == '.' || (safe[0] >= '0' && safe[0] <= '9') {
+		safe = "_" + safe
+	}
+	return safe
+}
+
+// formatNumber writes a number the shortest way that reads back exactly.
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,111 @@
+package rummikub
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// exportTestHand extends contextTestHand with a brick that is in no combination with the others, and a joker.
+var exportTestHand = append([]Brick{{Value: 9, Color: "blue"}, MakeJoker()}, contextTestHand...)
+
+// checkGolden compares the output with the golden file, or rewrites the golden file if -update is set.
+func checkGolden(t *testing.T, name string, write func(w io.Writer) error) {
+	var out bytes.Buffer
+	if !assert.NoError(t, write(&out)) {
+		return
+	}
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		assert.NoError(t, ioutil.WriteFile(path, out.Bytes(), 0644))
+		return
+	}
+	golden, err := ioutil.ReadFile(path)
+	if assert.NoError(t, err, "run the test with -update to create the golden file") {
+		assert.Equal(t, string(golden), out.String(), "the model written differs from %v", path)
+	}
+}
+
+func TestILPSolver_WriteLP(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	checkGolden(t, "model_bricks.lp", func(w io.Writer) error {
+		return solver.WriteLP(w, exportTestHand, contextTestTable, false)
+	})
+	checkGolden(t, "model_value.lp", func(w io.Writer) error {
+		return solver.WriteLP(w, exportTestHand, contextTestTable, true)
+	})
+	checkGolden(t, "model_empty.lp", func(w io.Writer) error {
+		return solver.WriteLP(w, []Brick{}, []BrickCombination{}, false)
+	})
+}
+
+func TestILPSolver_WriteMPS(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+	checkGolden(t, "model_bricks.mps", func(w io.Writer) error {
+		return solver.WriteMPS(w, exportTestHand, contextTestTable, false)
+	})
+	checkGolden(t, "model_value.mps", func(w io.Writer) error {
+		return solver.WriteMPS(w, exportTestHand, contextTestTable, true)
+	})
+}
+
+func TestILPSolver_WriteNameCollision(t *testing.T) {
+	// the bricks of both colors would be written out as dark_blue_<value>.
+	rules := NewDefaultRules()
+	rules.Colors = []string{"red", "dark blue", "dark_blue", "green"}
+	solver := NewILPSolver(rules)
+	hand := []Brick{{Value: 1, Color: "dark blue"}, {Value: 1, Color: "dark_blue"}}
+
+	var out bytes.Buffer
+	assert.Error(t, solver.WriteLP(&out, hand, []BrickCombination{}, false))
+	assert.Error(t, solver.WriteMPS(&out, hand, []BrickCombination{}, false))
+	assert.Empty(t, out.String(), "a partial model was written")
+}
+
+func TestModel_Record(t *testing.T) {
+	// the model as recorded for export is the model solved: the optimal solution satisfies each of its constraints.
+	solver := NewILPSolver(NewDefaultRules())
+	m := solver.buildModel(exportTestHand, contextTestTable, true)
+	assert.Len(t, m.variables, len(m.comboVars)+len(m.brickVars))
+	assert.Len(t, m.constraints, len(m.brickVars))
+
+	solution, _, err := solver.runModel(m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	values := make([]float64, len(m.variables))
+	objective := 0.0
+	for k, v := range m.comboVars {
+		values[m.variableIndex[v]] = float64(solution.combinations[m.comboIndex[k]])
+	}
+	for k, v := range m.brickVars {
+		values[m.variableIndex[v]] = float64(solution.bricks[m.brickIndex[k]])
+	}
+	for k, v := range m.variables {
+		assert.True(t, v.lower <= values[k] && values[k] <= v.upper, "%v = %v is out of bounds", v.name, values[k])
+		objective += v.coeff * values[k]
+	}
+	for _, c := range m.constraints {
+		lhs := 0.0
+		for _, term := range c.terms {
+			lhs += term.coeff * values[term.variable]
+		}
+		assert.Equal(t, c.rhs, lhs, "constraint %v is violated", c.name)
+	}
+	assert.Equal(t, 7.0, objective, "the joker and the run in the hand")
+}
+
+func TestExportName(t *testing.T) {
+	assert.Equal(t, "red_1", exportName("red_1"))
+	assert.Equal(t, "dark_blue_1", exportName("dark blue_1"))
+	assert.Equal(t, "_1st_1", exportName("1st_1"))
+	assert.Equal(t, "_", exportName(""))
+}
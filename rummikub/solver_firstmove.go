@@ -0,0 +1,124 @@
+package rummikub
+
+// FirstMoveSolver is implemented by solvers that encode the first move rules (see Rules.CheckFirstMove) in their search,
+// so that they find the best legal first move directly.
+type FirstMoveSolver interface {
+	SolveFirstMove(hand []Brick, table []BrickCombination, maximizeValue bool) (proposedArrangement []BrickCombination, bricksToPut []Brick, solveError error)
+}
+
+// SolveFirstMove finds the best legal first move: the one the FirstMoveSolver comes up with if the solver is one,
+// or else the solver's regular arrangement, if that happens to satisfy the first move rules.
+// If the arrangement does not satisfy the first move rules, the unchanged table (a forfeit) is returned instead.
+func SolveFirstMove(solver Solver, rules Rules, hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	var arrangement []BrickCombination
+	var bricks []Brick
+	var err error
+	if fms, ok := solver.(FirstMoveSolver); ok {
+		arrangement, bricks, err = fms.SolveFirstMove(hand, table, maximizeValue)
+	} else {
+		arrangement, bricks, err = solver.Solve(hand, table, maximizeValue)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(bricks) == 0 || rules.CheckFirstMove(table, arrangement) != nil {
+		return append([]BrickCombination{}, table...), []Brick{}, nil
+	}
+	return arrangement, bricks, nil
+}
+
+// SolveFirstMove is Solve, constrained to the legal first moves: either no bricks are put on the table,
+// or the bricks put on the table reach Rules.FirstMoveValue. If Rules.FirstMoveHandOnly is set, the table is left as it is,
+// and the bricks from the hand form combinations of their own.
+func (searchSpace *ILPSolver) SolveFirstMove(hand []Brick, table []BrickCombination, maxValue bool) ([]BrickCombination, []Brick, error) {
+	rules := searchSpace.rules
+
+	modelTable := table
+	if rules.FirstMoveHandOnly {
+		modelTable = nil
+	}
+
+	m := searchSpace.buildModel(hand, modelTable, maxValue)
+	m.addFirstMoveConstraint(searchSpace, len(hand))
+	solution, _, err := searchSpace.runModel(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	combinationsToPut, bricksToPut := searchSpace.decode(solution)
+	if rules.FirstMoveHandOnly {
+		combinationsToPut = append(append([]BrickCombination{}, table...), combinationsToPut...)
+	}
+	return combinationsToPut, bricksToPut, nil
+}
+
+// addFirstMoveConstraint demands that either no bricks are put on the table, or that their value reaches the first move threshold T:
+//
+//	sum(vi * yi) - T * z - e = 0
+//	sum(yi) - M * z + s = 0
+//
+// for binary z, nonnegative slack variables e and s, and M the number of bricks in the hand.
+// If Rules.FirstMoveHandOnly is set, the value is counted per combination instead (vj * xj, see Rules.CombinationValue),
+// as the table is not part of the model. Otherwise the jokers put on the table count as FirstMoveJokerValue if they count as JOKER_FIXED,
+// or else as the lowest value any joker in the model can represent (see lowestJokerValue): what they do represent depends on the arrangement,
+// which makes it a lower bound of the value Rules.CheckFirstMove gives them.
+func (m *model) addFirstMoveConstraint(searchSpace *ILPSolver, handSize int) {
+	rules := searchSpace.rules
+	if rules.FirstMoveValue <= 0 {
+		return
+	}
+
+	z := m.addVariable("first_move", 0, true, 0, 1)
+
+	value := m.addConstraint("first_move_value")
+	maxTotal := 0
+	if rules.FirstMoveHandOnly {
+		for k, j := range m.comboIndex {
+			v := rules.CombinationValue(searchSpace.combinations[j])
+			value.AddExpression(float64(v), m.comboVars[k])
+			maxTotal += m.comboBounds[k] * v
+		}
+	} else {
+		jokerValue := rules.FirstMoveJokerValue
+		if rules.FirstMoveJokerValuation != JOKER_FIXED {
+			jokerValue = m.lowestJokerValue(searchSpace)
+		}
+		for k, i := range m.brickIndex {
+			b := searchSpace.uniqueBricks[i]
+			v := b.Value
+			if b.Color == JokerColor {
+				v = jokerValue
+			}
+			value.AddExpression(float64(v), m.brickVars[k])
+			maxTotal += searchSpace.copies(b) * v
+		}
+	}
+	excess := m.addVariable("first_move_excess", 0, false, 0, float64(maxTotal))
+	value.AddExpression(-float64(rules.FirstMoveValue), z).AddExpression(-1, excess).EqualTo(0)
+
+	count := m.addConstraint("first_move_count")
+	for _, y := range m.brickVars {
+		count.AddExpression(1, y)
+	}
+	slack := m.addVariable("first_move_slack", 0, false, 0, float64(handSize))
+	count.AddExpression(-float64(handSize), z).AddExpression(1, slack).EqualTo(0)
+}
+
+// lowestJokerValue returns the lowest value of the bricks the jokers in the combinations of the model stand in for (see Rules.ResolveJokers),
+// or 0 if none of them has a joker.
+func (m *model) lowestJokerValue(searchSpace *ILPSolver) int {
+	lowest := 0
+	for _, j := range m.comboIndex {
+		c := searchSpace.combinations[j]
+		if c.jokerCount() == 0 || searchSpace.rules.ResolveJokers(&c) != nil {
+			continue
+		}
+		for _, b := range c.Jokers {
+			if lowest == 0 || b.Value < lowest {
+				lowest = b.Value
+			}
+		}
+	}
+	return lowest
+}
@@ -0,0 +1,70 @@
+package rummikub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSolver_SolveFirstMove(t *testing.T) {
+	table := []BrickCombination{
+		NewBrickCombination(Brick{Value: 5, Color: "red"}, Brick{Value: 5, Color: "blue"}, Brick{Value: 5, Color: "green"}),
+	}
+	hand := []Brick{{Value: 5, Color: "yellow"}, {Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}, {Value: 13, Color: "blue"}}
+	rules := NewDefaultRules()
+	rules.FirstMoveHandOnly = true
+	solver := NewILPSolver(rules)
+
+	// the run of 1, 2, 3 (and the yellow 5) does not reach the threshold: the only legal first move is to forfeit.
+	arrangement, bricksToPut, err := solver.SolveFirstMove(hand, table, true)
+	assert.NoError(t, err)
+	assert.Empty(t, bricksToPut)
+	assert.Equal(t, table, arrangement)
+
+	// with the run of 11, 12, 13 it does, but the yellow 5 can not be added to the table.
+	hand = append(hand, Brick{Value: 11, Color: "blue"}, Brick{Value: 12, Color: "blue"})
+	arrangement, bricksToPut, err = solver.SolveFirstMove(hand, table, false)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 6)
+	assert.Equal(t, table[0], arrangement[0], "the table was rearranged")
+	assert.NoError(t, rules.CheckFirstMove(table, arrangement))
+
+	// unless the table may be used.
+	rules.FirstMoveHandOnly = false
+	solver = NewILPSolver(rules)
+	arrangement, bricksToPut, err = SolveFirstMove(solver, rules, hand, table, false)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 7)
+	assert.NoError(t, rules.CheckFirstMove(table, arrangement))
+
+	// solvers that do not know about the first move rules are overruled if their move is not a legal first move:
+	// here the yellow 5 is added to the table, which the hand-only rules do not allow.
+	rules.FirstMoveHandOnly = true
+	plainSolver := struct{ Solver }{NewILPSolver(rules)}
+	arrangement, bricksToPut, err = SolveFirstMove(plainSolver, rules, hand, table, false)
+	assert.NoError(t, err)
+	assert.Empty(t, bricksToPut)
+	assert.Equal(t, table, arrangement)
+}
+
+func TestSolver_SolveFirstMove_RepresentedJokers(t *testing.T) {
+	rules := NewDefaultRules()
+	rules.FirstMoveValue = 30
+	solver := NewILPSolver(rules)
+
+	// the joker stands in for the red 12: the run is worth 33.
+	hand := []Brick{MakeJoker(), {Value: 10, Color: "red"}, {Value: 11, Color: "red"}}
+	arrangement, bricksToPut, err := solver.SolveFirstMove(hand, []BrickCombination{}, false)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 3)
+	assert.NoError(t, rules.CheckFirstMove([]BrickCombination{}, arrangement))
+
+	// as a fixed joker it is worth less.
+	rules.FirstMoveJokerValuation = JOKER_FIXED
+	rules.FirstMoveJokerValue = 5
+	solver = NewILPSolver(rules)
+	arrangement, bricksToPut, err = solver.SolveFirstMove(hand, []BrickCombination{}, false)
+	assert.NoError(t, err)
+	assert.Empty(t, bricksToPut)
+	assert.Empty(t, arrangement)
+}
@@ -0,0 +1,238 @@
+package rummikub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// PortfolioGrace is the time the PortfolioSolver waits for the incumbents of its engines once the budget has run out and they have been cancelled.
+const PortfolioGrace = 50 * time.Millisecond
+
+// PortfolioEngine is one of the solvers raced by a PortfolioSolver, and the name it is reported by (see SolveResult.Engine).
+type PortfolioEngine struct {
+	Name   string
+	Solver ContextSolver
+}
+
+// PortfolioSolver races several solvers (its engines) on each turn, as different solvers are fast on different positions.
+// It returns the first arrangement that is proven to be optimal (see SolveResult.Gap), or else the best arrangement found by any engine
+// once the budget has run out. Either way, the other engines are cancelled through the context they are given.
+// Engines whose solver can not be interrupted (the ILPSolver, and see AdaptSolver) return at once, but leave their solve to finish
+// in the background: until it has, their next solve waits for it (see ILPSolver.SolveContext).
+type PortfolioSolver struct {
+	engines []PortfolioEngine
+	rules   Rules
+
+	// the budget of Solve and SolveFirstMove, which are not given one. DefaultSolveBudget if zero.
+	budget time.Duration
+
+	mu   sync.Mutex
+	wins map[string]int // the number of turns each engine won, by name.
+}
+
+// NewPortfolioSolver returns a PortfolioSolver racing the engines, in order of preference: if several engines come up with
+// equally good arrangements, the one listed first wins. The rules are used to check the arrangements of engines that do not
+// know about the first move rules (see SolveFirstMove).
+func NewPortfolioSolver(rules Rules, engines ...PortfolioEngine) *PortfolioSolver {
+	return &PortfolioSolver{engines: engines, rules: rules, wins: make(map[string]int)}
+}
+
+// NewDefaultPortfolioSolver returns a PortfolioSolver racing the ILP solver against the greedy solver, for the game rules.
+func NewDefaultPortfolioSolver(rules Rules) *PortfolioSolver {
+	return NewPortfolioSolver(rules,
+		PortfolioEngine{Name: "ilp", Solver: NewILPSolver(rules)},
+		PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)},
+	)
+}
+
+// SetBudget sets the budget of Solve and SolveFirstMove.
+func (p *PortfolioSolver) SetBudget(budget time.Duration) {
+	p.budget = budget
+}
+
+// Wins returns the number of turns each engine won so far, by name.
+func (p *PortfolioSolver) Wins() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wins := make(map[string]int, len(p.wins))
+	for name, n := range p.wins {
+		wins[name] = n
+	}
+	return wins
+}
+
+func (p *PortfolioSolver) getBudget() time.Duration {
+	if p.budget <= 0 {
+		return DefaultSolveBudget
+	}
+	return p.budget
+}
+
+// Solve races the engines within the solver's budget (see SetBudget).
+func (p *PortfolioSolver) Solve(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	result, err := p.SolveContext(context.Background(), p.getBudget(), hand, table, maximizeValue)
+	return result.Arrangement, result.BricksToPut, err
+}
+
+// SolveContext races the engines. The error is the context's error, or the error of the first engine that failed if all of them did.
+func (p *PortfolioSolver) SolveContext(ctx context.Context, budget time.Duration, hand []Brick, table []BrickCombination, maximizeValue bool) (SolveResult, error) {
+	return p.race(ctx, budget, hand, table, maximizeValue, false, func(ctx context.Context, engine ContextSolver) (SolveResult, error) {
+		return engine.SolveContext(ctx, budget, hand, table, maximizeValue)
+	})
+}
+
+// SolveFirstMove races the engines on a first move, within the solver's budget (see SetBudget). Engines that are FirstMoveSolvers
+// search the legal first moves directly; the arrangements of the other engines are only kept if they satisfy the first move rules.
+func (p *PortfolioSolver) SolveFirstMove(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	budget := p.getBudget()
+	forfeit := forfeitResult(hand, table, maximizeValue)
+	result, err := p.race(context.Background(), budget, hand, table, maximizeValue, true, func(ctx context.Context, engine ContextSolver) (SolveResult, error) {
+		if fms, ok := engine.(FirstMoveSolver); ok {
+			return solveWithin(ctx, budget, solveSlotOf(engine), forfeit, func(func(SolveResult)) (SolveResult, error) {
+				arrangement, bricks, err := fms.SolveFirstMove(hand, table, maximizeValue)
+				return optimalResult(arrangement, bricks, maximizeValue), err
+			})
+		}
+		result, err := engine.SolveContext(ctx, budget, hand, table, maximizeValue)
+		if len(result.BricksToPut) > 0 && p.rules.CheckFirstMove(table, result.Arrangement) != nil {
+			// the engine's arrangement is no legal first move, so it proves nothing.
+			return forfeit, err
+		}
+		return result, err
+	})
+	return result.Arrangement, result.BricksToPut, err
+}
+
+// engineOutcome is the result of a single engine in a race.
+type engineOutcome struct {
+	engine int
+	result SolveResult
+	err    error
+}
+
+// race runs solve for each engine concurrently, and returns the first result that is proven to be optimal,
+// or else the best result received before the budget ran out (and the engines were cancelled, see PortfolioGrace).
+// Results that fail verification are logged (see LogInvalidSolution) and count as failures of their engine.
+// The unchanged table (a forfeit) is returned if no engine came up with an arrangement.
+func (p *PortfolioSolver) race(ctx context.Context, budget time.Duration, hand []Brick, table []BrickCombination, maximizeValue bool, firstMove bool,
+	solve func(ctx context.Context, engine ContextSolver) (SolveResult, error)) (SolveResult, error) {
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make(chan engineOutcome, len(p.engines))
+	for i, e := range p.engines {
+		go func(i int, engine ContextSolver) {
+			result, err := solve(raceCtx, engine)
+			outcomes <- engineOutcome{i, result, err}
+		}(i, e.Solver)
+	}
+
+	var deadline <-chan time.Time
+	if budget > 0 {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	best := forfeitResult(hand, table, maximizeValue)
+	bestEngine := -1
+	var firstErr error
+	accept := func(o engineOutcome) {
+		// engines that were cancelled by the race still return their incumbents.
+		if o.err != nil && !(errors.Is(o.err, context.Canceled) && ctx.Err() == nil) {
+			if firstErr == nil {
+				firstErr = o.err
+			}
+			return
+		}
+		if o.result.Arrangement == nil {
+			return
+		}
+		if invalid := verifyResult(p.rules, hand, table, maximizeValue, firstMove, o.result); invalid != nil {
+			LogInvalidSolution(invalid)
+			if firstErr == nil {
+				firstErr = invalid
+			}
+			return
+		}
+		if bestEngine < 0 || o.result.Objective > best.Objective || (o.result.Objective == best.Objective && o.engine < bestEngine) {
+			best, bestEngine = o.result, o.engine
+		}
+	}
+
+	pending := len(p.engines)
+race:
+	for pending > 0 {
+		select {
+		case o := <-outcomes:
+			pending--
+			accept(o)
+			if o.err == nil && bestEngine == o.engine && o.result.Gap() == 0 {
+				// a proven optimum: the other engines can not do better.
+				break race
+			}
+		case <-deadline:
+			// cancel the engines, and collect the incumbents of those that respond in time.
+			cancel()
+			grace := time.NewTimer(PortfolioGrace)
+			defer grace.Stop()
+			for pending > 0 {
+				select {
+				case o := <-outcomes:
+					pending--
+					accept(o)
+				case <-grace.C:
+					break race
+				}
+			}
+		case <-ctx.Done():
+			return forfeitResult(hand, table, maximizeValue), ctx.Err()
+		}
+	}
+
+	// the race is decided: the engines that are still running lost.
+	cancel()
+
+	if bestEngine < 0 {
+		return best, firstErr
+	}
+	best.Engine = p.engines[bestEngine].Name
+	p.mu.Lock()
+	p.wins[best.Engine]++
+	p.mu.Unlock()
+	return best, nil
+}
+
+// GreedySolver is a heuristic solver: it adds the combinations that can be made from the hand alone to the table,
+// the most valuable ones first, without rearranging the table. It is fast, but it only proves its arrangement to be optimal
+// if it puts the entire hand on the table.
+type GreedySolver struct {
+	*CombinationSpace
+}
+
+// NewGreedySolver returns a GreedySolver for the game rules, choosing from the combinations in their combination space.
+// It panics if the rules are invalid (see NewILPSolverWithOptions).
+func NewGreedySolver(rules Rules) *GreedySolver {
+	space, err := NewCombinationSpace(rules)
+	if err != nil {
+		panic(err)
+	}
+	return &GreedySolver{space}
+}
+
+// Solve returns the greedy arrangement.
+func (g *GreedySolver) Solve(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	result := g.greedyResult(hand, table, maximizeValue)
+	return result.Arrangement, result.BricksToPut, nil
+}
+
+// SolveContext returns the greedy arrangement, unless the context is already done.
+func (g *GreedySolver) SolveContext(ctx context.Context, budget time.Duration, hand []Brick, table []BrickCombination, maximizeValue bool) (SolveResult, error) {
+	if err := ctx.Err(); err != nil {
+		return forfeitResult(hand, table, maximizeValue), err
+	}
+	return g.greedyResult(hand, table, maximizeValue), nil
+}
@@ -0,0 +1,167 @@
+package rummikub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortfolioSolver_ProvenOptimum(t *testing.T) {
+	rules := NewDefaultRules()
+	solver := NewPortfolioSolver(rules,
+		PortfolioEngine{Name: "slow", Solver: AdaptSolver(&slowSolver{delay: time.Second})},
+		PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)},
+	)
+
+	// the greedy solver puts the entire hand on the table, which is optimal: the slow solver is not waited for.
+	start := time.Now()
+	result, err := solver.SolveContext(context.Background(), 0, contextTestHand, contextTestTable, false)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the portfolio waited for the other engines")
+	assert.NoError(t, err)
+	assert.Equal(t, "greedy", result.Engine)
+	assert.Len(t, result.BricksToPut, 3)
+	assert.Equal(t, 0.0, result.Gap())
+	assert.Equal(t, map[string]int{"greedy": 1}, solver.Wins())
+}
+
+// blockingSolver blocks until its context is done, and then closes cancelled.
+type blockingSolver struct {
+	cancelled chan struct{}
+}
+
+func (s *blockingSolver) SolveContext(ctx context.Context, budget time.Duration, hand []Brick, table []BrickCombination, maximizeValue bool) (SolveResult, error) {
+	<-ctx.Done()
+	close(s.cancelled)
+	return forfeitResult(hand, table, maximizeValue), ctx.Err()
+}
+
+func TestPortfolioSolver_CancelsLosers(t *testing.T) {
+	rules := NewDefaultRules()
+	loser := &blockingSolver{cancelled: make(chan struct{})}
+	solver := NewPortfolioSolver(rules,
+		PortfolioEngine{Name: "blocking", Solver: loser},
+		PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)},
+	)
+
+	result, err := solver.SolveContext(context.Background(), 0, contextTestHand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "greedy", result.Engine)
+
+	select {
+	case <-loser.cancelled:
+	case <-time.After(time.Second):
+		t.Error("the losing engine was not cancelled")
+	}
+}
+
+func TestPortfolioSolver_Deadline(t *testing.T) {
+	rules := NewDefaultRules()
+	solver := NewPortfolioSolver(rules,
+		PortfolioEngine{Name: "slow", Solver: AdaptSolver(&slowSolver{delay: time.Second})},
+		PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)},
+	)
+
+	// the greedy solver can not place the blue 13, so its arrangement is not proven to be optimal: the portfolio waits until the budget runs out.
+	hand := append([]Brick{{Value: 13, Color: "blue"}}, contextTestHand...)
+	start := time.Now()
+	result, err := solver.SolveContext(context.Background(), 50*time.Millisecond, hand, contextTestTable, false)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 50*time.Millisecond, "the portfolio did not wait for the budget to run out")
+	assert.True(t, elapsed < 500*time.Millisecond, "the portfolio did not respect its budget")
+	assert.NoError(t, err)
+	assert.Equal(t, "greedy", result.Engine)
+	assert.Len(t, result.BricksToPut, 3)
+	assert.True(t, result.Gap() > 0)
+}
+
+func TestPortfolioSolver_Preference(t *testing.T) {
+	rules := NewDefaultRules()
+	solver := NewPortfolioSolver(rules,
+		PortfolioEngine{Name: "ilp", Solver: NewILPSolver(rules)},
+		PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)},
+	)
+
+	// both engines find equally good arrangements that are not proven to be optimal: the engine listed first wins.
+	hand := append([]Brick{{Value: 13, Color: "blue"}}, contextTestHand...)
+	result, err := solver.SolveContext(context.Background(), time.Nanosecond, hand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "ilp", result.Engine)
+	assert.True(t, result.Gap() > 0)
+
+	// without a budget, the ILP solver proves its arrangement optimal.
+	result, err = solver.SolveContext(context.Background(), 0, hand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "ilp", result.Engine)
+	assert.Len(t, result.BricksToPut, 3)
+	assert.Equal(t, 0.0, result.Gap())
+}
+
+func TestPortfolioSolver_Failure(t *testing.T) {
+	solver := NewPortfolioSolver(NewDefaultRules(),
+		PortfolioEngine{Name: "failing", Solver: AdaptSolver(&failingSolver{})},
+		PortfolioEngine{Name: "failing too", Solver: AdaptSolver(&failingSolver{})},
+	)
+	result, err := solver.SolveContext(context.Background(), time.Second, contextTestHand, contextTestTable, false)
+	assert.Error(t, err)
+	assert.Equal(t, contextTestTable, result.Arrangement, "no safe incumbent returned")
+	assert.Empty(t, result.Engine)
+
+	// a failing engine does not keep the others from winning.
+	rules := NewDefaultRules()
+	solver = NewPortfolioSolver(rules,
+		PortfolioEngine{Name: "failing", Solver: AdaptSolver(&failingSolver{})},
+		PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)},
+	)
+	result, err = solver.SolveContext(context.Background(), time.Second, contextTestHand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "greedy", result.Engine)
+}
+
+func TestPortfolioSolver_Cancelled(t *testing.T) {
+	solver := NewPortfolioSolver(NewDefaultRules(), PortfolioEngine{Name: "slow", Solver: AdaptSolver(&slowSolver{delay: time.Second})})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := solver.SolveContext(ctx, 0, contextTestHand, contextTestTable, false)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, contextTestTable, result.Arrangement, "no safe incumbent returned")
+}
+
+func TestPortfolioSolver_SolveFirstMove(t *testing.T) {
+	rules := NewDefaultRules()
+	solver := NewPortfolioSolver(rules, PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)})
+
+	// the run of 1, 2, 3 does not reach the first move threshold.
+	arrangement, bricksToPut, err := SolveFirstMove(solver, rules, contextTestHand, contextTestTable, true)
+	assert.NoError(t, err)
+	assert.Empty(t, bricksToPut)
+	assert.Equal(t, contextTestTable, arrangement)
+
+	// the run of 11, 12, 13 does.
+	hand := []Brick{{Value: 11, Color: "red"}, {Value: 12, Color: "red"}, {Value: 13, Color: "red"}}
+	arrangement, bricksToPut, err = SolveFirstMove(solver, rules, hand, contextTestTable, true)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 3)
+	assert.NoError(t, rules.CheckFirstMove(contextTestTable, arrangement))
+
+	// the ILP solver searches the legal first moves directly, which may add the yellow 5 to the table.
+	solver = NewDefaultPortfolioSolver(rules)
+	hand = append(hand, Brick{Value: 1, Color: "blue"}, Brick{Value: 2, Color: "blue"}, Brick{Value: 3, Color: "blue"}, Brick{Value: 5, Color: "yellow"})
+	arrangement, bricksToPut, err = SolveFirstMove(solver, rules, hand, contextTestTable, true)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 7)
+	assert.NoError(t, rules.CheckFirstMove(contextTestTable, arrangement))
+	assert.Equal(t, 1, solver.Wins()["ilp"])
+}
+
+func TestPortfolioSolver_AIPlayer(t *testing.T) {
+	rules := NewDefaultRules()
+	solver := NewDefaultPortfolioSolver(rules)
+	player := NewAIPlayer("AI", solver)
+	player.SetHand(append([]Brick{{Value: 4, Color: "red"}}, contextTestHand...))
+
+	move := player.MakeMove(contextTestTable, rules, false)
+	assert.Len(t, BrickSliceDiff(DissolveCombinations(contextTestTable), move.Bricks()), 4)
+}
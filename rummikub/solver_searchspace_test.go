@@ -1,7 +1,9 @@
 package rummikub
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,6 +30,38 @@ func TestCombinationSpace_NoDuplicates(t *testing.T) {
 	}
 }
 
+func TestCombinationSpace_MultipleJokers(t *testing.T) {
+	// with two jokers per combination, saltWithJokers produces combinations with two identical bricks (the jokers).
+	// None of them may be dropped as duplicates of another combination.
+	gamerules := NewDefaultRules()
+	gamerules.JokersPerCombination = 2
+	space := NewILPSolver(gamerules)
+
+	brickSet := gamerules.BaseBricks()
+	salted := append(saltWithJokers(ComputeAllGroups(brickSet), 2), saltWithJokers(ComputeAllRuns(brickSet), 2)...)
+
+	// count the distinct combinations by their sorted bricks.
+	distinct := map[string]bool{}
+	for _, c := range salted {
+		bricks := append([]Brick{}, c.getBricks()...)
+		sort.Slice(bricks, func(i, j int) bool {
+			if bricks[i].Color != bricks[j].Color {
+				return bricks[i].Color < bricks[j].Color
+			}
+			return bricks[i].Value < bricks[j].Value
+		})
+		distinct[fmt.Sprint(bricks)] = true
+		assert.True(t, space.Contains(c), "combination missing from the search space: %v", c)
+	}
+	assert.Len(t, space.AllCombinations(), len(distinct), "the search space does not hold each distinct combination exactly once")
+
+	// e.g. a run of five with two jokers, which XOR-ing the brick hashes would confuse with the run of three.
+	run := NewBrickCombination(MakeJoker(), MakeJoker(), Brick{Color: "red", Value: 5}, Brick{Color: "red", Value: 6}, Brick{Color: "red", Value: 7})
+	assert.True(t, space.Contains(run))
+	short := NewBrickCombination(run.Bricks[2:]...)
+	assert.NotEqual(t, run.Hash(), short.Hash())
+}
+
 func TestCombinationSpace_GetPossibleCombinations_ComboSizes(t *testing.T) {
 	// test whether the numbers of possible legal combinations per combination size in the search searchSpace is as expected.
 
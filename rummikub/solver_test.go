@@ -1,13 +1,17 @@
 package rummikub
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/ilp"
 )
 
 type TestProblem struct {
@@ -72,6 +76,14 @@ func solveExampleProblems(t *testing.T, testProblems []TestProblem, space *ILPSo
 				assert.True(t, found, fmt.Sprintf("The following brick: %v was expected but not suggested by the solver", expectation))
 
 			}
+
+			// the solver without presolve finds a solution that is as good (if not necessarily the same one).
+			options := space.options
+			options.DisablePresolve = true
+			full := NewILPSolverWithOptions(space.rules, options)
+			_, fullBricks, err := full.Solve(prob.hand, prob.table, maxValue)
+			assert.NoError(t, err)
+			assert.Equal(t, objective(bricksToPut, maxValue), objective(fullBricks, maxValue), "presolve changed the objective")
 		})
 	}
 }
@@ -403,7 +415,7 @@ func TestSolver_Regression_RemoveStones(t *testing.T) {
 	solver := NewILPSolver(gamerules)
 	player := NewAIPlayer("testplayer", solver)
 	player.SetHand(hand)
-	move := player.MakeMove(table, 0)
+	move := player.MakeMove(table, gamerules, false)
 
 	// has the solver added unowned bricks to play?
 	addedBricks := BrickSliceDiff(DissolveCombinations(table), move.Bricks())
@@ -431,3 +443,223 @@ func TestSolver_Regression_RemoveStones(t *testing.T) {
 	t.Log(combinationsToPut)
 
 }
+
+func TestSolver_Options(t *testing.T) {
+	assert.Equal(t, runtime.NumCPU(), NewILPSolver(NewDefaultRules()).options.workers(), "default number of workers should equal the number of CPUs")
+	assert.Equal(t, runtime.NumCPU(), SolverOptions{Workers: -1}.workers())
+	assert.Equal(t, 2, SolverOptions{Workers: 2}.workers())
+}
+
+func TestSolver_SolveWithStats(t *testing.T) {
+	// run the solver in an empty directory, to check that it does not write any files.
+	dir, err := ioutil.TempDir("", "rummigo-solver")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	assert.NoError(t, os.Chdir(dir))
+
+	var trees []*ilp.TreeLogger
+	solver := NewILPSolverWithOptions(NewDefaultRules(), SolverOptions{
+		Workers:         2,
+		Instrumentation: func(tree *ilp.TreeLogger) { trees = append(trees, tree) },
+	})
+
+	table := []BrickCombination{
+		NewBrickCombination(Brick{Value: 5, Color: "red"}, Brick{Value: 5, Color: "blue"}, Brick{Value: 5, Color: "green"}),
+	}
+	hand := []Brick{{Value: 5, Color: "yellow"}, {Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}, {Value: 13, Color: "blue"}}
+
+	_, bricksToPut, stats, err := solver.SolveWithStats(hand, table, true)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 4)
+	assert.Equal(t, 11.0, stats.Objective)
+	assert.Equal(t, stats.Objective, stats.Bound)
+	assert.Equal(t, 2, stats.Workers)
+	assert.True(t, stats.Nodes > 0, "no branch-and-bound nodes reported")
+	assert.True(t, stats.Duration > 0, "no solve time reported")
+
+	// the instrumentation received the tree of the single solve.
+	if assert.Len(t, trees, 1) {
+		assert.Equal(t, stats.Nodes, trees[0].Nodes())
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files, "the solver wrote files to the working directory")
+}
+
+func TestSolver_RunModelCountsNodes(t *testing.T) {
+	// a model whose relaxation is fractional: max x + y, such that 2x + 2y + s = 3, for integer x and y and a continuous slack s.
+	// its optimum (x + y = 1) can only be proven by branching.
+	m := &model{prob: ilp.NewProblem(), comboIndex: []int{0, 1}, combinations: 2, variableIndex: make(map[*ilp.Variable]int)}
+	m.prob.Maximize()
+	for j := 0; j < 2; j++ {
+		name := fmt.Sprintf("combi_%v", j)
+		m.comboVars = append(m.comboVars, m.addVariable(name, 1, true, 0, 2))
+		m.comboNames = append(m.comboNames, name)
+	}
+	slack := m.addVariable("slack", 0, false, 0, 3)
+	m.addConstraint("capacity").
+		AddExpression(2, m.comboVars[0]).
+		AddExpression(2, m.comboVars[1]).
+		AddExpression(1, slack).
+		EqualTo(3)
+
+	var trees []*ilp.TreeLogger
+	solver := &ILPSolver{options: SolverOptions{
+		Workers:         1,
+		Instrumentation: func(tree *ilp.TreeLogger) { trees = append(trees, tree) },
+	}}
+
+	solution, stats, err := solver.runModel(m)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, solution.combinations[0]+solution.combinations[1])
+	assert.True(t, stats.Nodes > 1, "the solver did not branch: %v nodes reported", stats.Nodes)
+	if assert.Len(t, trees, 1) {
+		assert.Equal(t, stats.Nodes, trees[0].Nodes())
+	}
+}
+
+func TestSolver_ThreeReplicates(t *testing.T) {
+	gamerules := NewDefaultRules()
+	gamerules.Replicates = 3
+	assert.NoError(t, gamerules.Validate(4))
+	solver := NewILPSolver(gamerules)
+	assert.NoError(t, solver.Validate())
+
+	// the same run can be played three times.
+	hand := []Brick{}
+	for i := 0; i < 3; i++ {
+		hand = append(hand, Brick{Value: 5, Color: "red"}, Brick{Value: 6, Color: "red"}, Brick{Value: 7, Color: "red"})
+	}
+	arrangement, bricksToPut, err := solver.Solve(hand, []BrickCombination{}, false)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, 9)
+	assert.Len(t, arrangement, 3)
+	for _, c := range arrangement {
+		assert.NoError(t, gamerules.IsLegalCombination(c))
+	}
+}
+
+func TestSolver_SixColors(t *testing.T) {
+	gamerules := NewDefaultRules()
+	gamerules.Colors = []string{"red", "green", "blue", "yellow", "black", "orange"}
+	gamerules.JokersInPlay = 3
+	gamerules.Values = 9 // keeps the search space (and the test) small.
+	assert.NoError(t, gamerules.Validate(6))
+	solver := NewILPSolver(gamerules)
+	assert.NoError(t, solver.Validate())
+
+	// a group of five can not be split up into smaller groups, and each joker goes into a run of its own.
+	hand := []Brick{
+		{Value: 9, Color: "red"}, {Value: 9, Color: "green"}, {Value: 9, Color: "blue"}, {Value: 9, Color: "yellow"}, {Value: 9, Color: "black"},
+		{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, MakeJoker(),
+		{Value: 1, Color: "blue"}, {Value: 2, Color: "blue"}, MakeJoker(),
+		{Value: 1, Color: "orange"}, {Value: 2, Color: "orange"}, MakeJoker(),
+	}
+	arrangement, bricksToPut, err := solver.Solve(hand, []BrickCombination{}, false)
+	assert.NoError(t, err)
+	assert.Len(t, bricksToPut, len(hand))
+	for _, c := range arrangement {
+		assert.NoError(t, gamerules.IsLegalCombination(c))
+	}
+}
+
+func TestSolver_Validate(t *testing.T) {
+	gamerules := NewDefaultRules()
+	solver := NewILPSolver(gamerules)
+	assert.NoError(t, solver.Validate())
+
+	// each combination can be played as often as the bricks in play allow.
+	for i, c := range solver.AllCombinations() {
+		expected := 2
+		if c.jokerCount() > 1 {
+			expected = 1
+		}
+		assert.Equal(t, expected, solver.combinationBounds[i], "unexpected bound for %v", c)
+	}
+
+	// a search space built for other rules is rejected.
+	solver.rules.JokersInPlay = 0
+	assert.True(t, errors.Is(solver.Validate(), ErrInconsistentSearchSpace))
+	solver.rules = NewDefaultRules()
+	solver.rules.Colors = []string{"red", "green", "blue"}
+	assert.True(t, errors.Is(solver.Validate(), ErrInconsistentSearchSpace))
+}
+
+func TestSolver_Presolve(t *testing.T) {
+	solver := NewILPSolver(NewDefaultRules())
+
+	// with only the red 1, 2, 3 and 4 available, the runs of three and four of them are all that can be made (without jokers).
+	available := solver.brickCounts([]Brick{{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"}, {Value: 4, Color: "red"}, {Value: 4, Color: "red"}})
+	combinations, bounds := solver.presolve(available)
+	if assert.Len(t, combinations, 3) {
+		for k, j := range combinations {
+			c := solver.combinations[j]
+			assert.NoError(t, c.IsValidRun())
+			assert.Equal(t, 0, c.jokerCount())
+			assert.Equal(t, 1, bounds[k], "unexpected bound for %v", c)
+		}
+	}
+
+	// two of each can be made twice, but only as often as the bricks in play allow.
+	available = solver.brickCounts([]Brick{
+		{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"},
+		{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"},
+		{Value: 1, Color: "red"}, {Value: 2, Color: "red"}, {Value: 3, Color: "red"},
+	})
+	combinations, bounds = solver.presolve(available)
+	if assert.Len(t, combinations, 1) {
+		assert.Equal(t, 2, bounds[0])
+	}
+
+	// the incidence index agrees with the combinations.
+	for j, c := range solver.combinations {
+		n := 0
+		for _, inc := range solver.bricksByCombination[j] {
+			assert.Equal(t, countBrickOccurrence(c.getBricks(), solver.uniqueBricks[inc.index]), inc.count)
+			n += inc.count
+		}
+		assert.Equal(t, len(c.getBricks()), n)
+	}
+}
+
+// benchmarkSolve solves a typical mid-game turn: a dozen combinations on the table and a hand of fourteen bricks.
+func benchmarkSolve(b *testing.B, options SolverOptions) {
+	solver := NewILPSolverWithOptions(NewDefaultRules(), options)
+	table := []BrickCombination{
+		NewBrickCombination(Brick{Value: 1, Color: "red"}, Brick{Value: 2, Color: "red"}, Brick{Value: 3, Color: "red"}),
+		NewBrickCombination(Brick{Value: 7, Color: "red"}, Brick{Value: 8, Color: "red"}, Brick{Value: 9, Color: "red"}, Brick{Value: 10, Color: "red"}),
+		NewBrickCombination(Brick{Value: 4, Color: "blue"}, Brick{Value: 5, Color: "blue"}, Brick{Value: 6, Color: "blue"}),
+		NewBrickCombination(Brick{Value: 11, Color: "green"}, Brick{Value: 12, Color: "green"}, Brick{Value: 13, Color: "green"}),
+		NewBrickCombination(Brick{Value: 5, Color: "red"}, Brick{Value: 5, Color: "green"}, Brick{Value: 5, Color: "yellow"}),
+		NewBrickCombination(Brick{Value: 9, Color: "blue"}, Brick{Value: 9, Color: "green"}, Brick{Value: 9, Color: "yellow"}, Brick{Value: 9, Color: "red"}),
+		NewBrickCombination(Brick{Value: 2, Color: "yellow"}, Brick{Value: 3, Color: "yellow"}, MakeJoker()),
+		NewBrickCombination(Brick{Value: 12, Color: "red"}, Brick{Value: 12, Color: "blue"}, Brick{Value: 12, Color: "yellow"}),
+	}
+	hand := []Brick{
+		{Value: 4, Color: "red"}, {Value: 11, Color: "red"}, {Value: 1, Color: "blue"}, {Value: 3, Color: "blue"}, {Value: 7, Color: "blue"},
+		{Value: 10, Color: "blue"}, {Value: 1, Color: "green"}, {Value: 6, Color: "green"}, {Value: 8, Color: "green"}, {Value: 5, Color: "yellow"},
+		{Value: 6, Color: "yellow"}, {Value: 13, Color: "yellow"}, {Value: 13, Color: "red"}, MakeJoker(),
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := solver.Solve(hand, table, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSolver_Presolve(b *testing.B) {
+	benchmarkSolve(b, DefaultSolverOptions())
+}
+
+// BenchmarkSolver_NoPresolve adds every combination in the space to the model, as Solve used to.
+func BenchmarkSolver_NoPresolve(b *testing.B) {
+	benchmarkSolve(b, SolverOptions{DisablePresolve: true})
+}
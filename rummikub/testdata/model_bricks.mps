@@ -0,0 +1,104 @@
+* Rummikub turn: maximize the number of bricks put on the table
+NAME rummikub
+OBJSENSE
+    MAX
+ROWS
+ N  obj
+ E  bricks_red_1
+ E  bricks_red_2
+ E  bricks_red_3
+ E  bricks_red_5
+ E  bricks_green_5
+ E  bricks_blue_5
+ E  bricks_blue_9
+ E  bricks_joker_1
+COLUMNS
+    MARKER0  'MARKER'  'INTORG'
+    combi_29  bricks_red_5  1
+    combi_29  bricks_green_5  1
+    combi_29  bricks_blue_5  1
+    combi_84  bricks_red_5  1
+    combi_84  bricks_green_5  1
+    combi_84  bricks_blue_5  1
+    combi_84  bricks_joker_1  1
+    combi_141  bricks_green_5  1
+    combi_141  bricks_blue_5  1
+    combi_141  bricks_joker_1  1
+    combi_142  bricks_red_5  1
+    combi_142  bricks_blue_5  1
+    combi_142  bricks_joker_1  1
+    combi_143  bricks_red_5  1
+    combi_143  bricks_green_5  1
+    combi_143  bricks_joker_1  1
+    combi_195  bricks_red_1  1
+    combi_195  bricks_red_2  1
+    combi_195  bricks_red_3  1
+    combi_315  bricks_red_2  1
+    combi_315  bricks_red_3  1
+    combi_315  bricks_joker_1  1
+    combi_316  bricks_red_1  1
+    combi_316  bricks_red_3  1
+    combi_316  bricks_joker_1  1
+    combi_317  bricks_red_1  1
+    combi_317  bricks_red_2  1
+    combi_317  bricks_joker_1  1
+    combi_321  bricks_red_3  1
+    combi_321  bricks_red_5  1
+    combi_321  bricks_joker_1  1
+    combi_341  bricks_red_1  1
+    combi_341  bricks_red_2  1
+    combi_341  bricks_red_3  1
+    combi_341  bricks_joker_1  1
+    combi_344  bricks_red_2  1
+    combi_344  bricks_red_3  1
+    combi_344  bricks_red_5  1
+    combi_344  bricks_joker_1  1
+    combi_372  bricks_red_1  1
+    combi_372  bricks_red_2  1
+    combi_372  bricks_red_3  1
+    combi_372  bricks_red_5  1
+    combi_372  bricks_joker_1  1
+    red_1  obj  1
+    red_1  bricks_red_1  -1
+    red_2  obj  1
+    red_2  bricks_red_2  -1
+    red_3  obj  1
+    red_3  bricks_red_3  -1
+    red_5  obj  1
+    red_5  bricks_red_5  -1
+    green_5  obj  1
+    green_5  bricks_green_5  -1
+    blue_5  obj  1
+    blue_5  bricks_blue_5  -1
+    blue_9  obj  1
+    blue_9  bricks_blue_9  -1
+    joker_1  obj  1
+    joker_1  bricks_joker_1  -1
+    MARKER1  'MARKER'  'INTEND'
+RHS
+    RHS  bricks_red_5  1
+    RHS  bricks_green_5  1
+    RHS  bricks_blue_5  1
+BOUNDS
+ UP BND  combi_29  1
+ UP BND  combi_84  1
+ UP BND  combi_141  1
+ UP BND  combi_142  1
+ UP BND  combi_143  1
+ UP BND  combi_195  1
+ UP BND  combi_315  1
+ UP BND  combi_316  1
+ UP BND  combi_317  1
+ UP BND  combi_321  1
+ UP BND  combi_341  1
+ UP BND  combi_344  1
+ UP BND  combi_372  1
+ UP BND  red_1  1
+ UP BND  red_2  1
+ UP BND  red_3  1
+ UP BND  red_5  0
+ UP BND  green_5  0
+ UP BND  blue_5  0
+ UP BND  blue_9  1
+ UP BND  joker_1  1
+ENDATA
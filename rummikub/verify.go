@@ -0,0 +1,130 @@
+package rummikub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrInvalidSolution is returned by VerifySolution if the arrangement returned by a solver can not be played.
+var ErrInvalidSolution = errors.New("the solver's arrangement can not be played")
+
+// VerifySolution checks the arrangement and the bricks to put returned by a solver for the hand and the table,
+// independently of the solver: each combination in the arrangement must be legal (see Rules.IsLegalCombination),
+// all bricks on the table must be in it, and the others must be exactly the bricks to put, which must be in the hand.
+// Returns an error wrapping ErrInvalidSolution if the arrangement fails any of these checks.
+// Note that the first move rules are not checked (see Rules.CheckFirstMove).
+func VerifySolution(rules Rules, hand []Brick, table []BrickCombination, arrangement []BrickCombination, bricksToPut []Brick) error {
+	for _, c := range arrangement {
+		if err := rules.IsLegalCombination(c); err != nil {
+			return fmt.Errorf("%w: combination %v: %v", ErrInvalidSolution, c.Bricks, err)
+		}
+	}
+
+	tableBricks := DissolveCombinations(table)
+	arrangementBricks := DissolveCombinations(arrangement)
+	if taken := BrickSliceDiff(arrangementBricks, tableBricks); len(taken) > 0 {
+		return fmt.Errorf("%w: bricks %v were taken from the table", ErrInvalidSolution, taken)
+	}
+
+	placed := BrickSliceDiff(tableBricks, arrangementBricks)
+	if len(BrickSliceDiff(placed, bricksToPut)) > 0 || len(BrickSliceDiff(bricksToPut, placed)) > 0 {
+		return fmt.Errorf("%w: bricks %v were put on the table, not %v", ErrInvalidSolution, placed, bricksToPut)
+	}
+	if notInHand := BrickSliceDiff(hand, placed); len(notInHand) > 0 {
+		return fmt.Errorf("%w: bricks %v are not in the hand", ErrInvalidSolution, notInHand)
+	}
+	return nil
+}
+
+// InvalidSolution is a position for which a solver returned an arrangement that failed verification (see VerifySolution),
+// along with that arrangement. It holds everything needed to reproduce the failure (see Reproduce), and is logged as JSON.
+type InvalidSolution struct {
+	Rules         Rules              `json:"rules"`
+	Hand          []Brick            `json:"hand"`
+	Table         []BrickCombination `json:"table"`
+	MaximizeValue bool               `json:"maximize_value"`
+	FirstMove     bool               `json:"first_move"`
+
+	Arrangement []BrickCombination `json:"arrangement"`
+	BricksToPut []Brick            `json:"bricks_to_put"`
+
+	// why the arrangement failed verification.
+	Reason string `json:"reason"`
+
+	err error
+}
+
+// Error returns the reason the arrangement failed verification.
+func (c *InvalidSolution) Error() string {
+	return c.Reason
+}
+
+// Unwrap returns the error of VerifySolution (or the game's reason to reject the move), which wraps ErrInvalidSolution.
+func (c *InvalidSolution) Unwrap() error {
+	return c.err
+}
+
+// String returns the case as JSON.
+func (c *InvalidSolution) String() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("%#v", *c)
+	}
+	return string(data)
+}
+
+// Reproduce runs the solver on the position again (using SolveFirstMove on a first move), and verifies its arrangement.
+// Returns an error wrapping ErrInvalidSolution if it fails verification again, or the solver's error.
+func (c *InvalidSolution) Reproduce(solver Solver) error {
+	var arrangement []BrickCombination
+	var bricks []Brick
+	var err error
+	if c.FirstMove {
+		arrangement, bricks, err = SolveFirstMove(solver, c.Rules, c.Hand, c.Table, c.MaximizeValue)
+	} else {
+		arrangement, bricks, err = solver.Solve(c.Hand, c.Table, c.MaximizeValue)
+	}
+	if err != nil {
+		return err
+	}
+	return VerifySolution(c.Rules, c.Hand, c.Table, arrangement, bricks)
+}
+
+// LogInvalidSolution is called with each position for which an AI player's solver returned an arrangement that failed verification,
+// after which the player forfeits its turn instead. It logs the case using the standard logger, unless replaced (e.g. by the server,
+// to log it with its own logger). It must be safe for concurrent use.
+var LogInvalidSolution = func(c *InvalidSolution) {
+	log.Printf("AI player's solver returned an invalid arrangement (%v). Forfeiting instead. Reproducible case: %v", c.Reason, c.String())
+}
+
+// verifyResult verifies the result of a solver, returning the case if it fails verification, or nil if it does not.
+func verifyResult(rules Rules, hand []Brick, table []BrickCombination, maximizeValue bool, firstMove bool, result SolveResult) *InvalidSolution {
+	err := VerifySolution(rules, hand, table, result.Arrangement, result.BricksToPut)
+	if err == nil {
+		return nil
+	}
+	return newInvalidSolution(rules, hand, table, maximizeValue, firstMove, result, err)
+}
+
+// RejectedMove returns the case of an AI move that passed verification, but was not accepted by the game for the reason err
+// (e.g. because it does not satisfy the first move rules), to be logged with LogInvalidSolution.
+func (r *AIMoveRequest) RejectedMove(move Move, err error) *InvalidSolution {
+	result := SolveResult{Arrangement: move.Arrangement, BricksToPut: BrickSliceDiff(DissolveCombinations(r.table), move.Bricks())}
+	return newInvalidSolution(r.rules, r.player.Hand(), r.table, r.firstMove, r.firstMove, result, fmt.Errorf("%w: %v", ErrInvalidSolution, err))
+}
+
+func newInvalidSolution(rules Rules, hand []Brick, table []BrickCombination, maximizeValue bool, firstMove bool, result SolveResult, err error) *InvalidSolution {
+	return &InvalidSolution{
+		Rules:         rules,
+		Hand:          append([]Brick{}, hand...),
+		Table:         append([]BrickCombination{}, table...),
+		MaximizeValue: maximizeValue,
+		FirstMove:     firstMove,
+		Arrangement:   result.Arrangement,
+		BricksToPut:   result.BricksToPut,
+		Reason:        err.Error(),
+		err:           err,
+	}
+}
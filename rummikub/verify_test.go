@@ -0,0 +1,137 @@
+package rummikub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bogusSolver plays all of its hand as a single combination, whether that is legal or not.
+type bogusSolver struct{}
+
+func (s *bogusSolver) Solve(hand []Brick, table []BrickCombination, maximizeValue bool) ([]BrickCombination, []Brick, error) {
+	return append(append([]BrickCombination{}, table...), NewBrickCombination(hand...)), hand, nil
+}
+
+func TestVerifySolution(t *testing.T) {
+	rules := NewDefaultRules()
+	table := contextTestTable
+	run := NewBrickCombination(Brick{Value: 1, Color: "red"}, Brick{Value: 2, Color: "red"}, Brick{Value: 3, Color: "red"})
+	hand := append([]Brick{{Value: 9, Color: "blue"}}, run.Bricks...)
+
+	arrangement, bricksToPut, err := NewILPSolver(rules).Solve(hand, table, false)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifySolution(rules, hand, table, arrangement, bricksToPut))
+	assert.NoError(t, VerifySolution(rules, hand, table, table, []Brick{}), "a forfeit is always valid")
+
+	invalid := map[string]struct {
+		arrangement []BrickCombination
+		bricksToPut []Brick
+	}{
+		"illegal combination":      {append([]BrickCombination{table[0]}, NewBrickCombination(append(run.Bricks, hand[0])...)), hand},
+		"bricks taken from table":  {[]BrickCombination{run}, run.Bricks},
+		"other bricks put":         {[]BrickCombination{table[0], run}, run.Bricks[:2]},
+		"bricks not in the hand":   {[]BrickCombination{table[0], run, run}, append(run.Bricks, run.Bricks...)},
+		"combination not in rules": {[]BrickCombination{table[0], NewBrickCombination(Brick{Value: 14, Color: "red"}, Brick{Value: 15, Color: "red"}, Brick{Value: 16, Color: "red"})}, []Brick{}},
+	}
+	for name, c := range invalid {
+		err := VerifySolution(rules, hand, table, c.arrangement, c.bricksToPut)
+		assert.True(t, errors.Is(err, ErrInvalidSolution), "%v: %v", name, err)
+	}
+}
+
+func TestPlayer_MakeMove_InvalidSolution(t *testing.T) {
+	var logged []*InvalidSolution
+	defer func(log func(*InvalidSolution)) { LogInvalidSolution = log }(LogInvalidSolution)
+	LogInvalidSolution = func(c *InvalidSolution) { logged = append(logged, c) }
+
+	rules := NewDefaultRules()
+	hand := []Brick{{Value: 1, Color: "red"}, {Value: 5, Color: "blue"}, {Value: 9, Color: "yellow"}}
+	player := NewAIPlayer("AI", &bogusSolver{})
+	player.SetHand(hand)
+
+	// the bogus arrangement is not played: the player forfeits instead.
+	var move Move
+	assert.NotPanics(t, func() { move = player.MakeMove(contextTestTable, rules, false) })
+	assert.Equal(t, NewMove("AI", contextTestTable), move)
+
+	// the position is logged as a case that can be reproduced.
+	if !assert.Len(t, logged, 1) {
+		return
+	}
+	assert.True(t, errors.Is(logged[0], ErrInvalidSolution))
+	var c InvalidSolution
+	assert.NoError(t, json.Unmarshal([]byte(logged[0].String()), &c))
+	assert.Equal(t, hand, c.Hand)
+	assert.Equal(t, contextTestTable, c.Table)
+	assert.False(t, c.FirstMove)
+	assert.Len(t, c.Arrangement, 2)
+	assert.True(t, errors.Is(c.Reproduce(&bogusSolver{}), ErrInvalidSolution), "the case can not be reproduced")
+	assert.NoError(t, c.Reproduce(NewILPSolver(c.Rules)))
+}
+
+func TestGame_RunAITurns_InvalidSolution(t *testing.T) {
+	defer func(log func(*InvalidSolution)) { LogInvalidSolution = log }(LogInvalidSolution)
+	LogInvalidSolution = func(c *InvalidSolution) {}
+
+	playerA := NewAIPlayer("A", &bogusSolver{})
+	playerA.SetHand([]Brick{{Value: 1, Color: "red"}, {Value: 5, Color: "blue"}, {Value: 9, Color: "yellow"}})
+	playerB := NewHumanPlayer("B")
+	playerB.SetHand([]Brick{{Value: 1, Color: "green"}})
+	game, err := NewEmptyGame(NewDefaultRules(), playerA, playerB)
+	assert.NoError(t, err)
+
+	// the AI player forfeits instead of crashing the game, after which it is the human player's turn.
+	assert.NotPanics(t, func() { assert.NoError(t, game.RunAITurns()) })
+	assert.Equal(t, "B", game.CurrentPlayer().Name)
+	assert.Empty(t, game.Table())
+}
+
+func TestGame_RunAITurns_Forfeit(t *testing.T) {
+	var logged []*InvalidSolution
+	defer func(log func(*InvalidSolution)) { LogInvalidSolution = log }(LogInvalidSolution)
+	LogInvalidSolution = func(c *InvalidSolution) { logged = append(logged, c) }
+
+	playerA := NewAIPlayer("A", &bogusSolver{})
+	playerA.SetHand([]Brick{{Value: 1, Color: "red"}, {Value: 5, Color: "blue"}, {Value: 9, Color: "yellow"}})
+	playerB := NewHumanPlayer("B")
+	playerB.SetHand([]Brick{{Value: 1, Color: "green"}})
+	playerC := NewHumanPlayer("C")
+	playerC.SetHand([]Brick{{Value: 1, Color: "blue"}})
+	game, err := NewEmptyGame(NewDefaultRules(), playerA, playerB, playerC)
+	assert.NoError(t, err)
+	pileSize := len(game.Pile)
+
+	// the rejected move is logged, after which the AI player forfeits its turn: it draws a brick, and the turn passes to the next player.
+	assert.NoError(t, game.RunAITurns())
+	assert.Len(t, logged, 1)
+	assert.Len(t, game.Players[0].Hand(), 4)
+	assert.Len(t, game.Pile, pileSize-1)
+	assert.Equal(t, "B", game.CurrentPlayer().Name)
+	if assert.Len(t, game.MoveHistory, 1) {
+		assert.Equal(t, "A", game.MoveHistory[0].PlayerName)
+		assert.Empty(t, game.MoveHistory[0].Arrangement)
+	}
+}
+
+func TestPortfolioSolver_InvalidSolution(t *testing.T) {
+	var logged int
+	defer func(log func(*InvalidSolution)) { LogInvalidSolution = log }(LogInvalidSolution)
+	LogInvalidSolution = func(c *InvalidSolution) { logged++ }
+
+	// the bogus engine claims to have put the entire hand on the table (an optimum), but the greedy engine wins.
+	rules := NewDefaultRules()
+	solver := NewPortfolioSolver(rules,
+		PortfolioEngine{Name: "bogus", Solver: AdaptSolver(&bogusSolver{})},
+		PortfolioEngine{Name: "greedy", Solver: NewGreedySolver(rules)},
+	)
+	hand := append([]Brick{{Value: 9, Color: "blue"}}, contextTestHand...)
+	result, err := solver.SolveContext(context.Background(), time.Second, hand, contextTestTable, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "greedy", result.Engine)
+	assert.Equal(t, 1, logged)
+}
@@ -0,0 +1,171 @@
+package rummikub
+
+import "fmt"
+
+// Outcome is a stable, machine-readable identifier for the result of successfully processing a move.
+type Outcome string
+
+// Move processing outcomes.
+const (
+	LEGAL_MOVE Outcome = "legal_move"
+	FORFEITED  Outcome = "forfeited"
+	GAME_WON   Outcome = "game_won"
+)
+
+// ViolationCode is a stable, machine-readable identifier for a broken game rule.
+// It implements the error interface so that it can be matched against a RuleViolation, RulesError or PlayerError with errors.Is,
+// e.g. errors.Is(err, NOT_OWNED) or errors.Is(err, UNKNOWN_PLAYER).
+type ViolationCode string
+
+// Move-level rule violations.
+const (
+	NOT_YOUR_TURN      ViolationCode = "not_your_turn"
+	NOT_OWNED          ViolationCode = "not_owned"
+	BRICKS_REMOVED     ViolationCode = "bricks_removed"
+	VALUE_INSUFFICIENT ViolationCode = "value_insufficient"
+	GAME_OVER          ViolationCode = "game_over"
+	TABLE_REARRANGED   ViolationCode = "table_rearranged"
+)
+
+// Combination-level rule violations (matching BrickCombinations to game rules).
+const (
+	TOO_MANY_JOKERS_IN_COMBINATION ViolationCode = "too_many_jokers_in_combination"
+	ILLEGAL_COMBINATION            ViolationCode = "illegal_combination"
+	VALUE_OUT_OF_BOUNDS            ViolationCode = "value_out_of_bounds"
+	UNKNOWN_COLOR                  ViolationCode = "unknown_color"
+	INVALID_JOKER                  ViolationCode = "invalid_joker"
+)
+
+// Run and group violations (matching BrickCombinations to the definitions of a run and a group).
+const (
+	COMBINATION_TOO_SMALL     ViolationCode = "combination_too_small"
+	CONTAINS_ONLY_JOKERS      ViolationCode = "contains_only_jokers"
+	CONTAINS_MULTIPLE_VALUES  ViolationCode = "contains_multiple_values"
+	CONTAINS_MULTIPLE_COLORS  ViolationCode = "contains_multiple_colors"
+	CONTAINS_DUPLICATE_VALUES ViolationCode = "contains_duplicate_values"
+	COLORS_NOT_UNIQUE         ViolationCode = "colors_not_unique"
+	NOT_CONSECUTIVE           ViolationCode = "not_consecutive"
+)
+
+// Rules violations (why a Rules struct does not describe a playable game, see RulesError).
+const (
+	NOT_ENOUGH_PLAYERS            ViolationCode = "not_enough_players"
+	NO_VALUES                     ViolationCode = "no_values"
+	NO_COLORS                     ViolationCode = "no_colors"
+	EMPTY_COLOR                   ViolationCode = "empty_color"
+	DUPLICATE_COLOR               ViolationCode = "duplicate_color"
+	RESERVED_COLOR                ViolationCode = "reserved_color"
+	NO_REPLICATES                 ViolationCode = "no_replicates"
+	NEGATIVE_JOKERS               ViolationCode = "negative_jokers"
+	JOKERS_PER_COMBINATION_EXCEED ViolationCode = "jokers_per_combination_exceed"
+	NO_STARTING_HAND              ViolationCode = "no_starting_hand"
+	PILE_TOO_SMALL                ViolationCode = "pile_too_small"
+	NEGATIVE_FIRST_MOVE_VALUE     ViolationCode = "negative_first_move_value"
+	UNKNOWN_JOKER_VALUATION       ViolationCode = "unknown_joker_valuation"
+)
+
+// Player violations (why a player is rejected by the game, see PlayerError).
+const (
+	DUPLICATE_PLAYER_NAME ViolationCode = "duplicate_player_name"
+	UNKNOWN_PLAYER        ViolationCode = "unknown_player"
+	ALREADY_RESIGNED      ViolationCode = "already_resigned"
+	LAST_PLAYER           ViolationCode = "last_player"
+	NOT_HUMAN             ViolationCode = "not_human"
+)
+
+// human-readable descriptions of the violation codes.
+var violationMessages = map[ViolationCode]string{
+	NOT_YOUR_TURN:      "player named in the move object does not correspond to the name of the current player",
+	NOT_OWNED:          "there are new bricks in the proposed combination that are not in the player's hand",
+	BRICKS_REMOVED:     "bricks were removed from the field",
+	VALUE_INSUFFICIENT: "cumulative value of bricks insufficient",
+	GAME_OVER:          "the game has already been won",
+	TABLE_REARRANGED:   "the combinations on the table may not be changed on a first move",
+
+	TOO_MANY_JOKERS_IN_COMBINATION: "Too many Jokers in combination",
+	ILLEGAL_COMBINATION:            "Combination is neither a valid group or a valid run",
+	VALUE_OUT_OF_BOUNDS:            "Brick value invalid: brick value outside of game bounds",
+	UNKNOWN_COLOR:                  "Brick color was not found in game rules",
+	INVALID_JOKER:                  "Joker does not stand in for a brick that fits the combination",
+
+	COMBINATION_TOO_SMALL:     "combination is smaller than 3",
+	CONTAINS_ONLY_JOKERS:      "combination contains only jokers",
+	CONTAINS_MULTIPLE_VALUES:  "not a group: combination contains more than one unique value",
+	CONTAINS_MULTIPLE_COLORS:  "not a run: combination contains uniqueBricks of more than one color",
+	CONTAINS_DUPLICATE_VALUES: "not a run: combination contains duplicate values",
+	COLORS_NOT_UNIQUE:         "not a group: combination contains duplicates of a color",
+	NOT_CONSECUTIVE:           "not a run: brick values not consecutive or semi-consecutive (i.e. with joker)",
+
+	NOT_ENOUGH_PLAYERS:            "a game needs at least one player",
+	NO_VALUES:                     "the play set needs at least one brick value",
+	NO_COLORS:                     "the play set needs at least one color",
+	EMPTY_COLOR:                   "colors may not be empty strings",
+	DUPLICATE_COLOR:               "colors must be unique",
+	RESERVED_COLOR:                "the joker color is reserved",
+	NO_REPLICATES:                 "the play set needs at least one replicate of each brick",
+	NEGATIVE_JOKERS:               "the number of jokers may not be negative",
+	JOKERS_PER_COMBINATION_EXCEED: "more jokers allowed per combination than there are jokers in play",
+	NO_STARTING_HAND:              "the starting hand must contain at least one brick",
+	PILE_TOO_SMALL:                "not enough bricks in the play set to fill the starting hand of each player",
+	NEGATIVE_FIRST_MOVE_VALUE:     "the first move value may not be negative",
+	UNKNOWN_JOKER_VALUATION:       "unknown joker valuation",
+
+	DUPLICATE_PLAYER_NAME: "player names must be unique",
+	UNKNOWN_PLAYER:        "no player with this name takes part in the game",
+	ALREADY_RESIGNED:      "the player has already resigned",
+	LAST_PLAYER:           "the last player in the game can not resign",
+	NOT_HUMAN:             "only human players can request hints",
+}
+
+func (c ViolationCode) Error() string {
+	if msg, ok := violationMessages[c]; ok {
+		return msg
+	}
+	return string(c)
+}
+
+// NO_COMBINATION is the RuleViolation.Combination index of violations that do not concern a single combination.
+const NO_COMBINATION = -1
+
+// RuleViolation is the error returned when a move or a combination breaks the game rules.
+// It is serializable so that it can be sent down to clients as-is.
+type RuleViolation struct {
+	Code    ViolationCode `json:"code"`
+	Message string        `json:"message"`
+
+	// index of the offending combination in the proposed arrangement, or NO_COMBINATION.
+	Combination int `json:"combination"`
+
+	// the offending bricks (e.g. the bricks that are not owned, or the bricks of the offending combination).
+	Bricks []Brick `json:"bricks,omitempty"`
+}
+
+func newViolation(code ViolationCode, bricks ...Brick) *RuleViolation {
+	return &RuleViolation{
+		Code:        code,
+		Message:     code.Error(),
+		Combination: NO_COMBINATION,
+		Bricks:      bricks,
+	}
+}
+
+func (v *RuleViolation) Error() string {
+	if v.Combination != NO_COMBINATION {
+		return fmt.Sprintf("%v (combination %v: %v)", v.Message, v.Combination, v.Bricks)
+	}
+	if len(v.Bricks) > 0 {
+		return fmt.Sprintf("%v: %v", v.Message, v.Bricks)
+	}
+	return v.Message
+}
+
+// Is reports whether the violation carries the target ViolationCode, or equals the target RuleViolation's code.
+func (v *RuleViolation) Is(target error) bool {
+	switch t := target.(type) {
+	case ViolationCode:
+		return v.Code == t
+	case *RuleViolation:
+		return v.Code == t.Code
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package rummikub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleViolation_Is(t *testing.T) {
+	violation := newViolation(NOT_OWNED, Brick{Color: "green", Value: 5})
+
+	// match on the code, regardless of the attached details and of any wrapping.
+	assert.True(t, errors.Is(violation, NOT_OWNED), "violation does not match its own code")
+	assert.True(t, errors.Is(violation, &RuleViolation{Code: NOT_OWNED}), "violation does not match a violation with the same code")
+	assert.True(t, errors.Is(fmt.Errorf("wrapped: %w", violation), NOT_OWNED), "wrapped violation does not match its own code")
+	assert.False(t, errors.Is(violation, BRICKS_REMOVED), "violation matches a different code")
+}
+
+func TestRuleViolation_Serialize(t *testing.T) {
+	combination := NewBrickCombination(Brick{Color: "green", Value: 1}, Brick{Color: "green", Value: 1})
+	violation := combination.violation(COMBINATION_TOO_SMALL)
+	violation.Combination = 2
+
+	serialized, err := json.Marshal(violation)
+	assert.NoError(t, err, "error serializing violation")
+
+	var deserialized RuleViolation
+	assert.NoError(t, json.Unmarshal(serialized, &deserialized), "error deserializing violation")
+	assert.Equal(t, *violation, deserialized, "violation does not survive serialization")
+	assert.Equal(t, COMBINATION_TOO_SMALL.Error(), deserialized.Message, "human-readable message not included")
+}
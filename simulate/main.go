@@ -0,0 +1,189 @@
+// Command simulate plays games between AI players, and prints the outcome of each game along with the solver statistics:
+// the solve time, and the hit rate and time saved of the solution cache shared by all players.
+//
+// Usage:
+//
+//	simulate [-games n] [-players n] [-seed n] [-cache size] [-baseline] [-portfolio]
+//
+// With -baseline, the games are played again without the cache, to measure the speedup.
+// With -portfolio, the players race the ILP solver against the greedy solver (see rummikub.PortfolioSolver),
+// and the number of turns each of them won is reported.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+// config is the set of games to simulate.
+type config struct {
+	games   int
+	players int
+	seed    int64 // of the first game; the next games are seeded with the next numbers.
+
+	// the size of the solution cache. No cache is used if not positive.
+	cacheSize int
+
+	// whether the players race the ILP solver against the greedy solver.
+	portfolio bool
+}
+
+// gameResult is the outcome of a simulated game.
+type gameResult struct {
+	seed      int64
+	winner    string // empty if the game was not won.
+	turns     int
+	solveTime time.Duration
+}
+
+// simulation is the outcome of a set of simulated games.
+type simulation struct {
+	games     []gameResult
+	solveTime time.Duration
+	cache     *rummikub.SolutionCache // nil if no cache was used.
+	wins      map[string]int          // the number of turns each engine won, by name. Empty if no portfolio was used.
+}
+
+func main() {
+	c := config{}
+	flag.IntVar(&c.games, "games", 10, "the number of games to play")
+	flag.IntVar(&c.players, "players", 2, "the number of AI players per game")
+	flag.Int64Var(&c.seed, "seed", 1, "the seed of the first game")
+	flag.IntVar(&c.cacheSize, "cache", rummikub.DefaultSolutionCacheSize, "the number of turns in the solution cache (0 disables it)")
+	flag.BoolVar(&c.portfolio, "portfolio", false, "race the ILP solver against the greedy solver, and report which one won each turn")
+	baseline := flag.Bool("baseline", false, "play the games again without the solution cache, and report the speedup")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [-games n] [-players n] [-seed n] [-cache size] [-baseline] [-portfolio]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	sim, err := simulate(c, rummikub.NewDefaultRules())
+	if err != nil {
+		fail(err)
+	}
+	report(os.Stdout, sim)
+
+	if *baseline {
+		c.cacheSize = 0
+		base, err := simulate(c, rummikub.NewDefaultRules())
+		if err != nil {
+			fail(err)
+		}
+		reportSpeedup(os.Stdout, sim, base)
+	}
+}
+
+// simulate plays the games. All AI players share the solution cache (if any), but each has a solver of its own.
+func simulate(c config, rules rummikub.Rules) (*simulation, error) {
+	if err := rules.Validate(c.players); err != nil {
+		return nil, err
+	}
+
+	options := rummikub.DefaultSolverOptions()
+	sim := &simulation{games: []gameResult{}, wins: make(map[string]int)}
+	if c.cacheSize > 0 {
+		sim.cache = rummikub.NewSolutionCache(c.cacheSize)
+		options.Cache = sim.cache
+	}
+
+	for g := 0; g < c.games; g++ {
+		players := make([]rummikub.Player, c.players)
+		portfolios := []*rummikub.PortfolioSolver{}
+		for p := range players {
+			ilp := rummikub.NewILPSolverWithOptions(rules, options)
+			var solver rummikub.Solver = ilp
+			if c.portfolio {
+				portfolio := rummikub.NewPortfolioSolver(rules,
+					rummikub.PortfolioEngine{Name: "ilp", Solver: ilp},
+					rummikub.PortfolioEngine{Name: "greedy", Solver: rummikub.NewGreedySolver(rules)},
+				)
+				portfolios = append(portfolios, portfolio)
+				solver = portfolio
+			}
+			players[p] = rummikub.NewAIPlayer(fmt.Sprintf("AI %v", p+1), solver)
+		}
+
+		result := gameResult{seed: c.seed + int64(g)}
+		game, err := rummikub.NewGame(rules, result.seed, players...)
+		if err != nil {
+			return nil, err
+		}
+		err = game.RunAITurnsObserved(func(turn rummikub.AITurn) {
+			result.turns++
+			result.solveTime += turn.SolveTime
+		})
+		if err != nil {
+			return nil, fmt.Errorf("game %v: %w", result.seed, err)
+		}
+		if winner := game.Winner(); winner != nil {
+			result.winner = winner.Name
+		}
+
+		sim.games = append(sim.games, result)
+		sim.solveTime += result.solveTime
+		for _, portfolio := range portfolios {
+			for name, n := range portfolio.Wins() {
+				sim.wins[name] += n
+			}
+		}
+	}
+	return sim, nil
+}
+
+// report writes the outcome of each game and the solver statistics.
+func report(w io.Writer, sim *simulation) {
+	for _, g := range sim.games {
+		winner := g.winner
+		if winner == "" {
+			winner = "nobody"
+		}
+		fmt.Fprintf(w, "game %v: won by %v after %v turns, solve time %v\n", g.seed, winner, g.turns, g.solveTime.Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "solve time: %v\n", sim.solveTime.Round(time.Millisecond))
+
+	if len(sim.wins) > 0 {
+		names := make([]string, 0, len(sim.wins))
+		for name := range sim.wins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprint(w, "engines:")
+		for i, name := range names {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, " %v won %v turns", name, sim.wins[name])
+		}
+		fmt.Fprintln(w)
+	}
+
+	if sim.cache == nil {
+		fmt.Fprintln(w, "cache: disabled")
+		return
+	}
+	stats := sim.cache.Stats()
+	fmt.Fprintf(w, "cache: %v hits, %v misses (hit rate %.1f%%), %v evictions, %v warm starts, saved %v\n",
+		stats.Hits, stats.Misses, 100*stats.HitRate(), stats.Evictions, stats.WarmStarts, stats.Saved.Round(time.Millisecond))
+}
+
+// reportSpeedup writes the speedup of the simulation over the baseline (the same games, played without the cache).
+// Note that the games may play out differently if the solvers break ties between optimal moves differently.
+func reportSpeedup(w io.Writer, sim *simulation, baseline *simulation) {
+	speedup := 0.0
+	if sim.solveTime > 0 {
+		speedup = float64(baseline.solveTime) / float64(sim.solveTime)
+	}
+	fmt.Fprintf(w, "baseline solve time: %v, speedup %.2fx\n", baseline.solveTime.Round(time.Millisecond), speedup)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jjhbarkeywolf/rummiGo/rummikub"
+)
+
+func TestSimulate(t *testing.T) {
+	// the rules are validated for the number of players.
+	_, err := simulate(config{games: 1, players: 0}, rummikub.NewDefaultRules())
+	assert.Error(t, err)
+
+	sim, err := simulate(config{games: 0, players: 2, cacheSize: 10}, rummikub.NewDefaultRules())
+	assert.NoError(t, err)
+	assert.Empty(t, sim.games)
+	assert.NotNil(t, sim.cache)
+}
+
+func TestSimulate_CacheHits(t *testing.T) {
+	// a small game, in which a turn comes up twice: the second time, it is found in the cache.
+	rules := rummikub.Rules{
+		Colors:               []string{"red", "green", "blue"},
+		Values:               6,
+		JokersPerCombination: 1,
+		JokersInPlay:         1,
+		Replicates:           2,
+		StartingHandSize:     5,
+		FirstMoveValue:       6,
+		FirstMoveHandOnly:    true,
+	}
+	sim, err := simulate(config{games: 1, players: 3, seed: 3, cacheSize: 100}, rules)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, sim.games, 1) {
+		return
+	}
+	assert.True(t, sim.games[0].turns > 0)
+	assert.NotEmpty(t, sim.games[0].winner)
+
+	stats := sim.cache.Stats()
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, stats.Misses, sim.cache.Len(), "each miss should have been added to the cache")
+
+	var out bytes.Buffer
+	report(&out, sim)
+	assert.Contains(t, out.String(), fmt.Sprintf("cache: 1 hits, %v misses", stats.Misses))
+}
+
+func TestReport(t *testing.T) {
+	sim := &simulation{
+		games: []gameResult{
+			{seed: 1, winner: "AI 2", turns: 40, solveTime: 1500 * time.Millisecond},
+			{seed: 2, turns: 12, solveTime: 500 * time.Millisecond},
+		},
+		solveTime: 2 * time.Second,
+	}
+
+	var out bytes.Buffer
+	report(&out, sim)
+	assert.Equal(t, "game 1: won by AI 2 after 40 turns, solve time 1.5s\n"+
+		"game 2: won by nobody after 12 turns, solve time 500ms\n"+
+		"solve time: 2s\n"+
+		"cache: disabled\n", out.String())
+
+	sim.cache = rummikub.NewSolutionCache(10)
+	out.Reset()
+	report(&out, sim)
+	assert.Contains(t, out.String(), "cache: 0 hits, 0 misses (hit rate 0.0%), 0 evictions, 0 warm starts, saved 0s\n")
+
+	sim.wins = map[string]int{"ilp": 30, "greedy": 10}
+	out.Reset()
+	report(&out, sim)
+	assert.Contains(t, out.String(), "solve time: 2s\nengines: greedy won 10 turns, ilp won 30 turns\n")
+
+	out.Reset()
+	reportSpeedup(&out, sim, &simulation{solveTime: 3 * time.Second})
+	assert.Equal(t, "baseline solve time: 3s, speedup 1.50x\n", out.String())
+}